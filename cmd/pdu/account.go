@@ -56,7 +56,6 @@ var accountCmd = &cobra.Command{
 		default:
 			return errUnknownOperation
 		}
-		return nil
 	},
 }
 
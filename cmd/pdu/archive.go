@@ -0,0 +1,106 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/archive"
+	"github.com/pdupub/go-pdu/params"
+	"github.com/spf13/cobra"
+)
+
+var errDataDirExist = errors.New("data dir already exist")
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the PDU Universe into archive",
+	RunE: func(_ *cobra.Command, args []string) error {
+		udb, err := openDataDir()
+		if err != nil {
+			return err
+		}
+		defer udb.Close()
+
+		f, err := os.OpenFile(archiveFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w := bufio.NewWriter(f)
+		if err := archive.Export(udb, w); err != nil {
+			os.Remove(archiveFile)
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Println(archiveFile, "is exported success.")
+		return nil
+	},
+}
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import the PDU Universe from archive into new data dir",
+	RunE: func(_ *cobra.Command, args []string) error {
+		f, err := os.Open(archiveFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := updateDataDir(); err != nil {
+			return err
+		}
+		if exist, err := pathExists(dataDir); err != nil {
+			return err
+		} else if exist {
+			return errDataDirExist
+		}
+		udb, err := initNodeDir()
+		if err != nil {
+			return err
+		}
+		count, err := archive.Import(udb, bufio.NewReader(f))
+		if err != nil {
+			udb.Close()
+			os.RemoveAll(dataDir)
+			return err
+		}
+		if err := udb.Close(); err != nil {
+			return err
+		}
+		fmt.Println(count, "messages imported into", dataDir)
+		return nil
+	},
+}
+
+func init() {
+	exportCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("(default $HOME/%s)", params.DefaultPath))
+	exportCmd.PersistentFlags().StringVarP(&archiveFile, "output", "o", "universe.pdu", "output archive file")
+	importCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("(default $HOME/%s)", params.DefaultPath))
+	importCmd.PersistentFlags().StringVar(&dbBackend, "db", db.DefaultBackend, dbBackendUsage)
+	importCmd.PersistentFlags().StringVarP(&archiveFile, "input", "i", "universe.pdu", "input archive file")
+	rootCmd.AddCommand(exportCmd, importCmd)
+}
@@ -0,0 +1,264 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/spf13/cobra"
+)
+
+var (
+	errBirthNameMissing    = errors.New("name of new user missing")
+	errBirthUserMissing    = errors.New("user id missing")
+	errBirthNotSigned      = errors.New("birth draft not signed by both parents")
+	errBirthSameGender     = errors.New("parents of birth draft are same gender")
+	errBirthParentSigned   = errors.New("parent of same gender already signed")
+	errBirthCannotCosign   = errors.New("user can not cosign in any space time")
+	errBirthPubKeyMismatch = errors.New("key not match the user")
+)
+
+// birthCmd represents the birth command
+var birthCmd = &cobra.Command{
+	Use:   "birth [draft/sign/submit]",
+	Short: "Create new user by birth msg",
+	Long: `Create new user by birth msg, which need three steps:
+draft  : new user create draft with its own key
+sign   : each parent cosign the draft with their own key
+submit : one of parents submit the draft as birth msg to the node`,
+}
+
+// birthDraftCmd represents the birth draft command
+var birthDraftCmd = &cobra.Command{
+	Use:   "draft",
+	Short: "Create birth draft for new user",
+	RunE: func(_ *cobra.Command, args []string) error {
+		if birthName == "" {
+			return errBirthNameMissing
+		}
+		_, pubKey, err := unlockKey(birthKeyFile, birthPassFile)
+		if err != nil {
+			return err
+		}
+		contentBirth, err := core.CreateContentBirth(birthName, birthExtra, &core.Auth{PublicKey: *pubKey})
+		if err != nil {
+			return err
+		}
+		if err := writeBirthDraft(birthDraft, contentBirth); err != nil {
+			return err
+		}
+		fmt.Println(birthDraft, "is created success.")
+		return nil
+	},
+}
+
+// birthSignCmd represents the birth sign command
+var birthSignCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Cosign birth draft as parent",
+	RunE: func(_ *cobra.Command, args []string) error {
+		contentBirth, err := readBirthDraft(birthDraft)
+		if err != nil {
+			return err
+		}
+		client := node.NewClient(birthURL)
+		priKey, user, err := unlockBirthUser(client, birthKeyFile, birthPassFile, birthUserID)
+		if err != nil {
+			return err
+		}
+		if err := checkCosign(client, user); err != nil {
+			return err
+		}
+		parent := contentBirth.Parents[0]
+		if user.Gender() {
+			parent = contentBirth.Parents[1]
+		}
+		if parent.UserID != (common.Hash{}) && parent.UserID != user.ID() {
+			return errBirthParentSigned
+		}
+		if err := contentBirth.SignByParent(user, *priKey); err != nil {
+			return err
+		}
+		if err := writeBirthDraft(birthDraft, contentBirth); err != nil {
+			return err
+		}
+		fmt.Println(birthDraft, "is signed by", common.Hash2String(user.ID()))
+		return nil
+	},
+}
+
+// birthSubmitCmd represents the birth submit command
+var birthSubmitCmd = &cobra.Command{
+	Use:   "submit",
+	Short: "Submit birth draft signed by both parents",
+	RunE: func(_ *cobra.Command, args []string) error {
+		contentBirth, err := readBirthDraft(birthDraft)
+		if err != nil {
+			return err
+		}
+		if contentBirth.Parents[0].UserID == (common.Hash{}) || contentBirth.Parents[1].UserID == (common.Hash{}) {
+			return errBirthNotSigned
+		}
+		client := node.NewClient(birthURL)
+		var parents [2]core.User
+		for i, p := range contentBirth.Parents {
+			if err := client.Call(&parents[i], node.MethodUser, common.Hash2String(p.UserID)); err != nil {
+				return err
+			}
+		}
+		if parents[0].Gender() == parents[1].Gender() {
+			return errBirthSameGender
+		}
+		priKey, user, err := unlockBirthUser(client, birthKeyFile, birthPassFile, birthUserID)
+		if err != nil {
+			return err
+		}
+		if user.ID() != contentBirth.Parents[0].UserID && user.ID() != contentBirth.Parents[1].UserID {
+			return core.ErrSenderNotParent
+		}
+		refs, err := birthReferences(client)
+		if err != nil {
+			return err
+		}
+		content, err := json.Marshal(contentBirth)
+		if err != nil {
+			return err
+		}
+		msg, err := core.CreateMsg(user, &core.MsgValue{ContentType: core.TypeBirth, Content: content}, priKey, refs...)
+		if err != nil {
+			return err
+		}
+		var newUserID, msgID string
+		if err := client.Call(&newUserID, node.MethodCheckBirth, msg); err != nil {
+			return err
+		}
+		if err := client.Call(&msgID, node.MethodSendMsg, msg); err != nil {
+			return err
+		}
+		fmt.Println("birth msg", msgID, "is submitted, new user", newUserID)
+		return nil
+	},
+}
+
+func readBirthDraft(draftFile string) (*core.ContentBirth, error) {
+	draftJSON, err := ioutil.ReadFile(draftFile)
+	if err != nil {
+		return nil, err
+	}
+	var contentBirth core.ContentBirth
+	if err := json.Unmarshal(draftJSON, &contentBirth); err != nil {
+		return nil, err
+	}
+	return &contentBirth, nil
+}
+
+func writeBirthDraft(draftFile string, contentBirth *core.ContentBirth) error {
+	draftJSON, err := json.MarshalIndent(contentBirth, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(draftFile, draftJSON, 0600)
+}
+
+// unlockBirthUser unlock the key and get the user from node, the key must match the user
+func unlockBirthUser(client *node.Client, keyFile, passFile, userID string) (*crypto.PrivateKey, *core.User, error) {
+	if userID == "" {
+		return nil, nil, errBirthUserMissing
+	}
+	priKey, pubKey, err := unlockKey(keyFile, passFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var user core.User
+	if err := client.Call(&user, node.MethodUser, userID); err != nil {
+		return nil, nil, err
+	}
+	p1, err := json.Marshal(user.Auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	p2, err := json.Marshal(core.Auth{PublicKey: *pubKey})
+	if err != nil {
+		return nil, nil, err
+	}
+	if common.Bytes2String(p1) != common.Bytes2String(p2) {
+		return nil, nil, errBirthPubKeyMismatch
+	}
+	return priKey, &user, nil
+}
+
+// checkCosign check if the user can cosign in at least one space time
+func checkCosign(client *node.Client, user *core.User) error {
+	var sts []*node.SpaceTimeInfo
+	if err := client.Call(&sts, node.MethodSpaceTimes); err != nil {
+		return err
+	}
+	for _, st := range sts {
+		var userInfo core.UserInfo
+		if err := client.Call(&userInfo, node.MethodUserInfo, common.Hash2String(user.ID()), st.ID); err != nil {
+			continue
+		}
+		if userInfo.CanCosign(st.MaxSeq) {
+			return nil
+		}
+	}
+	return errBirthCannotCosign
+}
+
+// birthReferences reference the last msg of each space time, so the birth
+// msg can be verified in all of them
+func birthReferences(client *node.Client) ([]*core.MsgReference, error) {
+	var sts []*node.SpaceTimeInfo
+	if err := client.Call(&sts, node.MethodSpaceTimes); err != nil {
+		return nil, err
+	}
+	var refs []*core.MsgReference
+	for _, st := range sts {
+		stID, err := common.String2Hash(st.ID)
+		if err != nil {
+			return nil, err
+		}
+		var msg core.Message
+		if err := client.Call(&msg, node.MethodLastMsgByUser, st.ID); err != nil {
+			continue
+		}
+		refs = append(refs, &core.MsgReference{SenderID: stID, MsgID: msg.ID()})
+	}
+	return refs, nil
+}
+
+func init() {
+	birthCmd.PersistentFlags().StringVar(&birthKeyFile, "key", "", "key file of user")
+	birthCmd.PersistentFlags().StringVar(&birthPassFile, "pass", "", "password file of key, input password if not set")
+	birthCmd.PersistentFlags().StringVar(&birthDraft, "draft", "birth.json", "birth draft file")
+	birthSignCmd.Flags().StringVar(&birthUserID, "user", "", "user id of parent")
+	birthSubmitCmd.Flags().StringVar(&birthUserID, "user", "", "user id of sender, which must be one of parents")
+	for _, cmd := range []*cobra.Command{birthSignCmd, birthSubmitCmd} {
+		cmd.Flags().StringVar(&birthURL, "url", fmt.Sprintf("http://%s:%d/node", node.DefaultAPIHost, node.DefaultAPIPort), "url of local api of node")
+	}
+	birthDraftCmd.Flags().StringVar(&birthName, "name", "", "name of new user")
+	birthDraftCmd.Flags().StringVar(&birthExtra, "extra", "", "birth extra of new user")
+	birthCmd.AddCommand(birthDraftCmd, birthSignCmd, birthSubmitCmd)
+	rootCmd.AddCommand(birthCmd)
+}
@@ -17,7 +17,12 @@
 package main
 
 import (
+	"fmt"
+	"path"
+
 	"github.com/pdupub/go-pdu/console"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/pdupub/go-pdu/params"
 	"github.com/spf13/cobra"
 )
 
@@ -31,6 +36,17 @@ var consoleCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		if err := updateDataDir(); err != nil {
+			return err
+		}
+		if exist, err := pathExists(dataDir); err != nil {
+			return err
+		} else if exist {
+			cls.SetHistoryPath(path.Join(dataDir, console.DefaultHistoryFile))
+		}
+		if consoleURL != "" {
+			cls.SetTargetURL(consoleURL)
+		}
 		cls.Run()
 
 		return nil
@@ -38,5 +54,7 @@ var consoleCmd = &cobra.Command{
 }
 
 func init() {
+	consoleCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("(default $HOME/%s)", params.DefaultPath))
+	consoleCmd.PersistentFlags().StringVar(&consoleURL, "url", fmt.Sprintf("http://%s:%d/node", node.DefaultAPIHost, node.DefaultAPIPort), "url of local api of node")
 	rootCmd.AddCommand(consoleCmd)
 }
@@ -246,5 +246,6 @@ func createRootUsers() (users []*core.User, priKeys []*crypto.PrivateKey, err er
 
 func init() {
 	createCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("(default $HOME/%s)", params.DefaultPath))
+	createCmd.PersistentFlags().StringVar(&dbBackend, "db", db.DefaultBackend, dbBackendUsage)
 	rootCmd.AddCommand(createCmd)
 }
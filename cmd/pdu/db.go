@@ -0,0 +1,191 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/backend"
+	"github.com/pdupub/go-pdu/db/memory"
+	"github.com/pdupub/go-pdu/params"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// backupDir is the dir in data dir to save the backup of db before migration
+const backupDir = "backup"
+
+var errDataDirNotExist = errors.New("data dir not exist")
+
+// dbCmd represents the db command
+var dbCmd = &cobra.Command{
+	Use:   "db [check/migrate]",
+	Short: "Maintain the database of PDU Universe",
+}
+
+// dbCheckCmd represents the db check command
+var dbCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the integrity of messages in database, repair the indexes if need",
+	RunE: func(_ *cobra.Command, args []string) error {
+		udb, err := openDataDir()
+		if err != nil {
+			return err
+		}
+		defer udb.Close()
+
+		res, err := db.CheckDB(udb)
+		if err != nil {
+			return err
+		}
+		printCheckResult(res)
+		if !dbRepair || len(res.Issues) == 0 {
+			return nil
+		}
+		if err := db.RepairDB(udb); err != nil {
+			return err
+		}
+		fmt.Println("Indexes of messages rebuilt, check again")
+		if res, err = db.CheckDB(udb); err != nil {
+			return err
+		}
+		printCheckResult(res)
+		return nil
+	},
+}
+
+// dbMigrateCmd represents the db migrate command
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the schema of database, which also run by start",
+	RunE: func(_ *cobra.Command, args []string) error {
+		udb, err := openDataDir()
+		if err != nil {
+			return err
+		}
+		defer udb.Close()
+
+		version, err := db.GetSchemaVersion(udb)
+		if err != nil {
+			return err
+		}
+		pending, err := db.PendingMigrations(udb, db.Migrations)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Schema version", version, "current version", db.SchemaVersion)
+		for _, m := range pending {
+			fmt.Println("Pending migration", m.Version, m.Name)
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if dbDryRun {
+			// run migrations on copy of db in memory, the db not changed
+			mdb := memory.NewDB()
+			defer mdb.Close()
+			if err := db.CopyDB(mdb, udb); err != nil {
+				return err
+			}
+			if _, err := db.Migrate(mdb, db.Migrations, nil); err != nil {
+				return err
+			}
+			fmt.Println("Dry run", len(pending), "migrations success")
+			return nil
+		}
+		return migrateDB(udb)
+	},
+}
+
+// migrateDB run the pending migrations, backup the db before each of them
+func migrateDB(udb db.UDB) error {
+	applied, err := db.Migrate(udb, db.Migrations, func(m *db.Migration) error {
+		return backupDB(udb, m)
+	})
+	for _, m := range applied {
+		log.Info("Migration applied", m.Version, m.Name)
+	}
+	return err
+}
+
+// backupDB copy the db into backup dir with same backend before the migration,
+// named by the current schema version. The db in memory is not backup.
+func backupDB(udb db.UDB, m *db.Migration) error {
+	name := viper.GetString(configDBBackend)
+	if strings.ToLower(name) == db.BackendMemory {
+		return nil
+	}
+	if err := os.MkdirAll(path.Join(dataDir, backupDir), 0700); err != nil {
+		return err
+	}
+	version, err := db.GetSchemaVersion(udb)
+	if err != nil {
+		return err
+	}
+	backupPath := path.Join(dataDir, backupDir, fmt.Sprintf("u.db.v%d.%d", version, time.Now().Unix()))
+	bdb, err := backend.Open(name, backupPath)
+	if err != nil {
+		return err
+	}
+	if err := db.CopyDB(bdb, udb); err != nil {
+		bdb.Close()
+		return err
+	}
+	if err := bdb.Close(); err != nil {
+		return err
+	}
+	log.Info("Database backup before migration", m.Version, backupPath)
+	return nil
+}
+
+// openDataDir load the config and open db in the exist data dir
+func openDataDir() (db.UDB, error) {
+	if err := updateDataDir(); err != nil {
+		return nil, err
+	}
+	if exist, err := pathExists(dataDir); err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, errDataDirNotExist
+	}
+	if err := initConfigLoad(); err != nil {
+		return nil, err
+	}
+	return initDBLoad()
+}
+
+func printCheckResult(res *db.CheckResult) {
+	for _, issue := range res.Issues {
+		fmt.Println(issue)
+	}
+	fmt.Println(res.MsgCount, "messages checked,", len(res.Issues), "issues found")
+}
+
+func init() {
+	dbCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("(default $HOME/%s)", params.DefaultPath))
+	dbCheckCmd.Flags().BoolVar(&dbRepair, "repair", false, "rebuild the indexes of messages if any issue found")
+	dbMigrateCmd.Flags().BoolVar(&dbDryRun, "dryRun", false, "run migrations on copy of database in memory")
+	dbCmd.AddCommand(dbCheckCmd, dbMigrateCmd)
+	rootCmd.AddCommand(dbCmd)
+}
@@ -16,8 +16,19 @@
 
 package main
 
+import (
+	"fmt"
+
+	"github.com/pdupub/go-pdu/db/backend"
+)
+
 // public
-var dataDir string
+var (
+	dataDir   string
+	dbBackend string
+)
+
+var dbBackendUsage = fmt.Sprintf("db backend %v, only used when data dir initialized", backend.Names())
 
 // account
 var (
@@ -33,7 +44,36 @@ var (
 	nodeTPEnable       bool
 	nodeTPInterval     uint64
 	localPort          uint64
+	apiPort            uint64
+	apiExpose          bool
 	unlockKeyFile      string
 	unlockPassFile     string
 	unlockUserIDPrefix string
+	msgVerifiedOnly    bool
+	peersVerifiedOnly  bool
+	searchEnable       bool
+	nodeCodec          string
+)
+
+// console
+var consoleURL string
+
+// birth
+var (
+	birthKeyFile  string
+	birthPassFile string
+	birthDraft    string
+	birthName     string
+	birthExtra    string
+	birthUserID   string
+	birthURL      string
 )
+
+// db
+var (
+	dbRepair bool
+	dbDryRun bool
+)
+
+// export & import
+var archiveFile string
@@ -30,7 +30,7 @@ import (
 	"github.com/pdupub/go-pdu/core"
 	"github.com/pdupub/go-pdu/crypto"
 	"github.com/pdupub/go-pdu/db"
-	"github.com/pdupub/go-pdu/db/bolt"
+	"github.com/pdupub/go-pdu/db/backend"
 	"github.com/pdupub/go-pdu/node"
 	"github.com/pdupub/go-pdu/params"
 	"github.com/spf13/cobra"
@@ -47,12 +47,12 @@ var startCmd = &cobra.Command{
 			return err
 		}
 
+		// new db is used directly, so memory backend can be used without restart
+		var udb db.UDB
 		if exist, err := pathExists(dataDir); err != nil {
 			return err
 		} else if !exist {
-			if newdb, err := initNodeDir(); err != nil {
-				return err
-			} else if err := newdb.Close(); err != nil {
+			if udb, err = initNodeDir(); err != nil {
 				return err
 			}
 			log.Info("Database initialized successfully", dataDir)
@@ -64,19 +64,33 @@ var startCmd = &cobra.Command{
 		log.Info("Starting p2p node")
 		log.Info("CONFIG_NAME", viper.GetString("CONFIG_NAME"))
 
-		udb, err := initDBLoad()
-		if err != nil {
-			return err
+		if udb == nil {
+			var err error
+			if udb, err = initDBLoad(); err != nil {
+				return err
+			}
+			if err := migrateDB(udb); err != nil {
+				return err
+			}
 		}
-		pn, err := node.New(udb)
-		if err != nil {
-			return err
+		cfg := &node.Config{
+			LocalPort:         localPort,
+			APIPort:           apiPort,
+			ExposeAPI:         apiExpose,
+			Nodes:             nodeAddressList,
+			MsgVerifiedOnly:   msgVerifiedOnly,
+			PeersVerifiedOnly: peersVerifiedOnly,
+			Codec:             nodeCodec,
+			Search:            searchEnable,
+			TPInterval:        nodeTPInterval,
+			CheckInterval:     node.DefaultCheckInterval,
 		}
 		// for all node mode need to unlock account
 		var unlockedUser core.User
 		var unlockedPrivateKey *crypto.PrivateKey
 		if nodeTPEnable {
 			var unlockedPublicKey *crypto.PublicKey
+			var err error
 			unlockedPrivateKey, unlockedPublicKey, err = unlockKeyByFile(unlockKeyFile, unlockPassFile)
 			if err != nil {
 				return err
@@ -86,26 +100,13 @@ var startCmd = &cobra.Command{
 				return errors.New("user ID not have enough prefix")
 			}
 
-			rows, err := udb.Find(db.BucketUser, unlockUserIDPrefix, 2)
+			user, err := db.GetUserByPrefix(udb, unlockUserIDPrefix)
 			if err != nil {
 				return err
 			}
-			if len(rows) == 0 {
-				return errors.New("user ID can not be found")
-			}
-			if len(rows) > 1 {
-				return errors.New("user ID which has this prefix are not unique")
-			}
+			unlockedUser = *user
 
 			// check public key match
-			unlockUserBytes, err := udb.Get(db.BucketUser, rows[0].K)
-			if err != nil {
-				return err
-			}
-			err = json.Unmarshal(unlockUserBytes, &unlockedUser)
-			if err != nil {
-				return err
-			}
 
 			p1, err := json.Marshal(unlockedUser.Auth.PubKey)
 			if err != nil {
@@ -121,21 +122,16 @@ var startCmd = &cobra.Command{
 				return errors.New("public key not match")
 			}
 
-			log.Info("Account unlocked success", rows[0].K)
+			log.Info("Account unlocked success", common.Hash2String(unlockedUser.ID()))
+			cfg.TPUser, cfg.TPPrivateKey = &unlockedUser, unlockedPrivateKey
 		}
 
-		if nodeTPEnable {
-			if err := pn.EnableTP(&unlockedUser, unlockedPrivateKey, nodeTPInterval); err != nil {
-				return err
-			}
+		pn, err := node.New(udb, cfg)
+		if err != nil {
+			return err
 		}
-
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt, os.Kill)
-		pn.SetLocalPort(localPort)
-		if nodeAddressList != "" {
-			pn.SetNodes(nodeAddressList)
-		}
 		pn.Run(c)
 
 		return nil
@@ -169,7 +165,7 @@ func initConfigLoad() error {
 
 func initDBLoad() (db.UDB, error) {
 	dbFilePath := path.Join(dataDir, "u.db")
-	udb, err := bolt.NewDB(dbFilePath)
+	udb, err := backend.Open(viper.GetString(configDBBackend), dbFilePath)
 	if err != nil {
 		return nil, err
 	}
@@ -178,8 +174,15 @@ func initDBLoad() (db.UDB, error) {
 
 func init() {
 	startCmd.PersistentFlags().StringVar(&dataDir, "datadir", "", fmt.Sprintf("(default $HOME/%s)", params.DefaultPath))
+	startCmd.PersistentFlags().StringVar(&dbBackend, "db", db.DefaultBackend, dbBackendUsage)
 	startCmd.PersistentFlags().StringVar(&nodeAddressList, "nodes", "", "pdu nodes list, split by comma [userid@ip:port/nodeKey]")
 	startCmd.PersistentFlags().Uint64Var(&localPort, "port", node.DefaultLocalPort, "local port")
+	startCmd.PersistentFlags().Uint64Var(&apiPort, "apiPort", node.DefaultAPIPort, "port of local api")
+	startCmd.PersistentFlags().BoolVar(&apiExpose, "apiExpose", false, fmt.Sprintf("listen local api on all interfaces, not only %s", node.DefaultAPIHost))
+	startCmd.PersistentFlags().BoolVar(&msgVerifiedOnly, "verifiedMsg", false, "only accept messages from verified peers")
+	startCmd.PersistentFlags().BoolVar(&peersVerifiedOnly, "verifiedPeers", false, "only exchange peers with verified peers")
+	startCmd.PersistentFlags().BoolVar(&searchEnable, "search", false, "full-text search of text messages enable")
+	startCmd.PersistentFlags().StringVar(&nodeCodec, "codec", core.CodecBinary, "codec of messages sent to peers and saved in db, json for debugging")
 
 	// time proof
 	startCmd.PersistentFlags().BoolVar(&nodeTPEnable, "tp", false, "time proof enable")
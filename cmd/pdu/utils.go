@@ -30,7 +30,7 @@ import (
 	"github.com/pdupub/go-pdu/crypto"
 	"github.com/pdupub/go-pdu/crypto/utils"
 	"github.com/pdupub/go-pdu/db"
-	"github.com/pdupub/go-pdu/db/bolt"
+	"github.com/pdupub/go-pdu/db/backend"
 	"github.com/pdupub/go-pdu/params"
 	"github.com/spf13/viper"
 )
@@ -52,9 +52,13 @@ func initNodeDir() (db.UDB, error) {
 	return udb, nil
 }
 
+// configDBBackend is the key of db backend in config file
+const configDBBackend = "DB_BACKEND"
+
 func initConfig() error {
 	viper.SetConfigType(params.DefaultConfigType)
 	viper.Set("CONFIG_NAME", "PDU")
+	viper.Set(configDBBackend, dbBackend)
 	return viper.WriteConfigAs(path.Join(dataDir, params.DefaultConfigFile))
 }
 
@@ -73,35 +77,22 @@ func initDir() error {
 
 func initDB() (db.UDB, error) {
 	dbFilePath := path.Join(dataDir, "u.db")
-	udb, err := bolt.NewDB(dbFilePath)
+	udb, err := backend.Open(dbBackend, dbFilePath)
 	if err != nil {
 		return nil, err
 	}
-	if err := udb.CreateBucket(db.BucketConfig); err != nil {
-		return nil, err
+	for _, bucket := range db.Buckets {
+		if err := udb.CreateBucket(bucket); err != nil {
+			return nil, err
+		}
 	}
 	if err := udb.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(0).Bytes()); err != nil {
 		return nil, err
 	}
-	if err := udb.CreateBucket(db.BucketUser); err != nil {
-		return nil, err
-	}
-	if err := udb.CreateBucket(db.BucketMsg); err != nil {
-		return nil, err
-	}
-	if err := udb.CreateBucket(db.BucketMID); err != nil {
-		return nil, err
-	}
-	if err := udb.CreateBucket(db.BucketMOD); err != nil {
-		return nil, err
-	}
-	if err := udb.CreateBucket(db.BucketLastMID); err != nil {
-		return nil, err
-	}
-	if err := udb.CreateBucket(db.BucketPeer); err != nil {
+	if err := udb.Set(db.BucketConfig, db.ConfigCurrentStep, big.NewInt(db.StepInitDB).Bytes()); err != nil {
 		return nil, err
 	}
-	if err := udb.Set(db.BucketConfig, db.ConfigCurrentStep, big.NewInt(db.StepInitDB).Bytes()); err != nil {
+	if err := db.SetSchemaVersion(udb, db.SchemaVersion); err != nil {
 		return nil, err
 	}
 	return udb, nil
@@ -138,6 +129,26 @@ func unlockKeyByFile(keyFile, passFile string) (*crypto.PrivateKey, *crypto.Publ
 	return utils.DecryptKey(keyJSON, strings.TrimSpace(string(passwd)))
 }
 
+// unlockKey unlock key by file, input password if passFile is not set
+func unlockKey(keyFile, passFile string) (*crypto.PrivateKey, *crypto.PublicKey, error) {
+	if keyFile == "" {
+		return nil, nil, errKeyFileMissing
+	}
+	if passFile != "" {
+		return unlockKeyByFile(keyFile, passFile)
+	}
+	keyJSON, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Print("Password: ")
+	passwd, err := gopass.GetPasswd()
+	if err != nil {
+		return nil, nil, err
+	}
+	return utils.DecryptKey(keyJSON, string(passwd))
+}
+
 func scanLine(input *string) {
 	reader := bufio.NewReader(os.Stdin)
 	data, _, _ := reader.ReadLine()
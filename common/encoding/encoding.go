@@ -0,0 +1,201 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package encoding provides the primitives of canonical binary encoding, which
+// is shared by the msg and user in core and the waves in galaxy.
+//
+//	uint64    8 bytes, big endian
+//	bytes     uint64 length, then the bytes
+//	string    bytes of utf-8
+//	hash      32 bytes
+//	list      uint64 count, then each item
+//	optional  0x00 if nil, else 0x01 then the item
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// ErrNotValid returns when the bytes can not be decoded
+var ErrNotValid = errors.New("encoding not valid")
+
+// Writer write the fields in order
+type Writer struct {
+	buf bytes.Buffer
+}
+
+// Bytes return the bytes written
+func (w *Writer) Bytes() []byte {
+	return w.buf.Bytes()
+}
+
+// PutRaw write the bytes without length
+func (w *Writer) PutRaw(b ...byte) {
+	w.buf.Write(b)
+}
+
+// PutUint64 write the uint64
+func (w *Writer) PutUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf.Write(b[:])
+}
+
+// PutBytes write the length and bytes
+func (w *Writer) PutBytes(b []byte) {
+	w.PutUint64(uint64(len(b)))
+	w.buf.Write(b)
+}
+
+// PutString write the string as bytes
+func (w *Writer) PutString(s string) {
+	w.PutBytes([]byte(s))
+}
+
+// PutHash write the hash
+func (w *Writer) PutHash(h common.Hash) {
+	w.buf.Write(h[:])
+}
+
+// PutOptional write the flag of item, return true if the item should be written
+func (w *Writer) PutOptional(present bool) bool {
+	if present {
+		w.buf.WriteByte(1)
+	} else {
+		w.buf.WriteByte(0)
+	}
+	return present
+}
+
+// PutBytesList write the count and each bytes
+func (w *Writer) PutBytesList(list [][]byte) {
+	w.PutUint64(uint64(len(list)))
+	for _, b := range list {
+		w.PutBytes(b)
+	}
+}
+
+// PutHashList write the count and each hash
+func (w *Writer) PutHashList(list []common.Hash) {
+	w.PutUint64(uint64(len(list)))
+	for _, h := range list {
+		w.PutHash(h)
+	}
+}
+
+// Reader read the fields in order, the first error is kept
+type Reader struct {
+	data []byte
+	err  error
+}
+
+// NewReader create the reader of data
+func NewReader(data []byte) *Reader {
+	return &Reader{data: data}
+}
+
+// Err return the first error
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Fail keep the err as the error of reader, if no error yet
+func (r *Reader) Fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Finish return the first error, the data must be read in full
+func (r *Reader) Finish() error {
+	if r.err == nil && len(r.data) != 0 {
+		r.err = ErrNotValid
+	}
+	return r.err
+}
+
+// GetRaw read n bytes without length, nil if not enough
+func (r *Reader) GetRaw(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if uint64(len(r.data)) < n {
+		r.err = ErrNotValid
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// GetUint64 read the uint64
+func (r *Reader) GetUint64() uint64 {
+	if b := r.GetRaw(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// GetBytes return the copy of bytes, nil if the length is 0
+func (r *Reader) GetBytes() []byte {
+	n := r.GetUint64()
+	if b := r.GetRaw(n); len(b) > 0 {
+		return append([]byte{}, b...)
+	}
+	return nil
+}
+
+// GetString read the string
+func (r *Reader) GetString() string {
+	return string(r.GetBytes())
+}
+
+// GetHash read the hash
+func (r *Reader) GetHash() common.Hash {
+	return common.Bytes2Hash(r.GetRaw(common.HashLength))
+}
+
+// GetOptional read the flag of item, return true if the item should be read
+func (r *Reader) GetOptional() bool {
+	b := r.GetRaw(1)
+	if b == nil {
+		return false
+	}
+	if b[0] > 1 {
+		r.err = ErrNotValid
+	}
+	return b[0] == 1
+}
+
+// GetBytesList read the count and each bytes
+func (r *Reader) GetBytesList() (list [][]byte) {
+	for n := r.GetUint64(); n > 0 && r.err == nil; n-- {
+		list = append(list, r.GetBytes())
+	}
+	return list
+}
+
+// GetHashList read the count and each hash
+func (r *Reader) GetHashList() (list []common.Hash) {
+	for n := r.GetUint64(); n > 0 && r.err == nil; n-- {
+		list = append(list, r.GetHash())
+	}
+	return list
+}
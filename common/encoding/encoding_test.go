@@ -0,0 +1,69 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package encoding
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+func TestWriterReader(t *testing.T) {
+	hash := common.CreateHash()
+	w := &Writer{}
+	w.PutRaw(7)
+	w.PutUint64(42)
+	w.PutString("pdu")
+	w.PutBytes(nil)
+	w.PutHash(hash)
+	w.PutOptional(true)
+	w.PutOptional(false)
+	w.PutBytesList([][]byte{[]byte("a"), []byte("bc")})
+	w.PutHashList([]common.Hash{hash})
+
+	r := NewReader(w.Bytes())
+	if b := r.GetRaw(1); len(b) != 1 || b[0] != 7 {
+		t.Error("raw not match")
+	}
+	if r.GetUint64() != 42 || r.GetString() != "pdu" || r.GetBytes() != nil || r.GetHash() != hash {
+		t.Error("fields not match")
+	}
+	if !r.GetOptional() || r.GetOptional() {
+		t.Error("optional not match")
+	}
+	if list := r.GetBytesList(); len(list) != 2 || !bytes.Equal(list[1], []byte("bc")) {
+		t.Error("bytes list not match")
+	}
+	if list := r.GetHashList(); len(list) != 1 || list[0] != hash {
+		t.Error("hash list not match")
+	}
+	if err := r.Finish(); err != nil {
+		t.Error(err)
+	}
+
+	// data not enough, or not read in full
+	if r := NewReader(w.Bytes()[:5]); r.GetUint64() != 0 || r.Finish() != ErrNotValid {
+		t.Error("short data should not be valid")
+	}
+	if r := NewReader([]byte{0, 1}); r.GetOptional() || r.Finish() != ErrNotValid {
+		t.Error("data not read in full should not be valid")
+	}
+	if r := NewReader([]byte{2}); r.GetOptional() || r.Finish() != ErrNotValid {
+		t.Error("optional flag should be 0 or 1")
+	}
+}
@@ -18,51 +18,118 @@ package console
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/peterh/liner"
 	"github.com/spf13/cobra"
 )
 
+const (
+	// DefaultHistoryFile is the file name of console history, in the data dir of pdu
+	DefaultHistoryFile = "console_history"
+
+	// maxHistorySize is the max number of lines kept in history
+	maxHistorySize = 1000
+
+	// defaultMsgCount is the default number of messages shown by msgs command
+	defaultMsgCount = 10
+)
+
+var (
+	errNotConnected    = errors.New("target url of node is not set")
+	errUserNotUnlocked = errors.New("user not unlocked")
+	errPubKeyNotMatch  = errors.New("public key not match")
+	errPromptNotReady  = errors.New("prompt not ready")
+)
+
 // Console is the struct of cmd line console
 type Console struct {
-	targetURL string
-	showCmd   *cobra.Command
-	history   []string
+	targetURL   string
+	client      *node.Client
+	rootCmd     *cobra.Command
+	history     []string
+	historyPath string
+	prompter    *liner.State
+	out         io.Writer
+	user        *core.User
+	priKey      *crypto.PrivateKey
+	quit        bool
 }
 
 // NewConsole used to build a new console
 func NewConsole() (*Console, error) {
-	cls := &Console{}
-	cls.BldShowCmd()
+	cls := &Console{out: os.Stdout}
+	cls.BldCmds()
 	return cls, nil
 }
 
-// Close the console
+// Close the console, save the history
 func (c *Console) Close() {
-
+	if err := c.saveHistory(); err != nil {
+		fmt.Fprintln(c.out, "Save history fail", err)
+	}
+	if c.prompter != nil {
+		c.prompter.Close()
+		c.prompter = nil
+	}
 }
 
 // Run the console
 func (c *Console) Run() {
+	c.prompter = liner.NewLiner()
+	c.prompter.SetCtrlCAborts(true)
+	if err := c.loadHistory(); err != nil {
+		fmt.Fprintln(c.out, "Load history fail", err)
+	}
 	c.welcome()
-	for {
-		fmt.Print("> ")
-		content := c.scanLine()
-		if content == "quit" || content == "q" {
-			c.Close()
+	for !c.quit {
+		content, err := c.prompter.Prompt("> ")
+		if err != nil {
+			// ctrl-c or ctrl-d
 			break
-		} else if content == "show" {
-			c.ExeShowCmd()
-		} else {
-			fmt.Println(content)
+		}
+		content = strings.TrimSpace(content)
+		if content == "" {
+			continue
+		}
+		c.addHistory(content)
+		if err := c.Execute(content); err != nil {
+			fmt.Fprintln(c.out, "Error:", err)
 		}
 	}
+	c.Close()
+}
+
+// Execute one line of command
+func (c *Console) Execute(line string) error {
+	// flags of cobra command keep the value, reset help flag for next line
+	defer func() {
+		for _, cmd := range append(c.rootCmd.Commands(), c.rootCmd) {
+			if f := cmd.Flags().Lookup("help"); f != nil {
+				f.Value.Set("false")
+				f.Changed = false
+			}
+		}
+	}()
+	c.rootCmd.SetArgs(strings.Fields(line))
+	return c.rootCmd.Execute()
 }
 
 // initInfoDisplay
 func (c Console) welcome() {
-	fmt.Print(`
+	fmt.Fprint(c.out, `
 ##############################################################
 #                                                            #
 #               Welcome to PDU console ;-)                   #
@@ -70,37 +137,311 @@ func (c Console) welcome() {
 ##############################################################
 
 `)
+	if c.targetURL != "" {
+		fmt.Fprintln(c.out, "Target node", c.targetURL)
+	}
+	fmt.Fprintln(c.out, "Type help for the list of commands, quit to exit")
 }
 
 // SetTargetURL set the url of remote url
 func (c *Console) SetTargetURL(url string) {
 	c.targetURL = url
+	c.client = node.NewClient(url)
+}
+
+// SetHistoryPath set the path of history file
+func (c *Console) SetHistoryPath(path string) {
+	c.historyPath = path
+}
+
+// SetOutput set the writer of console output
+func (c *Console) SetOutput(out io.Writer) {
+	c.out = out
+	c.rootCmd.SetOutput(out)
+}
+
+func (c *Console) addHistory(line string) {
+	c.history = append(c.history, line)
+	if len(c.history) > maxHistorySize {
+		c.history = c.history[len(c.history)-maxHistorySize:]
+	}
+	if c.prompter != nil {
+		c.prompter.AppendHistory(line)
+	}
+}
+
+func (c *Console) loadHistory() error {
+	if c.historyPath == "" {
+		return nil
+	}
+	f, err := os.Open(c.historyPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			c.addHistory(line)
+		}
+	}
+	return scanner.Err()
+}
+
+func (c Console) saveHistory() error {
+	if c.historyPath == "" {
+		return nil
+	}
+	content := strings.Join(c.history, "\n")
+	if len(c.history) > 0 {
+		content += "\n"
+	}
+	return ioutil.WriteFile(c.historyPath, []byte(content), 0600)
+}
+
+func (c Console) call(result interface{}, method string, args ...interface{}) error {
+	if c.client == nil {
+		return errNotConnected
+	}
+	return c.client.Call(result, method, args...)
+}
+
+// callAndPrint call the method of node api and print the result as json
+func (c Console) callAndPrint(method string, args ...interface{}) error {
+	var result json.RawMessage
+	if err := c.call(&result, method, args...); err != nil {
+		return err
+	}
+	return c.printJSON(result)
 }
 
-// BldShowCmd initial the show command
-func (c *Console) BldShowCmd() {
-	c.showCmd = &cobra.Command{
-		Use:   "show",
-		Short: "Show command line",
+func (c Console) printJSON(v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, string(content))
+	return nil
+}
+
+// BldCmds initial the commands of console
+func (c *Console) BldCmds() {
+	c.rootCmd = &cobra.Command{
+		Use:           "",
+		Short:         "PDU console",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	c.rootCmd.AddCommand(
+		&cobra.Command{
+			Use:   "connect [url]",
+			Short: "Connect to the local api of node, like http://127.0.0.1:8342/node",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(_ *cobra.Command, args []string) error {
+				c.SetTargetURL(args[0])
+				return c.callAndPrint(node.MethodNodeInfo)
+			},
+		},
+		&cobra.Command{
+			Use:   "info",
+			Short: "Show information of node",
+			RunE: func(_ *cobra.Command, args []string) error {
+				return c.callAndPrint(node.MethodNodeInfo)
+			},
+		},
+		&cobra.Command{
+			Use:   "peers",
+			Short: "List peers of node",
+			RunE: func(_ *cobra.Command, args []string) error {
+				return c.callAndPrint(node.MethodPeers)
+			},
+		},
+		&cobra.Command{
+			Use:   "roots",
+			Short: "Show root users of universe",
+			RunE: func(_ *cobra.Command, args []string) error {
+				return c.callAndPrint(node.MethodRoots)
+			},
+		},
+		&cobra.Command{
+			Use:   "user [userID]",
+			Short: "Show user by ID",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(_ *cobra.Command, args []string) error {
+				return c.callAndPrint(node.MethodUser, args[0])
+			},
+		},
+		&cobra.Command{
+			Use:   "userinfo [userID] [spacetimeID]",
+			Short: "Show user info in space time",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(_ *cobra.Command, args []string) error {
+				return c.callAndPrint(node.MethodUserInfo, args[0], args[1])
+			},
+		},
+		&cobra.Command{
+			Use:   "st",
+			Short: "List space time IDs and max time sequence",
+			RunE: func(_ *cobra.Command, args []string) error {
+				return c.callAndPrint(node.MethodSpaceTimes)
+			},
+		},
+		&cobra.Command{
+			Use:   "msg [msgID]",
+			Short: "Show message by ID",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(_ *cobra.Command, args []string) error {
+				return c.callAndPrint(node.MethodMsgByID, args[0])
+			},
+		},
+		&cobra.Command{
+			Use:   "msgs [start] [count]",
+			Short: "Browse messages by order",
+			Args:  cobra.RangeArgs(1, 2),
+			RunE: func(_ *cobra.Command, args []string) error {
+				start, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					return err
+				}
+				count := uint64(defaultMsgCount)
+				if len(args) > 1 {
+					if count, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+						return err
+					}
+				}
+				return c.callAndPrint(node.MethodMsgByOrder, start, count)
+			},
+		},
+		c.searchCmd(),
+		&cobra.Command{
+			Use:   "unlock [keyFile] [userID]",
+			Short: "Unlock the key of user to sign messages",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(_ *cobra.Command, args []string) error {
+				if c.prompter == nil {
+					return errPromptNotReady
+				}
+				pass, err := c.prompter.PasswordPrompt("Password: ")
+				if err != nil {
+					return err
+				}
+				return c.unlock(args[0], pass, args[1])
+			},
+		},
+		&cobra.Command{
+			Use:   "send [text]",
+			Short: "Sign and send text message by unlocked user, reference the last message",
+			Args:  cobra.MinimumNArgs(1),
+			RunE: func(_ *cobra.Command, args []string) error {
+				return c.sendText(strings.Join(args, " "))
+			},
+		},
+		&cobra.Command{
+			Use:   "history",
+			Short: "Show history of commands",
+			RunE: func(_ *cobra.Command, args []string) error {
+				for i, line := range c.history {
+					fmt.Fprintf(c.out, "%4d  %s\n", i+1, line)
+				}
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:     "quit",
+			Aliases: []string{"q", "exit"},
+			Short:   "Quit the console",
+			RunE: func(_ *cobra.Command, args []string) error {
+				c.quit = true
+				return nil
+			},
+		},
+	)
+}
+
+// searchCmd return the command of full-text search, the flags are reset after
+// each search, so they are not kept for the next line
+func (c *Console) searchCmd() *cobra.Command {
+	var sender, st string
+	var skip, limit uint64
+	cmd := &cobra.Command{
+		Use:   "search [text]",
+		Short: "Search text messages which contain all words, newest first",
+		Args:  cobra.MinimumNArgs(1),
 		RunE: func(_ *cobra.Command, args []string) error {
-			fmt.Println("Show information ......")
-			return nil
+			defer func() {
+				sender, st, skip, limit = "", "", 0, defaultMsgCount
+			}()
+			return c.callAndPrint(node.MethodSearch, strings.Join(args, " "), sender, st, skip, limit)
 		},
 	}
+	cmd.Flags().StringVar(&sender, "sender", "", "only messages sent by the user")
+	cmd.Flags().StringVar(&st, "st", "", "only messages from users visible in the space time")
+	cmd.Flags().Uint64Var(&skip, "skip", 0, "number of messages skipped")
+	cmd.Flags().Uint64Var(&limit, "limit", defaultMsgCount, "max number of messages")
+	return cmd
 }
 
-// ExeShowCmd execute the show command
-func (c *Console) ExeShowCmd() error {
-	if err := c.showCmd.Execute(); err != nil {
+// unlock the key file and check the public key match the auth of user
+func (c *Console) unlock(keyFile, pass, userID string) error {
+	keyJSON, err := ioutil.ReadFile(keyFile)
+	if err != nil {
 		return err
 	}
+	priKey, pubKey, err := utils.DecryptKey(keyJSON, strings.TrimSpace(pass))
+	if err != nil {
+		return err
+	}
+	var user core.User
+	if err := c.call(&user, node.MethodUser, userID); err != nil {
+		return err
+	}
+	p1, err := json.Marshal(user.Auth.PubKey)
+	if err != nil {
+		return err
+	}
+	p2, err := json.Marshal(pubKey.PubKey)
+	if err != nil {
+		return err
+	}
+	if user.Auth.Source != pubKey.Source || user.Auth.SigType != pubKey.SigType ||
+		common.Bytes2String(p1) != common.Bytes2String(p2) {
+		return errPubKeyNotMatch
+	}
+	c.user, c.priKey = &user, priKey
+	fmt.Fprintln(c.out, "User unlocked", common.Hash2String(user.ID()))
 	return nil
 }
 
-func (c Console) scanLine() string {
-	var input string
-	reader := bufio.NewReader(os.Stdin)
-	data, _, _ := reader.ReadLine()
-	input = string(data)
-	return input
+// sendText create the text message signed by unlocked user and send to node
+func (c *Console) sendText(text string) error {
+	if c.user == nil || c.priKey == nil {
+		return errUserNotUnlocked
+	}
+	var info node.NodeInfo
+	if err := c.call(&info, node.MethodNodeInfo); err != nil {
+		return err
+	}
+	var refs []*core.MsgReference
+	if info.MsgCount > 0 {
+		var lastMsgs []*core.Message
+		if err := c.call(&lastMsgs, node.MethodMsgByOrder, info.MsgCount-1, 1); err != nil {
+			return err
+		}
+		for _, msg := range lastMsgs {
+			refs = append(refs, &core.MsgReference{SenderID: msg.SenderID, MsgID: msg.ID()})
+		}
+	}
+	value := &core.MsgValue{ContentType: core.TypeText, Content: []byte(text)}
+	msg, err := core.CreateMsg(c.user, value, c.priKey, refs...)
+	if err != nil {
+		return err
+	}
+	var msgID string
+	if err := c.call(&msgID, node.MethodSendMsg, msg); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "Message sent", msgID)
+	return nil
 }
@@ -16,8 +16,130 @@
 
 package console
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pdupub/go-pdu/node"
+)
+
+// testNodeServer response the node info for any request
+func testNodeServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req node.RPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		res := &node.RPCResponse{JSONRPC: node.JSONRPCVersion, ID: req.ID}
+		switch req.Method {
+		case node.MethodNodeInfo:
+			res.Result, _ = json.Marshal(&node.NodeInfo{Version: "test", NodeKey: "nodekey"})
+		case node.MethodSearch:
+			// params are returned as result
+			res.Result, _ = json.Marshal(req.Params)
+		default:
+			res.Error = &node.RPCError{Code: node.ErrCodeMethodNotFound, Message: "method not found"}
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+}
 
 func TestNewConsole(t *testing.T) {
+	cls, err := NewConsole()
+	if err != nil {
+		t.Error(err)
+	}
+	var out bytes.Buffer
+	cls.SetOutput(&out)
+
+	if err := cls.Execute("info"); err != errNotConnected {
+		t.Errorf("error should be %s, but get %v", errNotConnected, err)
+	}
+	if err := cls.Execute("unknown"); err == nil {
+		t.Error("unknown command should return error")
+	}
+	if err := cls.Execute("send hello"); err != errUserNotUnlocked {
+		t.Errorf("error should be %s, but get %v", errUserNotUnlocked, err)
+	}
+
+	srv := testNodeServer(t)
+	defer srv.Close()
+	cls.SetTargetURL(srv.URL)
+	out.Reset()
+	if err := cls.Execute("info"); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(out.String(), "nodekey") {
+		t.Error("node info not shown", out.String())
+	}
+	if err := cls.Execute("peers"); err == nil || !strings.Contains(err.Error(), "method not found") {
+		t.Error("error should be returned from node", err)
+	}
+
+	if err := cls.Execute("quit"); err != nil || !cls.quit {
+		t.Error("console should quit", err)
+	}
+}
+
+func TestConsole_Search(t *testing.T) {
+	cls, _ := NewConsole()
+	var out bytes.Buffer
+	cls.SetOutput(&out)
+	srv := testNodeServer(t)
+	defer srv.Close()
+	cls.SetTargetURL(srv.URL)
+
+	cases := []struct {
+		line   string
+		params []interface{}
+	}{
+		{"search hello world --sender abc --skip 2 --limit 5", []interface{}{"hello world", "abc", "", 2.0, 5.0}},
+		{"search hello --st def", []interface{}{"hello", "", "def", 0.0, float64(defaultMsgCount)}},
+		{"search hello", []interface{}{"hello", "", "", 0.0, float64(defaultMsgCount)}},
+	}
+	for _, c := range cases {
+		out.Reset()
+		if err := cls.Execute(c.line); err != nil {
+			t.Fatal(c.line, err)
+		}
+		var params []interface{}
+		if err := json.Unmarshal(out.Bytes(), &params); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(params, c.params) {
+			t.Errorf("%s : params should be %v, but %v", c.line, c.params, params)
+		}
+	}
+}
+
+func TestConsole_History(t *testing.T) {
+	dir, err := ioutil.TempDir("", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	historyPath := path.Join(dir, DefaultHistoryFile)
+
+	cls, _ := NewConsole()
+	cls.SetHistoryPath(historyPath)
+	cls.addHistory("info")
+	cls.addHistory("peers")
+	cls.Close()
 
+	cls, _ = NewConsole()
+	cls.SetHistoryPath(historyPath)
+	if err := cls.loadHistory(); err != nil {
+		t.Error(err)
+	}
+	if len(cls.history) != 2 || cls.history[0] != "info" || cls.history[1] != "peers" {
+		t.Error("history not match", cls.history)
+	}
 }
@@ -58,3 +58,20 @@ func (a Auth) MarshalJSON() ([]byte, error) {
 	_, pkBytes, err := engine.Marshal(nil, &a.PublicKey)
 	return pkBytes, err
 }
+
+// Verify check if the signature of hash is signed by the key of auth
+func (a Auth) Verify(hash []byte, sig *crypto.Signature) (bool, error) {
+	if sig == nil {
+		return false, crypto.ErrParamsMissing
+	}
+	if sig.Source != a.Source || sig.SigType != a.SigType {
+		return false, crypto.ErrSourceNotMatch
+	}
+	engine, err := utils.SelectEngine(a.Source)
+	if err != nil {
+		return false, err
+	}
+	signature := *sig
+	signature.PubKey = a.PubKey
+	return engine.Verify(hash, &signature)
+}
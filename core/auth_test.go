@@ -18,6 +18,7 @@ package core
 
 import (
 	"crypto/ecdsa"
+	"crypto/sha256"
 	"encoding/json"
 	"testing"
 
@@ -57,3 +58,41 @@ func TestAuth_MarshalJSON(t *testing.T) {
 	}
 
 }
+
+func TestAuth_Verify(t *testing.T) {
+	engine, _ := utils.SelectEngine(defaultEngineName)
+	privKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Errorf("pdu genereate key fail, err: %s", err)
+	}
+	auth := Auth{PublicKey: *pubKey}
+	hash := sha256.Sum256([]byte("hello world"))
+	sig, err := engine.Sign(hash[:], privKey)
+	if err != nil {
+		t.Errorf("sign fail, err: %s", err)
+	}
+	sig.PubKey = nil
+	if res, err := auth.Verify(hash[:], sig); err != nil || !res {
+		t.Errorf("verify fail, err: %s", err)
+	}
+
+	otherPrivKey, _, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Errorf("pdu genereate key fail, err: %s", err)
+	}
+	otherSig, err := engine.Sign(hash[:], otherPrivKey)
+	if err != nil {
+		t.Errorf("sign fail, err: %s", err)
+	}
+	if res, _ := auth.Verify(hash[:], otherSig); res {
+		t.Error("signature by other key should not be verified")
+	}
+
+	sig.SigType = crypto.MultipleSignatures
+	if _, err := auth.Verify(hash[:], sig); err != crypto.ErrSourceNotMatch {
+		t.Errorf("error should be %s, but get %v", crypto.ErrSourceNotMatch, err)
+	}
+	if _, err := auth.Verify(hash[:], nil); err != crypto.ErrParamsMissing {
+		t.Errorf("error should be %s, but get %v", crypto.ErrParamsMissing, err)
+	}
+}
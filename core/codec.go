@@ -0,0 +1,84 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import "encoding/json"
+
+// Codecs of msg and user on wire and in storage
+const (
+	// CodecJSON is readable, used for debugging
+	CodecJSON = "json"
+
+	// CodecBinary is the canonical binary encoding, see EncodeMsg and EncodeUser
+	CodecBinary = "binary"
+)
+
+// legacy msg and user are always marshaled by CodecJSON, because their IDs and
+// signatures are computed over json, which is not kept by binary encoding.
+
+// MarshalMsg marshal msg by codec
+func MarshalMsg(msg *Message, codec string) ([]byte, error) {
+	switch codec {
+	case CodecJSON:
+		return json.Marshal(msg)
+	case CodecBinary:
+		if msg.Version == EncodingLegacy {
+			return json.Marshal(msg)
+		}
+		return EncodeMsg(msg), nil
+	}
+	return nil, ErrCodecNotSupport
+}
+
+// UnmarshalMsg unmarshal msg by either codec, the json always start with '{',
+// and the binary encoding start with version in 8 bytes.
+func UnmarshalMsg(data []byte) (*Message, error) {
+	if len(data) > 0 && data[0] == '{' {
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+	}
+	return DecodeMsg(data)
+}
+
+// MarshalUser marshal user by codec
+func MarshalUser(u *User, codec string) ([]byte, error) {
+	switch codec {
+	case CodecJSON:
+		return json.Marshal(u)
+	case CodecBinary:
+		if u.Version == EncodingLegacy {
+			return json.Marshal(u)
+		}
+		return EncodeUser(u)
+	}
+	return nil, ErrCodecNotSupport
+}
+
+// UnmarshalUser unmarshal user by either codec, same as UnmarshalMsg
+func UnmarshalUser(data []byte) (*User, error) {
+	if len(data) > 0 && data[0] == '{' {
+		var u User
+		if err := json.Unmarshal(data, &u); err != nil {
+			return nil, err
+		}
+		return &u, nil
+	}
+	return DecodeUser(data)
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/pdu"
+)
+
+func createCodecMsg(tb testing.TB) (*User, *Message) {
+	engine := pdu.New()
+	priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	user := CreateRootUser(*pubKey, "user", "extra")
+	msg, err := CreateMsg(user, &MsgValue{ContentType: TypeText, Content: []byte("hello world")}, priKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return user, msg
+}
+
+func TestCodec(t *testing.T) {
+	user, msg := createCodecMsg(t)
+	for _, codec := range []string{CodecJSON, CodecBinary} {
+		msgBytes, err := MarshalMsg(msg, codec)
+		if err != nil {
+			t.Fatal(codec, err)
+		}
+		if (msgBytes[0] == '{') != (codec == CodecJSON) {
+			t.Errorf("%s : msg not marshaled by codec", codec)
+		}
+		msgLoad, err := UnmarshalMsg(msgBytes)
+		if err != nil {
+			t.Fatal(codec, err)
+		}
+		if msgLoad.ID() != msg.ID() || !bytes.Equal(msgLoad.Signature.Signature, msg.Signature.Signature) {
+			t.Errorf("%s : msg not match", codec)
+		}
+
+		userBytes, err := MarshalUser(user, codec)
+		if err != nil {
+			t.Fatal(codec, err)
+		}
+		userLoad, err := UnmarshalUser(userBytes)
+		if err != nil {
+			t.Fatal(codec, err)
+		}
+		if userLoad.ID() != user.ID() {
+			t.Errorf("%s : user not match", codec)
+		}
+	}
+
+	// legacy msg is always marshaled by json
+	legacy := *msg
+	legacy.Version = EncodingLegacy
+	msgBytes, err := MarshalMsg(&legacy, CodecBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgBytes[0] != '{' {
+		t.Error("legacy msg should be marshaled by json")
+	}
+
+	if _, err := MarshalMsg(msg, "unknown"); err != ErrCodecNotSupport {
+		t.Errorf("err should be %s, but now err : %v", ErrCodecNotSupport, err)
+	}
+	msgBytes, _ = MarshalMsg(msg, CodecBinary)
+	if _, err := UnmarshalMsg(msgBytes[:len(msgBytes)-1]); err != ErrEncodingNotValid {
+		t.Errorf("err should be %s, but now err : %v", ErrEncodingNotValid, err)
+	}
+	if _, err := UnmarshalMsg(append(msgBytes, 0)); err != ErrEncodingNotValid {
+		t.Errorf("err should be %s, but now err : %v", ErrEncodingNotValid, err)
+	}
+}
+
+func BenchmarkCodec_Msg(b *testing.B) {
+	_, msg := createCodecMsg(b)
+	for _, codec := range []string{CodecJSON, CodecBinary} {
+		b.Run(codec, func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				msgBytes, err := MarshalMsg(msg, codec)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := UnmarshalMsg(msgBytes); err != nil {
+					b.Fatal(err)
+				}
+				size = len(msgBytes)
+			}
+			b.ReportMetric(float64(size), "bytes/msg")
+		})
+	}
+}
@@ -17,6 +17,7 @@
 package core
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 
 	"github.com/pdupub/go-pdu/common"
@@ -38,7 +39,7 @@ type ParentSig struct {
 
 // CreateContentBirth create the birth msg content , which usually from the new user, not sign by parents yet
 func CreateContentBirth(name string, extra string, auth *Auth) (*ContentBirth, error) {
-	user := User{Name: name, BirthExtra: extra, Auth: auth}
+	user := User{Version: DefaultEncoding, Name: name, BirthExtra: extra, Auth: auth}
 	return &ContentBirth{User: user}, nil
 
 }
@@ -46,7 +47,7 @@ func CreateContentBirth(name string, extra string, auth *Auth) (*ContentBirth, e
 // SignByParent used to sign the birth msg by both parents
 func (mv *ContentBirth) SignByParent(user *User, privKey crypto.PrivateKey) error {
 
-	jsonByte, err := json.Marshal(mv.User)
+	sigHash, err := mv.sigHash()
 	if err != nil {
 		return err
 	}
@@ -56,7 +57,7 @@ func (mv *ContentBirth) SignByParent(user *User, privKey crypto.PrivateKey) erro
 		return err
 	}
 
-	signature, err = engine.Sign(jsonByte, &privKey)
+	signature, err = engine.Sign(sigHash, &privKey)
 	if err != nil {
 		return err
 	}
@@ -68,3 +69,46 @@ func (mv *ContentBirth) SignByParent(user *User, privKey crypto.PrivateKey) erro
 	}
 	return nil
 }
+
+// verifyParent check if the signature of parent at index is signed by the parent
+func (mv ContentBirth) verifyParent(index int, parent *User) error {
+	parentSig := mv.Parents[index]
+	if len(parentSig.Signature) == 0 {
+		return ErrParentSignatureMissing
+	}
+	if parent.Auth == nil {
+		return ErrParentSignatureInvalid
+	}
+	sigHash, err := mv.sigHash()
+	if err != nil {
+		return err
+	}
+	signature := &crypto.Signature{
+		PublicKey: crypto.PublicKey{Source: parent.Auth.Source, SigType: parent.Auth.SigType},
+		Signature: parentSig.Signature,
+	}
+	if ok, err := parent.Auth.Verify(sigHash, signature); err != nil || !ok {
+		return ErrParentSignatureInvalid
+	}
+	return nil
+}
+
+// sigHash return the input of new user which is signed by parents.
+// The user of EncodingLegacy is signed on its json as before, so the birth msg
+// already signed can still be verified. Some of engines only use the first 32 bytes
+// of input, so the user of EncodingV1 is hashed.
+func (mv ContentBirth) sigHash() ([]byte, error) {
+	switch mv.User.Version {
+	case EncodingLegacy:
+		// json of User value, not the MarshalJSON of *User
+		return json.Marshal(mv.User)
+	case EncodingV1:
+		userBytes, err := EncodeUser(&mv.User)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(userBytes)
+		return hash[:], nil
+	}
+	return nil, ErrEncodingNotSupport
+}
@@ -16,7 +16,14 @@
 
 package core
 
-import "crypto"
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
 
 const (
 	// LeakedPrivateKey is the evidence can prove a private key has been leaked.
@@ -45,21 +52,136 @@ func CreateContentEvidence(evidenceType int, msgs []*Message) *ContentEvidence {
 // CreateLPCE create the evidence of leaked privatekey
 func CreateLPCE(privKey crypto.PrivateKey, msg *Message) (*ContentEvidence, error) {
 	// check relation of privKey & msg.SenderID.Auth
-
+	if err := verifyLeakedKey(&privKey, msg); err != nil {
+		return nil, err
+	}
+	engine, err := utils.SelectEngine(privKey.Source)
+	if err != nil {
+		return nil, err
+	}
+	privKeyBytes, _, err := engine.Marshal(&privKey, nil)
+	if err != nil {
+		return nil, err
+	}
 	// create anonymous msg which content is privKey
-	msgPrivateKey := &Message{}
+	msgPrivateKey := &Message{Value: &MsgValue{ContentType: TypeText, Content: privKeyBytes}}
 	// create content evidence by two messages
-
 	return &ContentEvidence{EvidenceType: LeakedPrivateKey, Msgs: []*Message{msgPrivateKey, msg}}, nil
 }
 
 // CreateEBCE creaste the evidence of ExcessiveBirth
 func CreateEBCE(msgs ...*Message) (*ContentEvidence, error) {
 	// check all msgs from same SenderID
+	if _, err := checkEBCEMsgs(msgs); err != nil {
+		return nil, err
+	}
+	// if two msgs in spacetime and illeagal at least on one spacetime,
+	// can only be checked by universe.
+	return &ContentEvidence{EvidenceType: ExcessiveBirth, Msgs: msgs}, nil
+}
 
-	// check if two msgs in spacetime
+// verifyLeakedKey check if msg can be signed by privKey, the msg must be verified by
+// the auth of sender before, so the privKey belong to the sender.
+func verifyLeakedKey(privKey *crypto.PrivateKey, msg *Message) error {
+	if msg == nil || msg.Signature == nil {
+		return ErrEvidenceNotValid
+	}
+	if privKey.Source != msg.Signature.Source || privKey.SigType != msg.Signature.SigType {
+		return ErrEvidenceNotValid
+	}
+	engine, err := utils.SelectEngine(privKey.Source)
+	if err != nil {
+		return err
+	}
+	sigHash, err := msg.sigHash()
+	if err != nil {
+		return err
+	}
+	// public key is returned with the signature
+	sig, err := engine.Sign(sigHash, privKey)
+	if err != nil {
+		return err
+	}
+	signature := *msg.Signature
+	signature.PubKey = sig.PubKey
+	target := *msg
+	target.Signature = &signature
+	if res, err := VerifyMsg(target); err != nil || !res {
+		return ErrEvidenceNotValid
+	}
+	return nil
+}
 
-	// two msgs illeagal at least on one spacetime local universe
+// parseLeakedKey parse the private key from the content of anonymous msg in evidence
+func parseLeakedKey(msg *Message) (*crypto.PrivateKey, error) {
+	if msg == nil || msg.Value == nil {
+		return nil, ErrEvidenceNotValid
+	}
+	// check the format before unmarshal by engine
+	keyMap := make(map[string]interface{})
+	if err := json.Unmarshal(msg.Value.Content, &keyMap); err != nil {
+		return nil, err
+	}
+	source, ok := keyMap["source"].(string)
+	if !ok {
+		return nil, ErrEvidenceNotValid
+	}
+	sigType, ok := keyMap["sigType"].(string)
+	if !ok {
+		return nil, ErrEvidenceNotValid
+	}
+	switch strings.ToUpper(sigType) {
+	case crypto.Signature2PublicKey:
+		if _, ok := keyMap["privKey"].(string); !ok {
+			return nil, ErrEvidenceNotValid
+		}
+	case crypto.MultipleSignatures:
+		keys, ok := keyMap["privKey"].([]interface{})
+		if !ok {
+			return nil, ErrEvidenceNotValid
+		}
+		for _, k := range keys {
+			if _, ok := k.(string); !ok {
+				return nil, ErrEvidenceNotValid
+			}
+		}
+	default:
+		return nil, ErrEvidenceNotValid
+	}
+	engine, err := utils.SelectEngine(source)
+	if err != nil {
+		return nil, err
+	}
+	privKey, _, err := engine.Unmarshal(msg.Value.Content, nil)
+	if err != nil {
+		return nil, err
+	}
+	return privKey, nil
+}
 
-	return &ContentEvidence{EvidenceType: ExcessiveBirth, Msgs: msgs}, nil
+// checkEBCEMsgs check the msgs in ExcessiveBirth evidence are birth msgs from same SenderID,
+// and the sender is one of parents in each msg, return the SenderID.
+func checkEBCEMsgs(msgs []*Message) (common.Hash, error) {
+	if len(msgs) < 2 {
+		return common.Hash{}, ErrEvidenceNotValid
+	}
+	senderID := msgs[0].SenderID
+	msgIDs := make(map[common.Hash]bool)
+	for _, msg := range msgs {
+		if msg == nil || msg.Value == nil || msg.SenderID != senderID || msg.Value.ContentType != TypeBirth {
+			return common.Hash{}, ErrEvidenceNotValid
+		}
+		if msgIDs[msg.ID()] {
+			return common.Hash{}, ErrEvidenceNotValid
+		}
+		msgIDs[msg.ID()] = true
+		var contentBirth ContentBirth
+		if err := json.Unmarshal(msg.Value.Content, &contentBirth); err != nil {
+			return common.Hash{}, err
+		}
+		if contentBirth.Parents[0].UserID != senderID && contentBirth.Parents[1].UserID != senderID {
+			return common.Hash{}, ErrEvidenceNotValid
+		}
+	}
+	return senderID, nil
 }
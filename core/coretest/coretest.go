@@ -0,0 +1,51 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package coretest provides the helpers to create users for test.
+package coretest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+// RootUsers generate the root users in order of female and male, with their private keys
+func RootUsers(t testing.TB) ([]*core.User, []*crypto.PrivateKey) {
+	t.Helper()
+	engine, err := utils.SelectEngine(crypto.PDU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := make([]*core.User, 2)
+	priKeys := make([]*crypto.PrivateKey, 2)
+	for i := 0; users[0] == nil || users[1] == nil; i++ {
+		priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user := core.CreateRootUser(*pubKey, fmt.Sprintf("user%d", i), "extra")
+		gender := 0
+		if user.Gender() {
+			gender = 1
+		}
+		users[gender], priKeys[gender] = user, priKey
+	}
+	return users, priKeys
+}
@@ -0,0 +1,234 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pdupub/go-pdu/common/encoding"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+// Versions of encoding which the IDs and signatures of msg and user are computed over,
+// the version is saved in msg and user, so the universe created before can still be verified.
+const (
+	// EncodingLegacy is computed over the output of fmt and json, which depends on Go
+	EncodingLegacy = iota
+
+	// EncodingV1 is the canonical binary encoding
+	EncodingV1
+
+	// DefaultEncoding is the encoding of new msg and user
+	DefaultEncoding = EncodingV1
+)
+
+// encoder write the canonical binary encoding (EncodingV1) of msg and user. All fields
+// are encoded in order without names, so the encoding can be reproduced by other
+// implementations, the test vectors are in testdata/encoding.json. The primitives
+// are shared with the waves in package common/encoding.
+//
+//	uint64    8 bytes, big endian
+//	int       int64 as uint64 in two's complement
+//	bytes     uint64 length, then the bytes
+//	string    bytes of utf-8
+//	hash      32 bytes
+//	list      uint64 count, then each item
+//	optional  0x00 if nil, else 0x01 then the item
+//
+//	MsgReference  hash SenderID, hash MsgID
+//	MsgValue      int ContentType, bytes Content
+//	Signature     string Source, string SigType, bytes Signature
+//	Auth          string Source, string SigType, list of bytes PubKey
+//	Message       uint64 Version, hash SenderID, list of MsgReference Reference,
+//	              optional MsgValue Value, optional Signature Signature
+//	User          uint64 Version, string Name, string BirthExtra, optional Auth Auth,
+//	              optional Message BirthMsg, uint64 LifeTime
+//
+// The public key in Signature is not encoded, it is always the auth of sender. The public
+// keys of Auth are in the format of crypto engine, such as 65 bytes uncompressed secp256k1
+// point, one key for S2PK and all keys in order for MS.
+//
+// Message.ID is the sha256 of the encoding without signature, and the msg is signed over
+// its ID. User.ID is the sha256 of the encoding, and the parents sign the ID of new user
+// in ContentBirth, before BirthMsg and LifeTime are set.
+type encoder struct {
+	encoding.Writer
+}
+
+func (e *encoder) message(msg *Message) {
+	e.PutUint64(msg.Version)
+	e.PutHash(msg.SenderID)
+	e.PutUint64(uint64(len(msg.Reference)))
+	for _, ref := range msg.Reference {
+		e.PutHash(ref.SenderID)
+		e.PutHash(ref.MsgID)
+	}
+	if e.PutOptional(msg.Value != nil) {
+		e.PutUint64(uint64(int64(msg.Value.ContentType)))
+		e.PutBytes(msg.Value.Content)
+	}
+	if e.PutOptional(msg.Signature != nil) {
+		e.PutString(msg.Signature.Source)
+		e.PutString(msg.Signature.SigType)
+		e.PutBytes(msg.Signature.Signature)
+	}
+}
+
+func (e *encoder) auth(auth *Auth) error {
+	engine, err := utils.SelectEngine(auth.Source)
+	if err != nil {
+		return err
+	}
+	_, pubKeyMap, err := engine.MappingKey(nil, &auth.PublicKey)
+	if err != nil {
+		return err
+	}
+	var pubKeys []string
+	switch pk := pubKeyMap["pubKey"].(type) {
+	case string:
+		pubKeys = []string{pk}
+	case []string:
+		pubKeys = pk
+	default:
+		return crypto.ErrSigTypeNotSupport
+	}
+	e.PutString(auth.Source)
+	e.PutString(auth.SigType)
+	e.PutUint64(uint64(len(pubKeys)))
+	for _, pk := range pubKeys {
+		pkBytes, err := hex.DecodeString(pk)
+		if err != nil {
+			return err
+		}
+		e.PutBytes(pkBytes)
+	}
+	return nil
+}
+
+func (e *encoder) user(u *User) error {
+	e.PutUint64(u.Version)
+	e.PutString(u.Name)
+	e.PutString(u.BirthExtra)
+	if e.PutOptional(u.Auth != nil) {
+		if err := e.auth(u.Auth); err != nil {
+			return err
+		}
+	}
+	if e.PutOptional(u.BirthMsg != nil) {
+		e.message(u.BirthMsg)
+	}
+	e.PutUint64(u.LifeTime)
+	return nil
+}
+
+// EncodeMsg return the canonical binary encoding of msg
+func EncodeMsg(msg *Message) []byte {
+	var e encoder
+	e.message(msg)
+	return e.Bytes()
+}
+
+// EncodeUser return the canonical binary encoding of user
+func EncodeUser(u *User) ([]byte, error) {
+	var e encoder
+	if err := e.user(u); err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+// decoder read the canonical binary encoding, the first error is kept
+type decoder struct {
+	*encoding.Reader
+}
+
+func (d *decoder) message() *Message {
+	msg := &Message{Version: d.GetUint64(), SenderID: d.GetHash()}
+	for n := d.GetUint64(); n > 0 && d.Err() == nil; n-- {
+		msg.Reference = append(msg.Reference, &MsgReference{SenderID: d.GetHash(), MsgID: d.GetHash()})
+	}
+	if d.GetOptional() {
+		msg.Value = &MsgValue{ContentType: int(int64(d.GetUint64())), Content: d.GetBytes()}
+	}
+	if d.GetOptional() {
+		msg.Signature = &crypto.Signature{PublicKey: crypto.PublicKey{Source: d.GetString(), SigType: d.GetString()}}
+		msg.Signature.Signature = d.GetBytes()
+	}
+	return msg
+}
+
+// auth rebuild the public keys by crypto engine from the json format of Auth
+func (d *decoder) auth() *Auth {
+	authMap := map[string]interface{}{"source": d.GetString(), "sigType": d.GetString()}
+	var pubKeys []string
+	for n := d.GetUint64(); n > 0 && d.Err() == nil; n-- {
+		pubKeys = append(pubKeys, hex.EncodeToString(d.GetBytes()))
+	}
+	if d.Err() != nil {
+		return nil
+	}
+	if authMap["sigType"] == crypto.Signature2PublicKey && len(pubKeys) == 1 {
+		authMap["pubKey"] = pubKeys[0]
+	} else {
+		authMap["pubKey"] = pubKeys
+	}
+	authBytes, err := json.Marshal(authMap)
+	if err != nil {
+		d.Fail(err)
+		return nil
+	}
+	var auth Auth
+	if err := json.Unmarshal(authBytes, &auth); err != nil {
+		d.Fail(err)
+		return nil
+	}
+	return &auth
+}
+
+func (d *decoder) user() *User {
+	u := &User{Version: d.GetUint64(), Name: d.GetString(), BirthExtra: d.GetString()}
+	if d.GetOptional() {
+		u.Auth = d.auth()
+	}
+	if d.GetOptional() {
+		u.BirthMsg = d.message()
+	}
+	u.LifeTime = d.GetUint64()
+	return u
+}
+
+// DecodeMsg decode the msg from canonical binary encoding
+func DecodeMsg(data []byte) (*Message, error) {
+	d := &decoder{encoding.NewReader(data)}
+	msg := d.message()
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// DecodeUser decode the user from canonical binary encoding
+func DecodeUser(data []byte) (*User, error) {
+	d := &decoder{encoding.NewReader(data)}
+	u := d.user()
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
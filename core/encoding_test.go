@@ -0,0 +1,245 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/pdu"
+)
+
+// encodingVector is the test vector of encoding, all bytes and hashes are in hex,
+// and auth is in the json format of Auth. SigInput is the input signed by the sender
+// of msg or the parents of user, and the signature of msg is verified by signer if set.
+type encodingVector struct {
+	Name     string          `json:"name"`
+	Msg      *msgVector      `json:"msg,omitempty"`
+	User     *userVector     `json:"user,omitempty"`
+	Signer   json.RawMessage `json:"signer,omitempty"`
+	SigInput string          `json:"sigInput,omitempty"`
+	Encoding string          `json:"encoding"`
+	ID       string          `json:"id"`
+}
+
+type msgVector struct {
+	Version   uint64 `json:"version"`
+	SenderID  string `json:"senderID"`
+	Reference []struct {
+		SenderID string `json:"senderID"`
+		MsgID    string `json:"msgID"`
+	} `json:"reference"`
+	Value *struct {
+		ContentType int    `json:"contentType"`
+		Content     string `json:"content"`
+	} `json:"value"`
+	Signature *struct {
+		Source    string `json:"source"`
+		SigType   string `json:"sigType"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+}
+
+type userVector struct {
+	Version    uint64          `json:"version"`
+	Name       string          `json:"name"`
+	BirthExtra string          `json:"extra"`
+	Auth       json.RawMessage `json:"auth"`
+	BirthMsg   *msgVector      `json:"birthMsg"`
+	LifeTime   uint64          `json:"lifeTime"`
+}
+
+func (v *msgVector) message(t *testing.T) *Message {
+	var err error
+	msg := &Message{Version: v.Version}
+	if msg.SenderID, err = common.String2Hash(v.SenderID); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range v.Reference {
+		ref := &MsgReference{}
+		if ref.SenderID, err = common.String2Hash(r.SenderID); err != nil {
+			t.Fatal(err)
+		}
+		if ref.MsgID, err = common.String2Hash(r.MsgID); err != nil {
+			t.Fatal(err)
+		}
+		msg.Reference = append(msg.Reference, ref)
+	}
+	if v.Value != nil {
+		msg.Value = &MsgValue{ContentType: v.Value.ContentType}
+		if msg.Value.Content, err = hex.DecodeString(v.Value.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.Signature != nil {
+		msg.Signature = &crypto.Signature{PublicKey: crypto.PublicKey{Source: v.Signature.Source, SigType: v.Signature.SigType}}
+		if msg.Signature.Signature, err = hex.DecodeString(v.Signature.Signature); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return msg
+}
+
+func (v *userVector) user(t *testing.T) *User {
+	user := &User{Version: v.Version, Name: v.Name, BirthExtra: v.BirthExtra, LifeTime: v.LifeTime}
+	if v.Auth != nil {
+		if err := json.Unmarshal(v.Auth, &user.Auth); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.BirthMsg != nil {
+		user.BirthMsg = v.BirthMsg.message(t)
+	}
+	return user
+}
+
+func TestEncodingVectors(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/encoding.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []*encodingVector
+	if err := json.Unmarshal(content, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vectors {
+		var encoding, sigInput []byte
+		var id common.Hash
+		if v.Msg != nil {
+			msg := v.Msg.message(t)
+			if msg.Version != EncodingLegacy {
+				encoding = EncodeMsg(msg)
+			}
+			id = msg.ID()
+			if sigInput, err = msg.sigHash(); err != nil {
+				t.Fatal(v.Name, err)
+			}
+			if v.Signer != nil {
+				var signer Auth
+				if err := json.Unmarshal(v.Signer, &signer); err != nil {
+					t.Fatal(v.Name, err)
+				}
+				if ok, err := signer.Verify(sigInput, msg.Signature); err != nil || !ok {
+					t.Errorf("%s : signature should be verified, %v", v.Name, err)
+				}
+			}
+		} else {
+			user := v.User.user(t)
+			if user.Version != EncodingLegacy {
+				if encoding, err = EncodeUser(user); err != nil {
+					t.Fatal(v.Name, err)
+				}
+			}
+			id = user.ID()
+			if sigInput, err = (ContentBirth{User: *user}).sigHash(); err != nil {
+				t.Fatal(v.Name, err)
+			}
+		}
+		if v.SigInput != "" && hex.EncodeToString(sigInput) != v.SigInput {
+			t.Errorf("%s : sig input should be %s, but %x", v.Name, v.SigInput, sigInput)
+		}
+		if hex.EncodeToString(encoding) != v.Encoding {
+			t.Errorf("%s : encoding should be %s, but %x", v.Name, v.Encoding, encoding)
+		}
+		if common.Hash2String(id) != v.ID {
+			t.Errorf("%s : id should be %s, but %s", v.Name, v.ID, common.Hash2String(id))
+		}
+	}
+}
+
+func TestEncodingVersion(t *testing.T) {
+	engine := pdu.New()
+	priKey, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := CreateRootUser(*pubKey, "user", "extra")
+	if user.Version != DefaultEncoding {
+		t.Error("root user should be created by default encoding")
+	}
+	// version is kept by json
+	userBytes, err := json.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userLoad User
+	if err := json.Unmarshal(userBytes, &userLoad); err != nil {
+		t.Fatal(err)
+	}
+	if userLoad.Version != user.Version || userLoad.ID() != user.ID() {
+		t.Error("user should be same after json")
+	}
+
+	msg, err := CreateMsg(user, &MsgValue{ContentType: TypeText, Content: []byte("hello")}, priKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Version != DefaultEncoding {
+		t.Error("msg should be created by default encoding")
+	}
+	// id is not related to signature
+	unsigned := *msg
+	unsigned.Signature = nil
+	if unsigned.ID() != msg.ID() {
+		t.Error("id should not be changed by signature")
+	}
+
+	// msg signed by legacy encoding can still be verified
+	legacy := *msg
+	legacy.Version = EncodingLegacy
+	legacy.Signature = nil
+	sigHash, err := legacy.sigHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Signature, err = engine.Sign(sigHash, priKey); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.ID() == msg.ID() {
+		t.Error("id should be different by encoding")
+	}
+	// public key is not contained in signature of msg
+	verify := func(m Message) (bool, error) {
+		signature := *m.Signature
+		signature.PubKey = pubKey.PubKey
+		m.Signature = &signature
+		return VerifyMsg(m)
+	}
+	for _, m := range []*Message{msg, &legacy} {
+		if ok, err := verify(*m); err != nil || !ok {
+			t.Errorf("msg of version %d should be verified, %v", m.Version, err)
+		}
+	}
+	// signature is not valid for other encoding
+	legacy.Version = EncodingV1
+	if ok, _ := verify(legacy); ok {
+		t.Error("signature of legacy encoding should not be verified as v1")
+	}
+
+	unknown := *msg
+	unknown.Version = DefaultEncoding + 1
+	if unknown.ID() != (common.Hash{}) {
+		t.Error("id of unknown version should be empty")
+	}
+	if _, err := verify(unknown); err != ErrEncodingNotSupport {
+		t.Errorf("error should be %s, but %v", ErrEncodingNotSupport, err)
+	}
+}
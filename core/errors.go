@@ -16,7 +16,11 @@
 
 package core
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/pdupub/go-pdu/common/encoding"
+)
 
 var (
 	// ErrUserNotExist returns fail to find a user
@@ -52,9 +56,58 @@ var (
 	// ErrContentTypeNotBirth returns when try to add user from a not birth message
 	ErrContentTypeNotBirth = errors.New("content type is not TypeBirth")
 
+	// ErrParentGenderNotValid returns when parents of birth msg are not female and male in order
+	ErrParentGenderNotValid = errors.New("parent gender not valid")
+
+	// ErrSenderNotParent returns when the birth msg is not sent by one of parents
+	ErrSenderNotParent = errors.New("sender is not parent")
+
+	// ErrParentSignatureMissing returns when the birth msg content is not signed by parent
+	ErrParentSignatureMissing = errors.New("parent signature missing")
+
+	// ErrParentSignatureInvalid returns when the parent signature can not be verified by the auth of parent
+	ErrParentSignatureInvalid = errors.New("parent signature invalid")
+
 	// ErrDimensionNumberNotSuitable returns if the dimension is zero or too large
 	ErrDimensionNumberNotSuitable = errors.New("number of dimension is not suitable")
 
 	// ErrPerimeterIsZero returns if perimeter is zero
 	ErrPerimeterIsZero = errors.New("perimeter should not be zero")
+
+	// ErrMsgSignatureMissing returns when the message do not contain signature
+	ErrMsgSignatureMissing = errors.New("msg signature missing")
+
+	// ErrMsgSignatureNotMatchAuth returns when the source or type of signature not match the auth of sender
+	ErrMsgSignatureNotMatchAuth = errors.New("msg signature not match sender auth")
+
+	// ErrMsgSignatureInvalid returns when the signature can not be verified by the auth of sender
+	ErrMsgSignatureInvalid = errors.New("msg signature invalid")
+
+	// ErrUserPunished returns when the user has been punished in all space time
+	ErrUserPunished = errors.New("user has been punished")
+
+	// ErrEvidenceTypeNotSupport returns when the type of evidence is unknown
+	ErrEvidenceTypeNotSupport = errors.New("evidence type not support")
+
+	// ErrEvidenceNotValid returns when the evidence can not prove the illegal behavior in any space time
+	ErrEvidenceNotValid = errors.New("evidence not valid")
+
+	// ErrEncodingNotSupport returns when the encoding version of msg or user is unknown
+	ErrEncodingNotSupport = errors.New("encoding version not support")
+
+	// ErrEncodingNotValid returns when the bytes can not be decoded as canonical binary encoding
+	ErrEncodingNotValid = encoding.ErrNotValid
+
+	// ErrCodecNotSupport returns when the codec of msg or user is unknown
+	ErrCodecNotSupport = errors.New("codec not support")
+
+	// ErrSnapshotNotAvailable returns when the universe can not create snapshot yet
+	ErrSnapshotNotAvailable = errors.New("snapshot not available")
+
+	// ErrSnapshotNotValid returns when the universe can not be rebuilt from snapshot
+	ErrSnapshotNotValid = errors.New("snapshot not valid")
+
+	// ErrSnapshotMsgNotSaved returns when the msg in universe is not saved yet,
+	// the snapshot can not be rebuilt without it
+	ErrSnapshotMsgNotSaved = errors.New("msg of snapshot not saved")
 )
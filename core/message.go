@@ -28,6 +28,7 @@ import (
 
 // Message is valid msg in pdu
 type Message struct {
+	Version   uint64            `json:"version,omitempty"`
 	SenderID  common.Hash       `json:"senderID"`
 	Reference []*MsgReference   `json:"reference"`
 	Value     *MsgValue         `json:"value"`
@@ -53,6 +54,7 @@ func CreateMsg(user *User, value *MsgValue, priKey *crypto.PrivateKey, refs ...*
 	}
 
 	msg := &Message{
+		Version:   DefaultEncoding,
 		SenderID:  user.ID(),
 		Reference: rs,
 		Value:     v,
@@ -64,11 +66,11 @@ func CreateMsg(user *User, value *MsgValue, priKey *crypto.PrivateKey, refs ...*
 		return nil, err
 	}
 
-	jsonMsg, err := json.Marshal(msg)
+	sigHash, err := msg.sigHash()
 	if err != nil {
 		return nil, err
 	}
-	sig, err := engine.Sign(jsonMsg, priKey)
+	sig, err := engine.Sign(sigHash, priKey)
 	if err != nil {
 		return nil, err
 	}
@@ -85,25 +87,49 @@ func VerifyMsg(msg Message) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	jsonMsg, err := json.Marshal(&msg)
+	sigHash, err := msg.sigHash()
 	if err != nil {
 		return false, err
 	}
-	return engine.Verify(jsonMsg, signature)
+	return engine.Verify(sigHash, signature)
 
 }
 
-// ID is the id of msg based on content and author info
+// sigHash return the input of msg (without signature) which is used to sign or verify.
+// The msg of EncodingLegacy is signed on its json as before, so the msg already
+// signed can still be verified. Some of engines only use the first 32 bytes of input,
+// so the msg of EncodingV1 is hashed.
+func (msg Message) sigHash() ([]byte, error) {
+	msg.Signature = nil
+	switch msg.Version {
+	case EncodingLegacy:
+		return json.Marshal(&msg)
+	case EncodingV1:
+		hash := sha256.Sum256(EncodeMsg(&msg))
+		return hash[:], nil
+	}
+	return nil, ErrEncodingNotSupport
+}
+
+// ID is the id of msg based on content and author info,
+// empty hash is returned if the encoding version is not supported
 func (msg Message) ID() common.Hash {
-	hash := sha256.New()
-	hash.Reset()
-	var ref string
-	for _, r := range msg.Reference {
-		ref += fmt.Sprintf("%v%v", r.SenderID, r.MsgID)
+	switch msg.Version {
+	case EncodingLegacy:
+		hash := sha256.New()
+		hash.Reset()
+		var ref string
+		for _, r := range msg.Reference {
+			ref += fmt.Sprintf("%v%v", r.SenderID, r.MsgID)
+		}
+		val := fmt.Sprintf("%v", msg.Value)
+		hash.Write(append(append(msg.SenderID[:], ref...), val...))
+		return common.Bytes2Hash(hash.Sum(nil))
+	case EncodingV1:
+		msg.Signature = nil
+		return sha256.Sum256(EncodeMsg(&msg))
 	}
-	val := fmt.Sprintf("%v", msg.Value)
-	hash.Write(append(append(msg.SenderID[:], ref...), val...))
-	return common.Bytes2Hash(hash.Sum(nil))
+	return common.Hash{}
 }
 
 // ParentsID return the parents id
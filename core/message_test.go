@@ -15,3 +15,64 @@
 // along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
 
 package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+)
+
+// legacyData is the users and msgs created and signed before the encoding version is added
+type legacyData struct {
+	Users []struct {
+		Name string `json:"name"`
+		User *User  `json:"user"`
+		ID   string `json:"id"`
+	} `json:"users"`
+	Msgs []struct {
+		Name string   `json:"name"`
+		Msg  *Message `json:"msg"`
+		ID   string   `json:"id"`
+	} `json:"msgs"`
+}
+
+func loadLegacyData(t *testing.T) *legacyData {
+	content, err := ioutil.ReadFile("testdata/legacy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := new(legacyData)
+	if err := json.Unmarshal(content, data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifyMsg_Legacy(t *testing.T) {
+	data := loadLegacyData(t)
+	universe, err := NewUniverse(data.Users[0].User, data.Users[1].User)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range data.Msgs {
+		if v.Msg.Version != EncodingLegacy {
+			t.Fatalf("%s : version should be legacy", v.Name)
+		}
+		if id := common.Hash2String(v.Msg.ID()); id != v.ID {
+			t.Errorf("%s : id should be %s, but %s", v.Name, v.ID, id)
+		}
+		if err := universe.verifyMsgSignature(v.Msg); err != nil {
+			t.Errorf("%s : verify fail, %s", v.Name, err)
+		}
+		// the signature signed by any other key should fail the verification
+		tampered := *v.Msg
+		tampered.Signature = &crypto.Signature{PublicKey: v.Msg.Signature.PublicKey, Signature: append([]byte{}, v.Msg.Signature.Signature...)}
+		tampered.Signature.Signature[0]++
+		if err := universe.verifyMsgSignature(&tampered); err == nil {
+			t.Errorf("%s : tampered signature should not be verified", v.Name)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	dag "github.com/pdupub/go-dag"
+	"github.com/pdupub/go-pdu/common"
+)
+
+// Snapshot is the serializable checkpoint of universe. The messages in msgD are
+// only kept by ID, the content of message should be loaded from local db, so the
+// universe can be rebuilt without verify and process each message again.
+type Snapshot struct {
+	Users      []*User              `json:"users"`
+	MsgIDs     []common.Hash        `json:"msgIDs"`
+	SpaceTimes []*SnapshotSpaceTime `json:"spaceTimes"`
+}
+
+// SnapshotSpaceTime is the serializable state of one space time
+type SnapshotSpaceTime struct {
+	ID              common.Hash          `json:"id"`
+	ParentIDs       []common.Hash        `json:"parentIDs"`
+	MaxTimeSequence uint64               `json:"maxTimeSequence"`
+	TimeProofs      []*SnapshotTimeProof `json:"timeProofs"`
+	UserInfos       []*SnapshotUserInfo  `json:"userInfos"`
+}
+
+// SnapshotTimeProof is the time sequence of msg in space time
+type SnapshotTimeProof struct {
+	MsgID    common.Hash  `json:"msgID"`
+	Sequence uint64       `json:"sequence"`
+	RefID    *common.Hash `json:"refID"`
+}
+
+// SnapshotUserInfo is the user info of user in space time
+type SnapshotUserInfo struct {
+	UserID           common.Hash   `json:"userID"`
+	ParentIDs        []common.Hash `json:"parentIDs"`
+	NatureState      int           `json:"natureState"`
+	NatureLastCosign uint64        `json:"natureLastCosign"`
+	NatureLifeMaxSeq uint64        `json:"natureLifeMaxSeq"`
+	NatureBirthSeq   uint64        `json:"natureBirthSeq"`
+	LocalNickname    string        `json:"localNickname"`
+}
+
+// MsgLoader is used to load the message by ID when rebuild universe from snapshot
+type MsgLoader func(msgID common.Hash) (*Message, error)
+
+// MsgChecker is used to check if the message is saved when create snapshot
+type MsgChecker func(msgID common.Hash) (bool, error)
+
+// Snapshot create the checkpoint of current universe, all the messages in
+// universe must be saved (checked by isSaved), because the msgs, time proofs
+// and users after the unsaved one can not be rebuilt without it.
+func (u Universe) Snapshot(isSaved MsgChecker) (*Snapshot, error) {
+	if u.msgD == nil || u.stD == nil {
+		return nil, ErrSnapshotNotAvailable
+	}
+	snapshot := &Snapshot{}
+	for _, id := range u.userD.GetIDs() {
+		snapshot.Users = append(snapshot.Users, u.userD.GetVertex(id).Value().(*User))
+	}
+	for _, id := range u.msgD.GetIDs() {
+		saved, err := isSaved(id.(common.Hash))
+		if err != nil {
+			return nil, err
+		}
+		if !saved {
+			return nil, ErrSnapshotMsgNotSaved
+		}
+		snapshot.MsgIDs = append(snapshot.MsgIDs, id.(common.Hash))
+	}
+	for _, id := range u.stD.GetIDs() {
+		stVertex := u.stD.GetVertex(id)
+		st := stVertex.Value().(*SpaceTime)
+		sst := &SnapshotSpaceTime{ID: id.(common.Hash), ParentIDs: hashIDs(stVertex.ParentIDs()), MaxTimeSequence: st.maxTimeSequence}
+		for _, msgID := range st.timeProofD.GetIDs() {
+			tpVertex := st.timeProofD.GetVertex(msgID)
+			tp := &SnapshotTimeProof{MsgID: msgID.(common.Hash), Sequence: tpVertex.Value().(uint64)}
+			if refIDs := hashIDs(tpVertex.ParentIDs()); len(refIDs) > 0 {
+				tp.RefID = &refIDs[0]
+			}
+			sst.TimeProofs = append(sst.TimeProofs, tp)
+		}
+		for _, userID := range st.userStateD.GetIDs() {
+			uVertex := st.userStateD.GetVertex(userID)
+			ui := uVertex.Value().(*UserInfo)
+			sst.UserInfos = append(sst.UserInfos, &SnapshotUserInfo{
+				UserID:           userID.(common.Hash),
+				ParentIDs:        hashIDs(uVertex.ParentIDs()),
+				NatureState:      ui.natureState,
+				NatureLastCosign: ui.natureLastCosign,
+				NatureLifeMaxSeq: ui.natureLifeMaxSeq,
+				NatureBirthSeq:   ui.natureBirthSeq,
+				LocalNickname:    ui.localNickname,
+			})
+		}
+		snapshot.SpaceTimes = append(snapshot.SpaceTimes, sst)
+	}
+	return snapshot, nil
+}
+
+// NewUniverseFromSnapshot rebuild the universe from snapshot, the messages are loaded by loadMsg.
+func NewUniverseFromSnapshot(snapshot *Snapshot, loadMsg MsgLoader) (*Universe, error) {
+	if snapshot == nil || len(snapshot.Users) < 2 || len(snapshot.MsgIDs) == 0 || len(snapshot.SpaceTimes) == 0 {
+		return nil, ErrSnapshotNotValid
+	}
+	u, err := NewUniverse(snapshot.Users[0], snapshot.Users[1])
+	if err != nil {
+		return nil, err
+	}
+	// rebuild userD
+	for _, user := range snapshot.Users[2:] {
+		parentsID := user.ParentsID()
+		userVertex, err := dag.NewVertex(user.ID(), user, parentsID[0], parentsID[1])
+		if err != nil {
+			return nil, err
+		}
+		if err := u.userD.AddVertex(userVertex); err != nil {
+			return nil, err
+		}
+	}
+	// rebuild msgD
+	for i, msgID := range snapshot.MsgIDs {
+		msg, err := loadMsg(msgID)
+		if err != nil {
+			return nil, err
+		}
+		if msg.ID() != msgID {
+			return nil, ErrSnapshotNotValid
+		}
+		if i == 0 {
+			if err := u.initializeMsgD(msg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		msgVertex, err := dag.NewVertex(msg.ID(), msg, hashRefs(msg.ParentsID())...)
+		if err != nil {
+			return nil, err
+		}
+		if err := u.msgD.AddVertex(msgVertex); err != nil {
+			return nil, err
+		}
+		u.tips.update(msgVertex)
+	}
+	// rebuild stD
+	for _, sst := range snapshot.SpaceTimes {
+		st, err := newSpaceTimeFromSnapshot(sst)
+		if err != nil {
+			return nil, err
+		}
+		stVertex, err := dag.NewVertex(sst.ID, st, hashRefs(sst.ParentIDs)...)
+		if err != nil {
+			return nil, err
+		}
+		if u.stD == nil {
+			if u.stD, err = dag.NewDAG(1, stVertex); err != nil {
+				return nil, err
+			}
+		} else if err := u.stD.AddVertex(stVertex); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// newSpaceTimeFromSnapshot rebuild the time proof and user state of space time
+func newSpaceTimeFromSnapshot(sst *SnapshotSpaceTime) (*SpaceTime, error) {
+	if len(sst.TimeProofs) == 0 {
+		return nil, ErrSnapshotNotValid
+	}
+	st := &SpaceTime{maxTimeSequence: sst.MaxTimeSequence}
+	for i, tp := range sst.TimeProofs {
+		if i == 0 {
+			timeVertex, err := dag.NewVertex(tp.MsgID, tp.Sequence)
+			if err != nil {
+				return nil, err
+			}
+			if st.timeProofD, err = dag.NewDAG(1, timeVertex); err != nil {
+				return nil, err
+			}
+			st.timeProofD.RemoveStrict()
+			continue
+		}
+		// same as UpdateTimeProof, the reference is nil if not exist
+		var ref interface{}
+		if tp.RefID != nil {
+			ref = *tp.RefID
+		}
+		timeVertex, err := dag.NewVertex(tp.MsgID, tp.Sequence, ref)
+		if err != nil {
+			return nil, err
+		}
+		if err := st.timeProofD.AddVertex(timeVertex); err != nil {
+			return nil, err
+		}
+	}
+	userStateD, err := dag.NewDAG(2)
+	if err != nil {
+		return nil, err
+	}
+	userStateD.SetMaxParentsCount(2)
+	for _, sui := range sst.UserInfos {
+		userInfo := &UserInfo{
+			natureState:      sui.NatureState,
+			natureLastCosign: sui.NatureLastCosign,
+			natureLifeMaxSeq: sui.NatureLifeMaxSeq,
+			natureBirthSeq:   sui.NatureBirthSeq,
+			localNickname:    sui.LocalNickname,
+		}
+		userStateVertex, err := dag.NewVertex(sui.UserID, userInfo, hashRefs(sui.ParentIDs)...)
+		if err != nil {
+			return nil, err
+		}
+		if err := userStateD.AddVertex(userStateVertex); err != nil {
+			return nil, err
+		}
+	}
+	st.userStateD = userStateD
+	return st, nil
+}
+
+// hashIDs convert the vertex IDs to hash, the nil ID is ignored
+func hashIDs(ids []interface{}) (hashes []common.Hash) {
+	for _, id := range ids {
+		if h, ok := id.(common.Hash); ok {
+			hashes = append(hashes, h)
+		}
+	}
+	return hashes
+}
+
+// hashRefs convert the hash to vertex IDs
+func hashRefs(hashes []common.Hash) (refs []interface{}) {
+	for _, h := range hashes {
+		refs = append(refs, h)
+	}
+	return refs
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+func TestUniverse_Snapshot(t *testing.T) {
+	universeEngine, _ = utils.SelectEngine(defaultEngineName)
+	AdamS, EveS, priKeyAdamS, priKeyEveS, err := createAdamAndEve()
+	if err != nil {
+		t.Error("create root user fail", err)
+	}
+	universeS, err := NewUniverse(EveS, AdamS)
+	if err != nil {
+		t.Error("create universe fail", err)
+	}
+	msgs := make(map[common.Hash]*Message)
+	isSaved := func(msgID common.Hash) (bool, error) {
+		_, ok := msgs[msgID]
+		return ok, nil
+	}
+	if _, err := universeS.Snapshot(isSaved); err != ErrSnapshotNotAvailable {
+		t.Errorf("snapshot err should be %s, but now err : %v", ErrSnapshotNotAvailable, err)
+	}
+
+	addMsg := func(user *User, priKey *crypto.PrivateKey, content string, refs ...*MsgReference) *Message {
+		v := MsgValue{ContentType: TypeText, Content: []byte(content)}
+		msg, err := CreateMsg(user, &v, priKey, refs...)
+		if err != nil {
+			t.Error("create msg fail", err)
+		}
+		if err := universeS.AddMsg(msg); err != nil {
+			t.Error("add msg fail", err)
+		}
+		msgs[msg.ID()] = msg
+		return msg
+	}
+
+	// time proof of Adam, and one new space time base on Eve
+	lastAdam := addMsg(AdamS, priKeyAdamS, "first msg")
+	lastEve := addMsg(EveS, priKeyEveS, "msg from Eve", &MsgReference{SenderID: AdamS.ID(), MsgID: lastAdam.ID()})
+	for i := 0; i < 10; i++ {
+		lastAdam = addMsg(AdamS, priKeyAdamS, fmt.Sprintf("msg:%d", i), &MsgReference{SenderID: AdamS.ID(), MsgID: lastAdam.ID()})
+	}
+	refAdam := &MsgReference{SenderID: AdamS.ID(), MsgID: lastAdam.ID()}
+	msgNewST := addMsg(EveS, priKeyEveS, "new space time", &MsgReference{SenderID: EveS.ID(), MsgID: lastEve.ID()}, refAdam)
+	if err := universeS.AddSpaceTime(msgNewST, refAdam); err != nil {
+		t.Error("add space time fail", err)
+	}
+
+	snapshot, err := universeS.Snapshot(isSaved)
+	if err != nil {
+		t.Error("create snapshot fail", err)
+	}
+	// the msg not saved, which has saved child, stop the snapshot
+	if _, err := universeS.Snapshot(func(msgID common.Hash) (bool, error) {
+		return msgID != lastEve.ID(), nil
+	}); err != ErrSnapshotMsgNotSaved {
+		t.Errorf("snapshot err should be %s, but now err : %v", ErrSnapshotMsgNotSaved, err)
+	}
+	if _, err := universeS.Snapshot(func(common.Hash) (bool, error) {
+		return false, ErrMsgNotFound
+	}); err != ErrMsgNotFound {
+		t.Errorf("snapshot err should be %s, but now err : %v", ErrMsgNotFound, err)
+	}
+	snapshotBytes, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Error("marshal snapshot fail", err)
+	}
+	var snapshot2 Snapshot
+	if err := json.Unmarshal(snapshotBytes, &snapshot2); err != nil {
+		t.Error("unmarshal snapshot fail", err)
+	}
+	loader := func(msgID common.Hash) (*Message, error) {
+		if msg, ok := msgs[msgID]; ok {
+			return msg, nil
+		}
+		return nil, ErrMsgNotFound
+	}
+	universeR, err := NewUniverseFromSnapshot(&snapshot2, loader)
+	if err != nil {
+		t.Error("rebuild universe from snapshot fail", err)
+	}
+	compareUniverse(t, universeS, universeR)
+
+	// both universe should accept same new msg
+	msgNew, err := CreateMsg(EveS, &MsgValue{ContentType: TypeText, Content: []byte("after snapshot")}, priKeyEveS, &MsgReference{SenderID: EveS.ID(), MsgID: msgNewST.ID()})
+	if err != nil {
+		t.Error("create msg fail", err)
+	}
+	if err := universeS.AddMsg(msgNew); err != nil {
+		t.Error("add msg fail", err)
+	}
+	if err := universeR.AddMsg(msgNew); err != nil {
+		t.Error("add msg to rebuilt universe fail", err)
+	}
+	compareUniverse(t, universeS, universeR)
+
+	// message missing
+	delete(msgs, lastEve.ID())
+	if _, err := NewUniverseFromSnapshot(&snapshot2, loader); err != ErrMsgNotFound {
+		t.Errorf("rebuild err should be %s, but now err : %v", ErrMsgNotFound, err)
+	}
+}
+
+func compareUniverse(t *testing.T, u1, u2 *Universe) {
+	if len(u1.msgD.GetIDs()) != len(u2.msgD.GetIDs()) {
+		t.Error("msg count not match")
+	}
+	tips1, tips2 := u1.GetTips(), u2.GetTips()
+	if len(tips1) != len(tips2) {
+		t.Error("tips count not match")
+	}
+	for i := range tips1 {
+		if i < len(tips2) && tips1[i] != tips2[i] {
+			t.Error("tips not match", common.Hash2String(tips1[i]))
+		}
+	}
+	if len(u1.GetAllUserIDs()) != len(u2.GetAllUserIDs()) {
+		t.Error("user count not match")
+	}
+	stIDs := u1.GetSpaceTimeIDs()
+	if len(stIDs) != len(u2.GetSpaceTimeIDs()) {
+		t.Error("space time count not match")
+	}
+	for _, stID := range stIDs {
+		if u1.GetMaxSeq(stID) != u2.GetMaxSeq(stID) {
+			t.Error("max seq not match", common.Hash2String(stID))
+		}
+		for _, userID := range u1.GetUserIDs(stID) {
+			ui1, ui2 := u1.GetUserInfo(userID, stID), u2.GetUserInfo(userID, stID)
+			if ui2 == nil || ui1.String() != ui2.String() {
+				t.Error("user info not match", common.Hash2String(userID))
+			}
+		}
+	}
+}
@@ -105,8 +105,12 @@ func (s *SpaceTime) createUserStateD(st *SpaceTime, ref *MsgReference, userD *da
 	refSeq := st.timeProofD.GetVertex(ref.MsgID).Value().(uint64)
 	refUserStateD := st.userStateD
 	for _, k := range refUserStateD.GetIDs() {
-		lifeMaxSeq := refUserStateD.GetVertex(k).Value().(*UserInfo).natureLifeMaxSeq - refSeq
-		userStateVertex, err := dag.NewVertex(k, NewUserInfo(userD.GetVertex(k).Value().(*User).Name, lifeMaxSeq, 0), userD.GetVertex(k).ParentIDs()...)
+		refUserInfo := refUserStateD.GetVertex(k).Value().(*UserInfo)
+		lifeMaxSeq := refUserInfo.natureLifeMaxSeq - refSeq
+		userInfo := NewUserInfo(userD.GetVertex(k).Value().(*User).Name, lifeMaxSeq, 0)
+		// punishment in parent space time is kept
+		userInfo.natureState = refUserInfo.natureState
+		userStateVertex, err := dag.NewVertex(k, userInfo, userD.GetVertex(k).ParentIDs()...)
 		if err != nil {
 			return err
 		}
@@ -150,6 +154,13 @@ func (s SpaceTime) GetUserInfo(userID common.Hash) *UserInfo {
 	return nil
 }
 
+// UserPunished return true if the user has been punished in this space time,
+// the msgs from this user take no effect in this space time
+func (s SpaceTime) UserPunished(userID common.Hash) bool {
+	userInfo := s.GetUserInfo(userID)
+	return userInfo != nil && userInfo.Punished()
+}
+
 // UpdateTimeProof update the tp info
 func (s *SpaceTime) UpdateTimeProof(msg *Message) error {
 	var currentSeq uint64 = 1
@@ -181,35 +192,78 @@ func (s *SpaceTime) UpdateTimeProof(msg *Message) error {
 
 // AddUser add user info to this space time
 func (s *SpaceTime) AddUser(ref *MsgReference, contentBirth ContentBirth, user *User) error {
-	if tp := s.timeProofD.GetVertex(ref.MsgID); tp != nil {
-		msgSeq := tp.Value().(uint64)
-		p0 := s.userStateD.GetVertex(contentBirth.Parents[0].UserID)
-		if p0 == nil {
-			return ErrAddUserToSpaceTimeFail
+	msgSeq, p0, p1, err := s.checkBirth(ref, contentBirth)
+	if err != nil {
+		return err
+	}
+	// update nature last cosign number as msgSeq
+	p0.Value().(*UserInfo).natureLastCosign = msgSeq
+	p1.Value().(*UserInfo).natureLastCosign = msgSeq
+	// add user in this st
+	userVertex, err := dag.NewVertex(user.ID(), NewUserInfo(user.Name, user.LifeTime, msgSeq), p0, p1)
+	if err != nil {
+		return err
+	}
+	if err := s.userStateD.AddVertex(userVertex); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkBirth check both parents can cosign at the time sequence of ref in this
+// space time, return the sequence and vertex of parents.
+func (s SpaceTime) checkBirth(ref *MsgReference, contentBirth ContentBirth) (uint64, *dag.Vertex, *dag.Vertex, error) {
+	tp := s.timeProofD.GetVertex(ref.MsgID)
+	if tp == nil {
+		return 0, nil, nil, ErrAddUserToSpaceTimeFail
+	}
+	msgSeq := tp.Value().(uint64)
+	p0 := s.userStateD.GetVertex(contentBirth.Parents[0].UserID)
+	if p0 == nil {
+		return 0, nil, nil, ErrAddUserToSpaceTimeFail
+	}
+	p1 := s.userStateD.GetVertex(contentBirth.Parents[1].UserID)
+	if p1 == nil {
+		return 0, nil, nil, ErrAddUserToSpaceTimeFail
+	}
+	if !p0.Value().(*UserInfo).CanCosign(msgSeq) || !p1.Value().(*UserInfo).CanCosign(msgSeq) {
+		return 0, nil, nil, ErrAddUserToSpaceTimeFail
+	}
+	return msgSeq, p0, p1, nil
+}
+
+// excessiveBirth return true if any two of msgs cosigned in this space time
+// against the rule.ReproductionInterval.
+func (s SpaceTime) excessiveBirth(stID common.Hash, msgs []*Message) bool {
+	var seqs []uint64
+	for _, msg := range msgs {
+		var seq uint64
+		for _, r := range msg.Reference {
+			if r.SenderID != stID {
+				continue
+			}
+			if tp := s.timeProofD.GetVertex(r.MsgID); tp != nil && tp.Value().(uint64) > seq {
+				seq = tp.Value().(uint64)
+			}
 		}
-		userInfo0 := p0.Value().(*UserInfo)
-		p1 := s.userStateD.GetVertex(contentBirth.Parents[1].UserID)
-		if p1 == nil {
-			return ErrAddUserToSpaceTimeFail
+		if seq == 0 {
+			continue
 		}
-		userInfo1 := p1.Value().(*UserInfo)
-		if userInfo0.natureBirthSeq+userInfo0.natureLifeMaxSeq > msgSeq &&
-			userInfo1.natureBirthSeq+userInfo1.natureLifeMaxSeq > msgSeq &&
-			msgSeq-userInfo0.natureLastCosign > rule.ReproductionInterval &&
-			msgSeq-userInfo1.natureLastCosign > rule.ReproductionInterval {
-			// update nature last cosign number as msgSeq
-			userInfo0.natureLastCosign = msgSeq
-			userInfo1.natureLastCosign = msgSeq
-			// add user in this st
-			userVertex, err := dag.NewVertex(user.ID(), NewUserInfo(user.Name, user.LifeTime, msgSeq), p0, p1)
-			if err != nil {
-				return err
-			}
-			if err := s.userStateD.AddVertex(userVertex); err != nil {
-				return err
+		for _, v := range seqs {
+			if (seq >= v && seq-v <= rule.ReproductionInterval) || (seq < v && v-seq <= rule.ReproductionInterval) {
+				return true
 			}
-			return nil
 		}
+		seqs = append(seqs, seq)
+	}
+	return false
+}
+
+// PunishUser set the state of user as punished in this space time
+func (s *SpaceTime) PunishUser(userID common.Hash) error {
+	if uVertex := s.userStateD.GetVertex(userID); uVertex != nil {
+		uVertex.Value().(*UserInfo).natureState = UserStatusPunished
+		return nil
 	}
-	return ErrAddUserToSpaceTimeFail
+	return ErrUserNotExist
 }
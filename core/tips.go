@@ -0,0 +1,58 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"container/list"
+
+	dag "github.com/pdupub/go-dag"
+	"github.com/pdupub/go-pdu/common"
+)
+
+// tipSet keep the IDs of messages which are not referenced by any other message,
+// updated when message is added into msgD, so tips can be return without scan msgD.
+type tipSet struct {
+	order *list.List
+	index map[interface{}]*list.Element
+}
+
+func newTipSet() *tipSet {
+	return &tipSet{order: list.New(), index: make(map[interface{}]*list.Element)}
+}
+
+// update the tips by the vertex just added into msgD, the parents of vertex are
+// no longer tips, and the vertex is a tip if no message reference it yet.
+func (ts *tipSet) update(vertex *dag.Vertex) {
+	for _, pid := range vertex.ParentIDs() {
+		if e, ok := ts.index[pid]; ok {
+			ts.order.Remove(e)
+			delete(ts.index, pid)
+		}
+	}
+	if len(vertex.Children()) == 0 {
+		ts.index[vertex.ID()] = ts.order.PushFront(vertex.ID())
+	}
+}
+
+// list return the tips, the latest added comes first
+func (ts *tipSet) list() []common.Hash {
+	var tips []common.Hash
+	for e := ts.order.Front(); e != nil; e = e.Next() {
+		tips = append(tips, e.Value.(common.Hash))
+	}
+	return tips
+}
@@ -34,6 +34,7 @@ type Universe struct {
 	msgD  *dag.DAG // contain all messages valid in at least one spacetime
 	userD *dag.DAG // contain all users valid in at least one spacetime (strict)
 	stD   *dag.DAG // contain all spacetime, which could be diff by selecting (strict)
+	tips  *tipSet  // IDs of messages in msgD not referenced yet, latest first
 }
 
 // NewUniverse create Universe with two user with diff gender as root users
@@ -54,16 +55,23 @@ func NewUniverse(Eve, Adam *User) (*Universe, error) {
 		return nil, err
 	}
 	userD.SetMaxParentsCount(2)
-	return &Universe{userD: userD}, nil
+	return &Universe{userD: userD, tips: newTipSet()}, nil
 }
 
 // AddMsg will check if the message from valid user, who is validated in at least one spacetime
-// (in stD). Then new message will be added into Universe and update time proof if msg.SenderID
-// is any spacetime based on.
+// (in stD), and the signature of message can be verified by the auth of this user. Then new
+// message will be added into Universe and update time proof if msg.SenderID is any spacetime based on.
+// The message takes no effect in the spacetime which the sender has been punished in.
 func (u *Universe) AddMsg(msg *Message) error {
 	if !u.CheckUserExist(msg.SenderID) {
 		return ErrUserNotExist
 	}
+	if err := u.verifyMsgSignature(msg); err != nil {
+		return err
+	}
+	if u.checkUserPunished(msg.SenderID) {
+		return ErrUserPunished
+	}
 	if u.msgD == nil {
 		if err := u.initializeMsgD(msg); err != nil {
 			return err
@@ -89,6 +97,7 @@ func (u *Universe) AddMsg(msg *Message) error {
 		if err != nil {
 			return err
 		}
+		u.tips.update(msgVertex)
 		// update tp
 		err = u.updateTimeProof(msg)
 		if err != nil {
@@ -103,6 +112,49 @@ func (u *Universe) AddMsg(msg *Message) error {
 	return nil
 }
 
+// verifyMsgSignature check the signature of msg by the auth of sender. The public key
+// contained in msg.Signature (if any) is ignored, only the auth of sender from userD is used,
+// so the msg can not be forged by any other key pair.
+func (u Universe) verifyMsgSignature(msg *Message) error {
+	if msg.Signature == nil {
+		return ErrMsgSignatureMissing
+	}
+	sender := u.GetUserByID(msg.SenderID)
+	if sender == nil {
+		return ErrUserNotExist
+	}
+	if sender.Auth == nil || msg.Signature.Source != sender.Auth.Source || msg.Signature.SigType != sender.Auth.SigType {
+		return ErrMsgSignatureNotMatchAuth
+	}
+	signature := *msg.Signature
+	signature.PubKey = sender.Auth.PubKey
+	target := *msg
+	target.Signature = &signature
+	if res, err := VerifyMsg(target); err != nil || !res {
+		return ErrMsgSignatureInvalid
+	}
+	return nil
+}
+
+// checkUserPunished return true if the user has been punished in all space time
+// which contain this user.
+func (u Universe) checkUserPunished(userID common.Hash) bool {
+	if u.stD == nil {
+		return false
+	}
+	punished := false
+	for _, id := range u.stD.GetIDs() {
+		st := u.stD.GetVertex(id).Value().(*SpaceTime)
+		if st.GetUserInfo(userID) != nil {
+			if !st.UserPunished(userID) {
+				return false
+			}
+			punished = true
+		}
+	}
+	return punished
+}
+
 // GetSpaceTimeIDs get ids in of spacetime (list of msg.SenderID of each spacetime)
 func (u *Universe) GetSpaceTimeIDs() []common.Hash {
 	var ids []common.Hash
@@ -193,10 +245,21 @@ func (u Universe) GetUserByID(userID common.Hash) *User {
 	return nil
 }
 
+// GetAllUserIDs return ids of all users in userD
+func (u Universe) GetAllUserIDs() []common.Hash {
+	var userIDs []common.Hash
+	for _, id := range u.userD.GetIDs() {
+		userIDs = append(userIDs, id.(common.Hash))
+	}
+	return userIDs
+}
+
 // GetMaxSeq return the max time proof sequence
 func (u Universe) GetMaxSeq(spacetimeID common.Hash) uint64 {
-	if vertex := u.stD.GetVertex(spacetimeID); vertex != nil {
-		return vertex.Value().(*SpaceTime).maxTimeSequence
+	if u.stD != nil {
+		if vertex := u.stD.GetVertex(spacetimeID); vertex != nil {
+			return vertex.Value().(*SpaceTime).maxTimeSequence
+		}
 	}
 	return 0
 }
@@ -226,12 +289,21 @@ func (u Universe) GetUserInfo(userID common.Hash, spacetimeID common.Hash) *User
 // GetMsgByID will return the msg by msg.ID()
 // nil will be return if msg not exist
 func (u Universe) GetMsgByID(msgID interface{}) *Message {
+	if u.msgD == nil {
+		return nil
+	}
 	if v := u.msgD.GetVertex(msgID); v != nil {
 		return v.Value().(*Message)
 	}
 	return nil
 }
 
+// GetTips return the IDs of messages which are not referenced by any other message,
+// the latest added message comes first.
+func (u Universe) GetTips() []common.Hash {
+	return u.tips.list()
+}
+
 // initializeMsgD only run once to create u.msgD by initial message, and the DAG
 // will remove strict rule, so msgD can accept new message if at least one of
 // reference exist in whole universe.
@@ -247,6 +319,7 @@ func (u *Universe) initializeMsgD(msg *Message) error {
 	}
 	msgD.RemoveStrict()
 	u.msgD = msgD
+	u.tips.update(msgVertex)
 	return nil
 }
 
@@ -259,17 +332,116 @@ func (u *Universe) processMsg(msg *Message) error {
 		if err != nil {
 			return err
 		}
+	case TypeEvidence:
+		err := u.processEvidence(msg)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func (u *Universe) updateTimeProof(msg *Message) error {
 	if vertex := u.stD.GetVertex(msg.SenderID); vertex != nil {
-		return vertex.Value().(*SpaceTime).UpdateTimeProof(msg)
+		st := vertex.Value().(*SpaceTime)
+		// punished user can not be used as time proof in this space time
+		if st.UserPunished(msg.SenderID) {
+			return nil
+		}
+		return st.UpdateTimeProof(msg)
+	}
+	return nil
+}
+
+// processEvidence validate the evidence in msg, and punish the user in each
+// space time which the evidence hold.
+func (u *Universe) processEvidence(msg *Message) error {
+	var contentEvidence ContentEvidence
+	if err := json.Unmarshal(msg.Value.Content, &contentEvidence); err != nil {
+		return err
+	}
+	var offender common.Hash
+	var stIDs []common.Hash
+	var err error
+	switch contentEvidence.EvidenceType {
+	case LeakedPrivateKey:
+		offender, stIDs, err = u.checkLPCE(&contentEvidence)
+	case ExcessiveBirth:
+		offender, stIDs, err = u.checkEBCE(&contentEvidence)
+	default:
+		return ErrEvidenceTypeNotSupport
+	}
+	if err != nil {
+		return err
+	}
+	if len(stIDs) == 0 {
+		return ErrEvidenceNotValid
+	}
+	for _, stID := range stIDs {
+		st := u.stD.GetVertex(stID).Value().(*SpaceTime)
+		// evidence from the user punished in this space time is ignored here
+		if st.UserPunished(msg.SenderID) {
+			continue
+		}
+		if err := st.PunishUser(offender); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// checkLPCE check the evidence of leaked private key, return the offender and
+// all space time contain the offender.
+func (u Universe) checkLPCE(ce *ContentEvidence) (offender common.Hash, stIDs []common.Hash, err error) {
+	if len(ce.Msgs) != 2 || ce.Msgs[1] == nil {
+		return offender, nil, ErrEvidenceNotValid
+	}
+	offender = ce.Msgs[1].SenderID
+	if !u.CheckUserExist(offender) {
+		return offender, nil, ErrUserNotExist
+	}
+	if err := u.verifyMsgSignature(ce.Msgs[1]); err != nil {
+		return offender, nil, err
+	}
+	privKey, err := parseLeakedKey(ce.Msgs[0])
+	if err != nil {
+		return offender, nil, err
+	}
+	if err := verifyLeakedKey(privKey, ce.Msgs[1]); err != nil {
+		return offender, nil, err
+	}
+	for _, stID := range u.GetSpaceTimeIDs() {
+		if u.GetUserInfo(offender, stID) != nil {
+			stIDs = append(stIDs, stID)
+		}
+	}
+	return offender, stIDs, nil
+}
+
+// checkEBCE check the evidence of excessive birth, return the offender and
+// the space time which the cosign of offender against the nature rule.
+func (u Universe) checkEBCE(ce *ContentEvidence) (offender common.Hash, stIDs []common.Hash, err error) {
+	offender, err = checkEBCEMsgs(ce.Msgs)
+	if err != nil {
+		return offender, nil, err
+	}
+	if !u.CheckUserExist(offender) {
+		return offender, nil, ErrUserNotExist
+	}
+	for _, m := range ce.Msgs {
+		if err := u.verifyMsgSignature(m); err != nil {
+			return offender, nil, err
+		}
+	}
+	for _, stID := range u.GetSpaceTimeIDs() {
+		st := u.stD.GetVertex(stID).Value().(*SpaceTime)
+		if st.GetUserInfo(offender) != nil && st.excessiveBirth(stID, ce.Msgs) {
+			stIDs = append(stIDs, stID)
+		}
+	}
+	return offender, stIDs, nil
+}
+
 // addUser user to u.userD
 // update info of u.stD need other func
 func (u *Universe) addUserByMsg(msg *Message) error {
@@ -311,12 +483,48 @@ func (u *Universe) addUserByMsg(msg *Message) error {
 	return nil
 }
 
+// CheckBirthMsg check if the new user can be created by the birth msg without change
+// the universe, the parents should can cosign in at least one space time of references.
+func (u Universe) CheckBirthMsg(msg *Message) error {
+	user, err := CreateNewUser(&u, msg)
+	if err != nil {
+		return err
+	}
+	if u.GetUserByID(user.ID()) != nil {
+		return ErrUserAlreadyExist
+	}
+	if u.stD == nil {
+		return ErrAddUserToSpaceTimeFail
+	}
+	var contentBirth ContentBirth
+	if err := json.Unmarshal(msg.Value.Content, &contentBirth); err != nil {
+		return err
+	}
+	for _, ref := range msg.Reference {
+		if vertex := u.stD.GetVertex(ref.SenderID); vertex != nil {
+			st := vertex.Value().(*SpaceTime)
+			if st.UserPunished(msg.SenderID) {
+				continue
+			}
+			if _, _, _, err := st.checkBirth(ref, contentBirth); err == nil {
+				return nil
+			}
+		}
+	}
+	return ErrNewUserAddFail
+}
+
 // addUserToSpaceTime used to add new user to spacetime base on ref.SenderID, the age of parents in this spacetime
 // should fit the nature rule.
 // TODO: ref.SenderID not must be spacetime, the new user's life length can be calculated by any ref msg.
 func (u *Universe) addUserToSpaceTime(ref *MsgReference, contentBirth ContentBirth, user *User) error {
 	if vertex := u.stD.GetVertex(ref.SenderID); vertex != nil {
-		return vertex.Value().(*SpaceTime).AddUser(ref, contentBirth, user)
+		st := vertex.Value().(*SpaceTime)
+		// birth msg sent by the user punished in this space time is ignored here
+		if st.UserPunished(user.BirthMsg.SenderID) {
+			return ErrAddUserToSpaceTimeFail
+		}
+		return st.AddUser(ref, contentBirth, user)
 	}
 	return ErrAddUserToSpaceTimeFail
 }
@@ -184,16 +184,16 @@ func TestUniverse_AddBirthMsg(t *testing.T) {
 	// verify the signature in the content of BirthMsg
 	var contentBirth ContentBirth
 	json.Unmarshal(msgBirth2.Value.Content, &contentBirth)
-	jsonBytes, _ := json.Marshal(contentBirth.User)
+	sigHash, _ := contentBirth.sigHash()
 	sigAdam := crypto.Signature{Signature: contentBirth.Parents[1].Signature,
 		PublicKey: universe.GetUserByID(contentBirth.Parents[1].UserID).Auth.PublicKey}
 	sigEve := crypto.Signature{Signature: contentBirth.Parents[0].Signature,
 		PublicKey: universe.GetUserByID(contentBirth.Parents[0].UserID).Auth.PublicKey}
 
-	if res, err := universeEngine.Verify(jsonBytes, &sigAdam); err != nil || res == false {
+	if res, err := universeEngine.Verify(sigHash, &sigAdam); err != nil || res == false {
 		t.Error("verify Adam fail", err)
 	}
-	if res, err := universeEngine.Verify(jsonBytes, &sigEve); err != nil || res == false {
+	if res, err := universeEngine.Verify(sigHash, &sigEve); err != nil || res == false {
 		t.Error("verify Eve fail", err)
 	}
 
@@ -297,8 +297,12 @@ func TestUniverse_AddUserOnSpaceTime(t *testing.T) {
 	refAdam := MsgReference{SenderID: Adam.ID(), MsgID: AdamPartMsgIDs[len(AdamPartMsgIDs)-1]}
 	if msgBirth, err := CreateMsg(Eve, &valueBirth, priKeyEve, &ref, &refAdam); err != nil {
 		t.Error("create msg fail , err :", err)
+	} else if err := universe.CheckBirthMsg(msgBirth); err != nil {
+		t.Error("check user birth msg fail, err:", err)
 	} else if err := universe.AddMsg(msgBirth); err != nil {
 		t.Error("add user birth msg fail, err:", err)
+	} else if err := universe.CheckBirthMsg(msgBirth); err != ErrUserAlreadyExist {
+		t.Errorf("check user birth msg, err should be %s, but now err : %v", ErrUserAlreadyExist, err)
 	}
 
 	// display the user state in each of the space time
@@ -306,10 +310,184 @@ func TestUniverse_AddUserOnSpaceTime(t *testing.T) {
 
 }
 
+func TestUniverse_AddMsgWithBadSignature(t *testing.T) {
+	// Test 12: Message which signature can not be verified by
+	// the auth of sender should be rejected by universe.
+	v := MsgValue{
+		ContentType: TypeText,
+		Content:     []byte("forged msg"),
+	}
+	// signed by private key of Eve, but sender is Adam
+	msgForged, err := CreateMsg(Adam, &v, priKeyEve, &ref)
+	if err != nil {
+		t.Error("create msg fail", err)
+	}
+	if err := universe.AddMsg(msgForged); err != ErrMsgSignatureInvalid {
+		t.Errorf("add forged msg, err should be %s, but now err : %v", ErrMsgSignatureInvalid, err)
+	}
+
+	// signed by key with different signature type
+	priKeyS2PK, _, err := universeEngine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Error("generate key fail", err)
+	}
+	msgSigType, err := CreateMsg(Eve, &v, priKeyS2PK, &ref)
+	if err != nil {
+		t.Error("create msg fail", err)
+	}
+	if err := universe.AddMsg(msgSigType); err != ErrMsgSignatureNotMatchAuth {
+		t.Errorf("add msg, err should be %s, but now err : %v", ErrMsgSignatureNotMatchAuth, err)
+	}
+
+	// content changed after signed
+	msgTampered, err := CreateMsg(Eve, &v, priKeyEve, &ref)
+	if err != nil {
+		t.Error("create msg fail", err)
+	}
+	msgTampered.Value.Content = []byte("tampered msg")
+	if err := universe.AddMsg(msgTampered); err != ErrMsgSignatureInvalid {
+		t.Errorf("add tampered msg, err should be %s, but now err : %v", ErrMsgSignatureInvalid, err)
+	}
+
+	// signature missing
+	msgNoSig, err := CreateMsg(Eve, &v, priKeyEve, &ref)
+	if err != nil {
+		t.Error("create msg fail", err)
+	}
+	msgNoSig.Signature = nil
+	if err := universe.AddMsg(msgNoSig); err != ErrMsgSignatureMissing {
+		t.Errorf("add msg, err should be %s, but now err : %v", ErrMsgSignatureMissing, err)
+	}
+}
+
 func TestUniverse_AddMsgWithDiffRef(t *testing.T) {
 
 }
 
+func TestUniverse_AddExcessiveBirthEvidence(t *testing.T) {
+	// Test 13: Eve cosign two birth msgs at same time proof sequence,
+	// both msgs can be used as evidence of excessive birth. After the
+	// evidence msg be accepted, Eve is punished in both space time.
+	var msgBirths []*Message
+	refAdam := MsgReference{SenderID: Adam.ID(), MsgID: AdamPartMsgIDs[len(AdamPartMsgIDs)-1]}
+	for i := 0; i < 2; i++ {
+		_, pubKey, err := universeEngine.GenKey(crypto.Signature2PublicKey)
+		if err != nil {
+			t.Error("generate public key fail", err)
+		}
+		content, err := CreateContentBirth(fmt.Sprintf("E%d", i), "", &Auth{PublicKey: *pubKey})
+		if err != nil {
+			t.Error("create birth content fail", err)
+		}
+		content.SignByParent(Adam, *priKeyAdam)
+		content.SignByParent(Eve, *priKeyEve)
+		valueBirth := MsgValue{ContentType: TypeBirth}
+		if valueBirth.Content, err = json.Marshal(content); err != nil {
+			t.Error("content marshal fail", err)
+		}
+		msgBirth, err := CreateMsg(Eve, &valueBirth, priKeyEve, &ref, &refAdam)
+		if err != nil {
+			t.Error("create msg fail", err)
+		}
+		msgBirths = append(msgBirths, msgBirth)
+	}
+
+	if _, err := CreateEBCE(msgBirths[0]); err != ErrEvidenceNotValid {
+		t.Errorf("create evidence, err should be %s, but now err : %v", ErrEvidenceNotValid, err)
+	}
+	contentEvidence, err := CreateEBCE(msgBirths...)
+	if err != nil {
+		t.Error("create evidence fail", err)
+	}
+	valueEvidence := MsgValue{ContentType: TypeEvidence}
+	if valueEvidence.Content, err = json.Marshal(contentEvidence); err != nil {
+		t.Error("content marshal fail", err)
+	}
+	msgEvidence, err := CreateMsg(Adam, &valueEvidence, priKeyAdam, &refAdam)
+	if err != nil {
+		t.Error("create msg fail", err)
+	}
+	if err := universe.AddMsg(msgEvidence); err != nil {
+		t.Error("add evidence msg fail", err)
+	}
+	for _, stID := range universe.GetSpaceTimeIDs() {
+		if userInfo := universe.GetUserInfo(Eve.ID(), stID); userInfo == nil || !userInfo.Punished() {
+			t.Error("Eve should be punished in space time", common.Hash2String(stID))
+		}
+		if userInfo := universe.GetUserInfo(Adam.ID(), stID); userInfo == nil || userInfo.Punished() {
+			t.Error("Adam should not be punished in space time", common.Hash2String(stID))
+		}
+	}
+
+	// msg from Eve should be rejected
+	v := MsgValue{
+		ContentType: TypeText,
+		Content:     []byte("msg after punished"),
+	}
+	msgT, err := CreateMsg(Eve, &v, priKeyEve, &ref)
+	if err != nil {
+		t.Error("create msg fail", err)
+	}
+	if err := universe.AddMsg(msgT); err != ErrUserPunished {
+		t.Errorf("add msg, err should be %s, but now err : %v", ErrUserPunished, err)
+	}
+}
+
+func TestUniverse_AddLeakedPrivateKeyEvidence(t *testing.T) {
+	// Test 14: The private key of Adam is leaked, anyone can send
+	// the evidence, then Adam is punished in the universe.
+	AdamL, EveL, priKeyAdamL, priKeyEveL, err := createAdamAndEve()
+	if err != nil {
+		t.Error("create root user fail", err)
+	}
+	universeL, err := NewUniverse(EveL, AdamL)
+	if err != nil {
+		t.Error("create universe fail", err)
+	}
+	v := MsgValue{
+		ContentType: TypeText,
+		Content:     []byte("hello world!"),
+	}
+	msgAdam, err := CreateMsg(AdamL, &v, priKeyAdamL)
+	if err != nil {
+		t.Error("create msg fail", err)
+	}
+	if err := universeL.AddMsg(msgAdam); err != nil {
+		t.Error("add msg fail", err)
+	}
+	refL := MsgReference{SenderID: AdamL.ID(), MsgID: msgAdam.ID()}
+
+	if _, err := CreateLPCE(*priKeyEveL, msgAdam); err != ErrEvidenceNotValid {
+		t.Errorf("create evidence, err should be %s, but now err : %v", ErrEvidenceNotValid, err)
+	}
+	contentEvidence, err := CreateLPCE(*priKeyAdamL, msgAdam)
+	if err != nil {
+		t.Error("create evidence fail", err)
+	}
+	valueEvidence := MsgValue{ContentType: TypeEvidence}
+	if valueEvidence.Content, err = json.Marshal(contentEvidence); err != nil {
+		t.Error("content marshal fail", err)
+	}
+	msgEvidence, err := CreateMsg(EveL, &valueEvidence, priKeyEveL, &refL)
+	if err != nil {
+		t.Error("create msg fail", err)
+	}
+	if err := universeL.AddMsg(msgEvidence); err != nil {
+		t.Error("add evidence msg fail", err)
+	}
+	if userInfo := universeL.GetUserInfo(AdamL.ID(), AdamL.ID()); userInfo == nil || !userInfo.Punished() {
+		t.Error("Adam should be punished")
+	}
+
+	msgT, err := CreateMsg(AdamL, &v, priKeyAdamL, &refL)
+	if err != nil {
+		t.Error("create msg fail", err)
+	}
+	if err := universeL.AddMsg(msgT); err != ErrUserPunished {
+		t.Errorf("add msg, err should be %s, but now err : %v", ErrUserPunished, err)
+	}
+}
+
 func loopAddMsg(universe *Universe, user *User, priKey *crypto.PrivateKey, lastMsgID common.Hash) error {
 	ref = MsgReference{SenderID: user.ID(), MsgID: lastMsgID}
 	// loop to add msg dag
@@ -398,3 +576,156 @@ func displayAllSpaceTimeUserState() {
 		}
 	}
 }
+
+func TestUniverse_GetTips(t *testing.T) {
+	universeEngine, _ = utils.SelectEngine(defaultEngineName)
+	Adam, Eve, priKeyAdam, priKeyEve, err := createAdamAndEve()
+	if err != nil {
+		t.Error("create root user fail", err)
+	}
+	universe, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Error("create universe fail", err)
+	}
+	if tips := universe.GetTips(); len(tips) != 0 {
+		t.Error("tips should be empty before first msg")
+	}
+	addMsg := func(user *User, priKey *crypto.PrivateKey, refs ...*Message) *Message {
+		var msgRefs []*MsgReference
+		for _, r := range refs {
+			msgRefs = append(msgRefs, &MsgReference{SenderID: r.SenderID, MsgID: r.ID()})
+		}
+		msg, err := CreateMsg(user, &MsgValue{ContentType: TypeText, Content: []byte("tips")}, priKey, msgRefs...)
+		if err != nil {
+			t.Fatal("create msg fail", err)
+		}
+		if err := universe.AddMsg(msg); err != nil {
+			t.Fatal("add msg fail", err)
+		}
+		return msg
+	}
+
+	msg1 := addMsg(Adam, priKeyAdam)
+	if tips := universe.GetTips(); len(tips) != 1 || tips[0] != msg1.ID() {
+		t.Error("tips should be the first msg")
+	}
+	msg2 := addMsg(Eve, priKeyEve, msg1)
+	msg3 := addMsg(Adam, priKeyAdam, msg1)
+	if tips := universe.GetTips(); len(tips) != 2 || tips[0] != msg3.ID() || tips[1] != msg2.ID() {
+		t.Error("tips should be msg3 and msg2")
+	}
+	msg4 := addMsg(Eve, priKeyEve, msg2, msg3)
+	if tips := universe.GetTips(); len(tips) != 1 || tips[0] != msg4.ID() {
+		t.Error("tips should be msg4")
+	}
+	// the msg added after the msg which reference it is not a tip
+	late, err := CreateMsg(Adam, &MsgValue{ContentType: TypeText, Content: []byte("late")}, priKeyAdam,
+		&MsgReference{SenderID: msg4.SenderID, MsgID: msg4.ID()})
+	if err != nil {
+		t.Fatal("create msg fail", err)
+	}
+	msg5 := addMsg(Eve, priKeyEve, msg4, late)
+	if err := universe.AddMsg(late); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	if tips := universe.GetTips(); len(tips) != 1 || tips[0] != msg5.ID() {
+		t.Error("tips should be msg5")
+	}
+}
+
+func TestUniverse_CheckBirthMsgWithoutSpaceTime(t *testing.T) {
+	data := loadLegacyData(t)
+	universe, err := NewUniverse(data.Users[0].User, data.Users[1].User)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no msg is added, so there is no space time in universe yet
+	if err := universe.CheckBirthMsg(data.Msgs[1].Msg); err != ErrAddUserToSpaceTimeFail {
+		t.Errorf("err should be %s, but now err : %v", ErrAddUserToSpaceTimeFail, err)
+	}
+}
+
+func TestUniverse_PunishedInOneSpaceTime(t *testing.T) {
+	universeEngine, _ = utils.SelectEngine(defaultEngineName)
+	Adam, Eve, priKeyAdam, priKeyEve, err := createAdamAndEve()
+	if err != nil {
+		t.Fatal("create root user fail", err)
+	}
+	universe, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	createMsg := func(user *User, priKey *crypto.PrivateKey, value MsgValue, refs ...*Message) *Message {
+		var msgRefs []*MsgReference
+		for _, r := range refs {
+			msgRefs = append(msgRefs, &MsgReference{SenderID: r.SenderID, MsgID: r.ID()})
+		}
+		msg, err := CreateMsg(user, &value, priKey, msgRefs...)
+		if err != nil {
+			t.Fatal("create msg fail", err)
+		}
+		return msg
+	}
+	text := MsgValue{ContentType: TypeText, Content: []byte("hello")}
+	msgAdam := createMsg(Adam, priKeyAdam, text)
+	if err := universe.AddMsg(msgAdam); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	msgEve := createMsg(Eve, priKeyEve, text, msgAdam)
+	if err := universe.AddMsg(msgEve); err != nil {
+		t.Fatal("add msg fail", err)
+	}
+	if err := universe.AddSpaceTime(msgEve, msgEve.Reference[0]); err != nil {
+		t.Fatal("add space time fail", err)
+	}
+	stAdam := universe.stD.GetVertex(Adam.ID()).Value().(*SpaceTime)
+	stEve := universe.stD.GetVertex(Eve.ID()).Value().(*SpaceTime)
+
+	// Eve is punished in her own space time, but not in the space time of Adam,
+	// so the msg of Eve is accepted, but not used as time proof
+	if err := stEve.PunishUser(Eve.ID()); err != nil {
+		t.Fatal(err)
+	}
+	maxSeq := universe.GetMaxSeq(Eve.ID())
+	msgEve2 := createMsg(Eve, priKeyEve, text, msgEve)
+	if err := universe.AddMsg(msgEve2); err != nil {
+		t.Error("msg should be accepted by the space time of Adam", err)
+	}
+	if universe.GetMaxSeq(Eve.ID()) != maxSeq {
+		t.Error("time proof should not be updated by punished user")
+	}
+
+	// the evidence sent by Eve only take effect in the space time of Adam
+	contentEvidence, err := CreateLPCE(*priKeyAdam, msgAdam)
+	if err != nil {
+		t.Fatal("create evidence fail", err)
+	}
+	evidence := MsgValue{ContentType: TypeEvidence}
+	if evidence.Content, err = json.Marshal(contentEvidence); err != nil {
+		t.Fatal("content marshal fail", err)
+	}
+	if err := universe.AddMsg(createMsg(Eve, priKeyEve, evidence, msgEve2)); err != nil {
+		t.Error("add evidence msg fail", err)
+	}
+	if !stAdam.UserPunished(Adam.ID()) {
+		t.Error("Adam should be punished in the space time of Adam")
+	}
+	if stEve.UserPunished(Adam.ID()) {
+		t.Error("Adam should not be punished by evidence from the punished user")
+	}
+	maxSeq = universe.GetMaxSeq(Adam.ID())
+	if err := universe.AddMsg(createMsg(Adam, priKeyAdam, text, msgAdam)); err != nil {
+		t.Error("msg should be accepted by the space time of Eve", err)
+	}
+	if universe.GetMaxSeq(Adam.ID()) != maxSeq {
+		t.Error("time proof should not be updated by punished user")
+	}
+
+	// Eve is punished in all space time
+	if err := stAdam.PunishUser(Eve.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := universe.AddMsg(createMsg(Eve, priKeyEve, text, msgEve2)); err != ErrUserPunished {
+		t.Errorf("add msg, err should be %s, but now err : %v", ErrUserPunished, err)
+	}
+}
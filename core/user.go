@@ -39,6 +39,7 @@ const (
 
 // User is the author of any msg in pdu
 type User struct {
+	Version    uint64   `json:"version,omitempty"`
 	Name       string   `json:"name"`
 	BirthExtra string   `json:"extra"`
 	Auth       *Auth    `json:"auth"`
@@ -49,14 +50,14 @@ type User struct {
 // CreateRootUser try to create root user by public key
 // Gender of the root user is depend on key,name and extra
 func CreateRootUser(key crypto.PublicKey, name, extra string) *User {
-	return &User{Name: name, BirthExtra: extra, Auth: &Auth{PublicKey: key}, BirthMsg: nil, LifeTime: rule.MaxLifeTime}
+	return &User{Version: DefaultEncoding, Name: name, BirthExtra: extra, Auth: &Auth{PublicKey: key}, BirthMsg: nil, LifeTime: rule.MaxLifeTime}
 }
 
 // CreateNewUser create new user by cosign message
 // The msg must be signed by user in local user dag.
 // Both parents must be in the local use dag.
-// Both parents fit the nature rules.
-// The Birth struct signed by both parents.
+// Both parents fit the nature rules, female and male in order.
+// The Birth struct signed by both parents, and the msg sent by one of them.
 func CreateNewUser(universe *Universe, msg *Message) (*User, error) {
 	if msg.Value.ContentType != TypeBirth {
 		return nil, ErrContentTypeNotBirth
@@ -67,19 +68,30 @@ func CreateNewUser(universe *Universe, msg *Message) (*User, error) {
 	}
 	newUser := contentBirth.User
 	newUser.BirthMsg = msg
-	// calculate the life time of new user
-	p0 := universe.userD.GetVertex(contentBirth.Parents[0].UserID)
-	if p0 == nil {
-		return nil, ErrUserNotExist
+	var parents [2]*User
+	for i, p := range contentBirth.Parents {
+		vertex := universe.userD.GetVertex(p.UserID)
+		if vertex == nil {
+			return nil, ErrUserNotExist
+		}
+		parents[i] = vertex.Value().(*User)
 	}
-	maxParentLifeTime := p0.Value().(*User).LifeTime
-
-	p1 := universe.userD.GetVertex(contentBirth.Parents[1].UserID)
-	if p1 == nil {
-		return nil, ErrUserNotExist
+	// parents[0] is female and parents[1] is male
+	if parents[0].Gender() || !parents[1].Gender() {
+		return nil, ErrParentGenderNotValid
+	}
+	if msg.SenderID != parents[0].ID() && msg.SenderID != parents[1].ID() {
+		return nil, ErrSenderNotParent
 	}
-	if maxParentLifeTime < p1.Value().(*User).LifeTime {
-		maxParentLifeTime = p1.Value().(*User).LifeTime
+	for i, parent := range parents {
+		if err := contentBirth.verifyParent(i, parent); err != nil {
+			return nil, err
+		}
+	}
+	// calculate the life time of new user
+	maxParentLifeTime := parents[0].LifeTime
+	if maxParentLifeTime < parents[1].LifeTime {
+		maxParentLifeTime = parents[1].LifeTime
 	}
 	if maxParentLifeTime == rule.MortalLifetime {
 		newUser.LifeTime = rule.MortalLifetime
@@ -91,26 +103,37 @@ func CreateNewUser(universe *Universe, msg *Message) (*User, error) {
 }
 
 // ID return the vertex.id, related to parents and value of the vertex
-// ID cloud use as address of user account
+// ID cloud use as address of user account,
+// empty hash is returned if the user can not be encoded
 func (u User) ID() common.Hash {
-	hash := sha256.New()
-	hash.Reset()
-
-	auth, _ := json.Marshal(u.Auth)
-	lifeTime := fmt.Sprintf("%v", u.LifeTime)
-	var birthMsg string
-	// todo : add init BirthMsg to rootUser
-	// todo : so this condition can be deleted
-	if u.BirthMsg != nil {
-		birthMsg += fmt.Sprintf("%v", u.BirthMsg.SenderID)
-		for _, v := range u.BirthMsg.Reference {
-			birthMsg += fmt.Sprintf("%v%v", v.SenderID, v.MsgID)
+	switch u.Version {
+	case EncodingLegacy:
+		hash := sha256.New()
+		hash.Reset()
+
+		auth, _ := json.Marshal(u.Auth)
+		lifeTime := fmt.Sprintf("%v", u.LifeTime)
+		var birthMsg string
+		// todo : add init BirthMsg to rootUser
+		// todo : so this condition can be deleted
+		if u.BirthMsg != nil {
+			birthMsg += fmt.Sprintf("%v", u.BirthMsg.SenderID)
+			for _, v := range u.BirthMsg.Reference {
+				birthMsg += fmt.Sprintf("%v%v", v.SenderID, v.MsgID)
+			}
+			birthMsg += fmt.Sprintf("%v%v%v", u.BirthMsg.Signature.Signature, u.BirthMsg.Signature.Source, u.BirthMsg.Signature.SigType)
+			birthMsg += fmt.Sprintf("%v%v", u.BirthMsg.Value.Content, u.BirthMsg.Value.ContentType)
+		}
+		hash.Write(append(append(append(append([]byte(u.Name), u.BirthExtra...), auth...), birthMsg...), lifeTime...))
+		return common.Bytes2Hash(hash.Sum(nil))
+	case EncodingV1:
+		userBytes, err := EncodeUser(&u)
+		if err != nil {
+			return common.Hash{}
 		}
-		birthMsg += fmt.Sprintf("%v%v%v", u.BirthMsg.Signature.Signature, u.BirthMsg.Signature.Source, u.BirthMsg.Signature.SigType)
-		birthMsg += fmt.Sprintf("%v%v", u.BirthMsg.Value.Content, u.BirthMsg.Value.ContentType)
+		return sha256.Sum256(userBytes)
 	}
-	hash.Write(append(append(append(append([]byte(u.Name), u.BirthExtra...), auth...), birthMsg...), lifeTime...))
-	return common.Bytes2Hash(hash.Sum(nil))
+	return common.Hash{}
 }
 
 // Gender return the gender of user, true = male = end of ID is odd
@@ -207,6 +230,12 @@ func (u *User) UnmarshalJSON(input []byte) error {
 	if err != nil {
 		return err
 	}
+	// version is not set for user of EncodingLegacy
+	if version, ok := userMap["version"].(string); ok {
+		if u.Version, err = strconv.ParseUint(version, 0, 64); err != nil {
+			return err
+		}
+	}
 	json.Unmarshal([]byte(userMap["birthMsg"].(string)), &u.BirthMsg)
 	json.Unmarshal([]byte(userMap["auth"].(string)), &u.Auth)
 
@@ -219,6 +248,9 @@ func (u *User) MarshalJSON() ([]byte, error) {
 	userMap["name"] = u.Name
 	userMap["birthExtra"] = u.BirthExtra
 	userMap["lifeTime"] = fmt.Sprintf("%v", u.LifeTime)
+	if u.Version != EncodingLegacy {
+		userMap["version"] = fmt.Sprintf("%v", u.Version)
+	}
 
 	auth, err := json.Marshal(&u.Auth)
 	if err != nil {
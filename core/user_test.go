@@ -129,6 +129,95 @@ func TestCreateNewUser(t *testing.T) {
 	}
 }
 
+func TestCreateNewUser_Verify(t *testing.T) {
+	Adam, privKeyAdam, Eve, privKeyEve := createRootUsers()
+	universe, err := NewUniverse(Eve, Adam)
+	if err != nil {
+		t.Fatal("create universe fail", err)
+	}
+	privKeyCain, pubKeyCain, err := userEngine.GenKey(crypto.MultipleSignatures, 3)
+	if err != nil {
+		t.Fatal("generate key fail", err)
+	}
+	// Cain is not in the universe
+	Cain := CreateRootUser(*pubKeyCain, "Cain", "extra")
+	_, pubKey, err := userEngine.GenKey(crypto.MultipleSignatures, 3)
+	if err != nil {
+		t.Fatal("generate key fail", err)
+	}
+
+	signed := func() *ContentBirth {
+		content, _ := CreateContentBirth("A2", "1234", &Auth{PublicKey: *pubKey})
+		content.SignByParent(Adam, privKeyAdam)
+		content.SignByParent(Eve, privKeyEve)
+		return content
+	}
+
+	cases := []struct {
+		name      string
+		content   func() *ContentBirth
+		sender    *User
+		senderKey crypto.PrivateKey
+		err       error
+	}{
+		{"sent by mother", signed, Eve, privKeyEve, nil},
+		{"sent by father", signed, Adam, privKeyAdam, nil},
+		{"parent not exist", func() *ContentBirth {
+			content := signed()
+			content.Parents[1].UserID = Cain.ID()
+			return content
+		}, Eve, privKeyEve, ErrUserNotExist},
+		{"parents swapped", func() *ContentBirth {
+			content := signed()
+			content.Parents[0], content.Parents[1] = content.Parents[1], content.Parents[0]
+			return content
+		}, Eve, privKeyEve, ErrParentGenderNotValid},
+		{"same gender", func() *ContentBirth {
+			content := signed()
+			content.Parents[1] = content.Parents[0]
+			return content
+		}, Eve, privKeyEve, ErrParentGenderNotValid},
+		{"sender not parent", signed, Cain, *privKeyCain, ErrSenderNotParent},
+		{"signature missing", func() *ContentBirth {
+			content := signed()
+			content.Parents[0].Signature = nil
+			return content
+		}, Eve, privKeyEve, ErrParentSignatureMissing},
+		{"signed by other key", func() *ContentBirth {
+			content := signed()
+			content.SignByParent(Eve, privKeyAdam)
+			return content
+		}, Eve, privKeyEve, ErrParentSignatureInvalid},
+		{"signature tampered", func() *ContentBirth {
+			content := signed()
+			content.Parents[1].Signature[0] ^= 0xff
+			return content
+		}, Eve, privKeyEve, ErrParentSignatureInvalid},
+		{"user changed after sign", func() *ContentBirth {
+			content := signed()
+			content.User.Name = "A3"
+			return content
+		}, Eve, privKeyEve, ErrParentSignatureInvalid},
+	}
+
+	for _, c := range cases {
+		value := MsgValue{ContentType: TypeBirth}
+		value.Content, err = json.Marshal(c.content())
+		if err != nil {
+			t.Errorf("%s : content marshal fail %s", c.name, err)
+			continue
+		}
+		birthMsg, err := CreateMsg(c.sender, &value, &c.senderKey)
+		if err != nil {
+			t.Errorf("%s : create msg fail %s", c.name, err)
+			continue
+		}
+		if _, err := CreateNewUser(universe, birthMsg); err != c.err {
+			t.Errorf("%s : should return %v, but %v", c.name, c.err, err)
+		}
+	}
+}
+
 func TestUserDistance(t *testing.T) {
 	Adam, _, Eve, _ := createRootUsers()
 	// default setting for standard distance
@@ -208,3 +297,27 @@ func createRootUsers() (*User, crypto.PrivateKey, *User, crypto.PrivateKey) {
 	}
 	return Adam, APK, Eve, EPK
 }
+
+func TestCreateNewUser_Legacy(t *testing.T) {
+	data := loadLegacyData(t)
+	universe, err := NewUniverse(data.Users[0].User, data.Users[1].User)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the birth msg and the user in it are signed before the encoding version is added
+	birthMsg := data.Msgs[1].Msg
+	var content ContentBirth
+	if err := json.Unmarshal(birthMsg.Value.Content, &content); err != nil {
+		t.Fatal(err)
+	}
+	if content.User.Version != EncodingLegacy {
+		t.Fatal("version of user should be legacy")
+	}
+	if _, err := CreateNewUser(universe, birthMsg); err != nil {
+		t.Error("create new user fail", err)
+	}
+	content.Parents[1].Signature[0] ^= 0xff
+	if err := content.verifyParent(1, data.Users[1].User); err != ErrParentSignatureInvalid {
+		t.Errorf("should return %v, but %v", ErrParentSignatureInvalid, err)
+	}
+}
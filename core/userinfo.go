@@ -16,11 +16,20 @@
 
 package core
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pdupub/go-pdu/core/rule"
+)
 
 const (
 	// UserStatusNormal is the status of user, will be add more later, like punished...
 	UserStatusNormal = iota
+
+	// UserStatusPunished is the status of user who has been proved illegal by evidence
+	// in this space time, any msg from this user will not be accepted by this space time.
+	UserStatusPunished
 )
 
 // UserInfo contain the information except pass by BirthMsg
@@ -40,7 +49,53 @@ func NewUserInfo(name string, life uint64, BirthSeq uint64) *UserInfo {
 	return &UserInfo{natureState: UserStatusNormal, natureLastCosign: BirthSeq, natureLifeMaxSeq: life, natureBirthSeq: BirthSeq, localNickname: name}
 }
 
+// Punished return true if the user has been punished
+func (ui UserInfo) Punished() bool {
+	return ui.natureState == UserStatusPunished
+}
+
+// CanCosign return true if the user is alive and not punished at the time sequence,
+// and the last cosign is earlier than the sequence for more than rule.ReproductionInterval.
+func (ui UserInfo) CanCosign(seq uint64) bool {
+	return !ui.Punished() &&
+		ui.natureBirthSeq+ui.natureLifeMaxSeq > seq &&
+		seq-ui.natureLastCosign > rule.ReproductionInterval
+}
+
 // String used to print user info
 func (ui UserInfo) String() string {
 	return fmt.Sprintf("localNickname:\t%s\tnatureState:\t%d\tnatureLastCosign:\t%d\tnatureLifeMaxSeq:\t%d\tnatureBirthSeq:\t%d\t", ui.localNickname, ui.natureState, ui.natureLastCosign, ui.natureLifeMaxSeq, ui.natureBirthSeq)
 }
+
+type userInfoJSON struct {
+	NatureState      int    `json:"natureState"`
+	NatureLastCosign uint64 `json:"natureLastCosign"`
+	NatureLifeMaxSeq uint64 `json:"natureLifeMaxSeq"`
+	NatureBirthSeq   uint64 `json:"natureBirthSeq"`
+	LocalNickname    string `json:"localNickname"`
+}
+
+// MarshalJSON marshal user info to json
+func (ui UserInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&userInfoJSON{
+		NatureState:      ui.natureState,
+		NatureLastCosign: ui.natureLastCosign,
+		NatureLifeMaxSeq: ui.natureLifeMaxSeq,
+		NatureBirthSeq:   ui.natureBirthSeq,
+		LocalNickname:    ui.localNickname,
+	})
+}
+
+// UnmarshalJSON is used to unmarshal json
+func (ui *UserInfo) UnmarshalJSON(input []byte) error {
+	var uiJSON userInfoJSON
+	if err := json.Unmarshal(input, &uiJSON); err != nil {
+		return err
+	}
+	ui.natureState = uiJSON.NatureState
+	ui.natureLastCosign = uiJSON.NatureLastCosign
+	ui.natureLifeMaxSeq = uiJSON.NatureLifeMaxSeq
+	ui.natureBirthSeq = uiJSON.NatureBirthSeq
+	ui.localNickname = uiJSON.LocalNickname
+	return nil
+}
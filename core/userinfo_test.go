@@ -15,3 +15,43 @@
 // along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
 
 package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pdupub/go-pdu/core/rule"
+)
+
+func TestUserInfo_MarshalJSON(t *testing.T) {
+	ui := NewUserInfo("Adam", 100, 3)
+	ui.natureLastCosign = 42
+	uiBytes, err := json.Marshal(ui)
+	if err != nil {
+		t.Error(err)
+	}
+	var target UserInfo
+	if err := json.Unmarshal(uiBytes, &target); err != nil {
+		t.Error(err)
+	}
+	if *ui != target {
+		t.Errorf("user info mismatch, should be %s, but get %s", ui, target)
+	}
+}
+
+func TestUserInfo_CanCosign(t *testing.T) {
+	ui := NewUserInfo("A2", rule.MortalLifetime, 10)
+	if ui.CanCosign(10 + rule.ReproductionInterval) {
+		t.Error("can not cosign in reproduction interval after birth")
+	}
+	if !ui.CanCosign(11 + rule.ReproductionInterval) {
+		t.Error("should can cosign after reproduction interval")
+	}
+	if ui.CanCosign(10 + rule.MortalLifetime) {
+		t.Error("can not cosign after life time")
+	}
+	ui.natureState = UserStatusPunished
+	if ui.CanCosign(11 + rule.ReproductionInterval) {
+		t.Error("punished user can not cosign")
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+const (
+	// Format is the name of archive format, in the header of archive
+	Format = "pdu-archive"
+
+	// Version is the current version of archive format
+	Version = 1
+)
+
+var (
+	// ErrFormatNotMatch returns when the stream is not pdu archive
+	ErrFormatNotMatch = errors.New("archive format not match")
+
+	// ErrVersionNotSupport returns when the version of archive is newer than current
+	ErrVersionNotSupport = errors.New("archive version not support")
+
+	// ErrArchiveIncomplete returns when the count of msgs not match the header
+	ErrArchiveIncomplete = errors.New("archive incomplete")
+
+	// ErrUniverseExist returns when import into db which already contain universe
+	ErrUniverseExist = errors.New("universe already exist in db")
+)
+
+// settingKeys is the universe settings in BucketConfig exported
+var settingKeys = []string{db.ConfigUniverseDimension, db.ConfigUniversePerimeter, db.ConfigUniverseRedshiftConstant}
+
+// Header is the first line of archive, followed by msgs one per line
+type Header struct {
+	Format   string            `json:"format"`
+	Version  uint64            `json:"version"`
+	Roots    [2]*core.User     `json:"roots"`
+	Settings map[string][]byte `json:"settings"`
+	MsgCount uint64            `json:"msgCount"`
+}
+
+// Export write the root users, universe settings and all msgs in topological
+// order into w. The msgs are read by order, the msg is delayed only if it
+// references msg in db but not be written yet.
+func Export(udb db.UDB, w io.Writer) error {
+	root0, root1, err := db.GetRootUsers(udb)
+	if err != nil {
+		return err
+	}
+	count, err := db.GetMsgCount(udb)
+	if err != nil {
+		return err
+	}
+	header := &Header{
+		Format:   Format,
+		Version:  Version,
+		Roots:    [2]*core.User{root0, root1},
+		Settings: make(map[string][]byte),
+		MsgCount: count.Uint64(),
+	}
+	for _, key := range settingKeys {
+		val, err := udb.Get(db.BucketConfig, key)
+		if err != nil {
+			return err
+		}
+		if val != nil {
+			header.Settings[key] = val
+		}
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+
+	written := make(map[common.Hash]bool)
+	// waiting is the msgs wait for reference, by the msg.ID of reference
+	waiting := make(map[common.Hash][]*core.Message)
+	var write func(msg *core.Message) error
+	write = func(msg *core.Message) error {
+		for _, ref := range msg.Reference {
+			if written[ref.MsgID] {
+				continue
+			}
+			if refBytes, err := udb.Get(db.BucketMsg, common.Hash2String(ref.MsgID)); err != nil {
+				return err
+			} else if refBytes != nil {
+				waiting[ref.MsgID] = append(waiting[ref.MsgID], msg)
+				return nil
+			}
+		}
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+		written[msg.ID()] = true
+		children := waiting[msg.ID()]
+		delete(waiting, msg.ID())
+		for _, child := range children {
+			if !written[child.ID()] {
+				if err := write(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for i := uint64(0); i < header.MsgCount; i++ {
+		mid, err := udb.Get(db.BucketMID, new(big.Int).SetUint64(i).String())
+		if err != nil {
+			return err
+		}
+		msg, err := db.GetMsgByID(udb, common.Bytes2Hash(mid))
+		if err != nil {
+			return err
+		}
+		if err := write(msg); err != nil {
+			return err
+		}
+	}
+	if len(written) != int(header.MsgCount) {
+		return ErrArchiveIncomplete
+	}
+	return nil
+}
+
+// Import read the archive from r into udb, which should be initialized without universe.
+// Every msg is validated by universe before be saved, return the count of msgs imported.
+func Import(udb db.UDB, r io.Reader) (uint64, error) {
+	stepBytes, err := udb.Get(db.BucketConfig, db.ConfigCurrentStep)
+	if err != nil {
+		return 0, err
+	}
+	if new(big.Int).SetBytes(stepBytes).Uint64() >= db.StepRootsSaved {
+		return 0, ErrUniverseExist
+	}
+
+	dec := json.NewDecoder(r)
+	var header Header
+	if err := dec.Decode(&header); err != nil {
+		return 0, err
+	}
+	if header.Format != Format {
+		return 0, ErrFormatNotMatch
+	}
+	if header.Version > Version {
+		return 0, ErrVersionNotSupport
+	}
+	if header.Roots[0] == nil || header.Roots[1] == nil {
+		return 0, ErrFormatNotMatch
+	}
+	universe, err := core.NewUniverse(header.Roots[0], header.Roots[1])
+	if err != nil {
+		return 0, err
+	}
+	if err := db.SaveRootUsers(udb, header.Roots[:]); err != nil {
+		return 0, err
+	}
+	for _, key := range settingKeys {
+		if val, ok := header.Settings[key]; ok {
+			if err := udb.Set(db.BucketConfig, key, val); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	var count uint64
+	for {
+		var msg core.Message
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return count, err
+		}
+		if err := importMsg(udb, universe, &msg); err != nil {
+			return count, fmt.Errorf("msg %d %s : %s", count, common.Hash2String(msg.ID()), err)
+		}
+		count++
+	}
+	if count != header.MsgCount {
+		return count, ErrArchiveIncomplete
+	}
+	return count, nil
+}
+
+// importMsg add the msg into universe, then save it and the user created by it
+// in one transaction
+func importMsg(udb db.UDB, universe *core.Universe, msg *core.Message) error {
+	if msg.Value == nil {
+		return ErrFormatNotMatch
+	}
+	if err := universe.AddMsg(msg); err != nil {
+		return err
+	}
+	if msg.Value.ContentType == core.TypeBirth {
+		user, err := core.CreateNewUser(universe, msg)
+		if err != nil {
+			return err
+		}
+		if universe.GetUserByID(user.ID()) != nil {
+			return db.SaveBirthMsg(udb, msg, user)
+		}
+	}
+	return db.SaveMsg(udb, msg)
+}
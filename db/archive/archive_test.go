@@ -0,0 +1,189 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/core/coretest"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memory"
+)
+
+func newTestDB(t *testing.T) db.UDB {
+	udb := memory.NewDB()
+	for _, bucket := range db.Buckets {
+		if err := udb.CreateBucket(bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(0).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigCurrentStep, big.NewInt(db.StepInitDB).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return udb
+}
+
+// createSourceDB create db with root users, settings and msgs, each msg reference
+// the previous one, but the last two msgs are saved in reverse order.
+func createSourceDB(t *testing.T) (db.UDB, []*core.Message) {
+	users, priKeys := coretest.RootUsers(t)
+
+	udb := newTestDB(t)
+	if err := db.SaveRootUsers(udb, users); err != nil {
+		t.Fatal(err)
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigUniverseDimension, big.NewInt(4).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []*core.Message
+	for i := 0; i < 5; i++ {
+		var refs []*core.MsgReference
+		if i > 0 {
+			refs = append(refs, &core.MsgReference{SenderID: msgs[i-1].SenderID, MsgID: msgs[i-1].ID()})
+		}
+		msg, err := core.CreateMsg(users[i%2], &core.MsgValue{ContentType: core.TypeText, Content: []byte(fmt.Sprintf("msg%d", i))}, priKeys[i%2], refs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	for _, i := range []int{0, 1, 2, 4, 3} {
+		if err := db.SaveMsg(udb, msgs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return udb, msgs
+}
+
+func TestExportImport(t *testing.T) {
+	src, msgs := createSourceDB(t)
+	var buf bytes.Buffer
+	if err := Export(src, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// msgs should be exported in topological order
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(msgs)+1 {
+		t.Fatal("line count not match", len(lines))
+	}
+	for i, line := range lines[1:] {
+		var msg core.Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID() != msgs[i].ID() {
+			t.Error("msg not in topological order", i)
+		}
+	}
+
+	dst := newTestDB(t)
+	count, err := Import(dst, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != uint64(len(msgs)) {
+		t.Error("import count not match", count)
+	}
+	root0, root1, err := db.GetRootUsers(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src0, src1, _ := db.GetRootUsers(src)
+	if root0.ID() != src0.ID() || root1.ID() != src1.ID() {
+		t.Error("root users not match")
+	}
+	if dimension, err := dst.Get(db.BucketConfig, db.ConfigUniverseDimension); err != nil || new(big.Int).SetBytes(dimension).Int64() != 4 {
+		t.Error("settings not match", err)
+	}
+	saved := db.GetMsgByOrder(dst, big.NewInt(0), len(msgs))
+	for i, msg := range saved {
+		if msg.ID() != msgs[i].ID() {
+			t.Error("msg not match", i)
+		}
+	}
+	if res, err := db.CheckDB(dst); err != nil || len(res.Issues) != 0 {
+		t.Error("imported db not valid", res.Issues, err)
+	}
+
+	// can not import again
+	if _, err := Import(dst, bytes.NewReader(buf.Bytes())); err != ErrUniverseExist {
+		t.Error("import into exist universe should fail", err)
+	}
+}
+
+func TestImport_Invalid(t *testing.T) {
+	src, msgs := createSourceDB(t)
+	var buf bytes.Buffer
+	if err := Export(src, &buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var header Header
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatal(err)
+	}
+	withHeader := func(update func(h Header) Header) string {
+		headerBytes, _ := json.Marshal(update(header))
+		return strings.Join(append([]string{string(headerBytes)}, lines[1:]...), "\n")
+	}
+	// msg[2] signed by sender of msg[1]
+	forged := *msgs[2]
+	forged.Signature = msgs[1].Signature
+	forgedBytes, _ := json.Marshal(&forged)
+
+	cases := []struct {
+		name    string
+		archive string
+		err     error
+	}{
+		{"format", withHeader(func(h Header) Header {
+			h.Format = "other"
+			return h
+		}), ErrFormatNotMatch},
+		{"version", withHeader(func(h Header) Header {
+			h.Version = Version + 1
+			return h
+		}), ErrVersionNotSupport},
+		{"truncated", strings.Join(lines[:len(lines)-1], "\n"), ErrArchiveIncomplete},
+		{"forged", strings.Join(append(append(lines[:3:3], string(forgedBytes)), lines[4:]...), "\n"), core.ErrMsgSignatureInvalid},
+	}
+	for _, c := range cases {
+		_, err := Import(newTestDB(t), strings.NewReader(c.archive))
+		if err == nil || !strings.Contains(err.Error(), c.err.Error()) {
+			t.Errorf("%s : should return %v, but %v", c.name, c.err, err)
+		}
+	}
+	// the forged msg is not saved
+	dst := newTestDB(t)
+	if _, err := Import(dst, strings.NewReader(cases[3].archive)); err == nil {
+		t.Fatal("forged msg should not be imported")
+	}
+	if _, err := db.GetMsgByID(dst, forged.ID()); err != db.ErrMessageNotFound {
+		t.Error("forged msg should not be saved", common.Hash2String(forged.ID()), err)
+	}
+}
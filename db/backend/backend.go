@@ -0,0 +1,97 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/bolt"
+	"github.com/pdupub/go-pdu/db/leveldb"
+	"github.com/pdupub/go-pdu/db/memory"
+)
+
+// OpenFunc open the UDB in path, create if not exist
+type OpenFunc func(path string) (db.UDB, error)
+
+var (
+	mu       sync.RWMutex
+	backends = make(map[string]OpenFunc)
+)
+
+func init() {
+	Register(db.BackendBolt, func(path string) (db.UDB, error) {
+		udb, err := bolt.NewDB(path)
+		if err != nil {
+			return nil, err
+		}
+		return udb, nil
+	})
+	Register(db.BackendLevelDB, func(path string) (db.UDB, error) {
+		udb, err := leveldb.NewDB(path)
+		if err != nil {
+			return nil, err
+		}
+		return udb, nil
+	})
+	Register(db.BackendMemory, func(path string) (db.UDB, error) {
+		return memory.NewDB(), nil
+	})
+}
+
+// Register make the backend available by name, the name is case insensitive.
+// It panics if open is nil or the name is registered twice.
+func Register(name string, open OpenFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	if open == nil {
+		panic("backend: register open func is nil " + name)
+	}
+	name = strings.ToLower(name)
+	if _, ok := backends[name]; ok {
+		panic("backend: register twice for " + name)
+	}
+	backends[name] = open
+}
+
+// Open return the UDB by name of backend, the path is ignored by memory backend
+func Open(name, path string) (db.UDB, error) {
+	if name == "" {
+		name = db.DefaultBackend
+	}
+	mu.RLock()
+	open, ok := backends[strings.ToLower(name)]
+	mu.RUnlock()
+	if !ok {
+		return nil, db.ErrBackendNotSupport
+	}
+	return open(path)
+}
+
+// Names return the names of all backends
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,352 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/bolt"
+	"github.com/pdupub/go-pdu/db/memory"
+)
+
+const (
+	bucketName  = "testBucket"
+	emptyBucket = "emptyBucket"
+)
+
+// conformance is the test run against every backend
+var conformance = []struct {
+	name string
+	test func(*testing.T, db.UDB)
+}{
+	{"Bucket", testBucket},
+	{"SetGetDel", testSetGetDel},
+	{"Find", testFind},
+	{"FindArgs", testFindArgs},
+	{"Update", testUpdate},
+}
+
+func TestBackends(t *testing.T) {
+	for _, name := range Names() {
+		for _, c := range conformance {
+			t.Run(fmt.Sprintf("%s/%s", name, c.name), func(t *testing.T) {
+				dir, err := ioutil.TempDir("", "pdu_db_test")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(dir)
+				udb, err := Open(name, path.Join(dir, "u.db"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := udb.CreateBucket(bucketName); err != nil {
+					t.Fatal(err)
+				}
+				c.test(t, udb)
+				if err := udb.Close(); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := Open("unknown", ""); err != db.ErrBackendNotSupport {
+		t.Error("unknown backend should not be opened", err)
+	}
+	dir, err := ioutil.TempDir("", "pdu_db_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	udb, err := Open("", path.Join(dir, "u.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udb.Close()
+	if _, ok := udb.(*bolt.UBoltDB); !ok {
+		t.Error("default backend should be bolt")
+	}
+	if udb, err := Open(db.BackendBolt, dir); err == nil || udb != nil {
+		t.Error("open fail should return nil db", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	name := "Test"
+	opened := ""
+	Register(name, func(path string) (db.UDB, error) {
+		opened = path
+		return memory.NewDB(), nil
+	})
+	defer func() {
+		mu.Lock()
+		delete(backends, "test")
+		mu.Unlock()
+	}()
+	if udb, err := Open("test", "u.db"); err != nil || udb == nil || opened != "u.db" {
+		t.Error("registered backend should be opened", err)
+	}
+	found := false
+	for _, n := range Names() {
+		found = found || n == "test"
+	}
+	if !found {
+		t.Error("registered backend should be in names")
+	}
+	for _, c := range []struct {
+		name string
+		open OpenFunc
+	}{
+		{"register twice", func(string) (db.UDB, error) { return memory.NewDB(), nil }},
+		{"register nil", nil},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s should panic", c.name)
+				}
+			}()
+			Register(name, c.open)
+		}()
+	}
+}
+
+func testBucket(t *testing.T, udb db.UDB) {
+	if err := udb.CreateBucket(bucketName); err == nil {
+		t.Error("bucket should not be created twice")
+	}
+	if err := udb.Set(emptyBucket, "key", []byte("val")); err == nil {
+		t.Error("set should fail if bucket not exist")
+	}
+	if _, err := udb.Get(emptyBucket, "key"); err == nil {
+		t.Error("get should fail if bucket not exist")
+	}
+	if _, err := udb.Find(emptyBucket, "", 1); err != db.ErrBucketNotExist {
+		t.Errorf("find err should be %s if bucket not exist, but now err : %v", db.ErrBucketNotExist, err)
+	}
+	if err := udb.DeleteBucket(emptyBucket); err == nil {
+		t.Error("delete should fail if bucket not exist")
+	}
+	if err := udb.CreateBucket(emptyBucket); err != nil {
+		t.Error(err)
+	}
+	// same key in different bucket
+	if err := udb.Set(bucketName, "key", []byte("val")); err != nil {
+		t.Error(err)
+	}
+	if val, err := udb.Get(emptyBucket, "key"); err != nil || val != nil {
+		t.Error("key should not be found in other bucket", err)
+	}
+	if err := udb.DeleteBucket(bucketName); err != nil {
+		t.Error(err)
+	}
+	if _, err := udb.Get(bucketName, "key"); err == nil {
+		t.Error("get should fail after bucket deleted")
+	}
+	// recreated bucket should be empty
+	if err := udb.CreateBucket(bucketName); err != nil {
+		t.Error(err)
+	}
+	if val, err := udb.Get(bucketName, "key"); err != nil || val != nil {
+		t.Error("recreated bucket should be empty", err)
+	}
+}
+
+func testSetGetDel(t *testing.T, udb db.UDB) {
+	if val, err := udb.Get(bucketName, "key"); err != nil || val != nil {
+		t.Error("missing key should return nil", err)
+	}
+	if err := udb.Set(bucketName, "key", []byte("val")); err != nil {
+		t.Error(err)
+	}
+	if val, err := udb.Get(bucketName, "key"); err != nil || string(val) != "val" {
+		t.Error("val not equal", err)
+	}
+	if err := udb.Set(bucketName, "key", []byte("val2")); err != nil {
+		t.Error(err)
+	}
+	if val, err := udb.Get(bucketName, "key"); err != nil || string(val) != "val2" {
+		t.Error("val should be overwritten", err)
+	}
+	if err := udb.Del(bucketName, "key"); err != nil {
+		t.Error(err)
+	}
+	if val, err := udb.Get(bucketName, "key"); err != nil || val != nil {
+		t.Error("deleted key should return nil", err)
+	}
+	if err := udb.Del(bucketName, "key"); err != nil {
+		t.Error("delete missing key should not fail", err)
+	}
+	// empty val is not same as missing key
+	if err := udb.Set(bucketName, "empty", []byte{}); err != nil {
+		t.Error(err)
+	}
+	if val, err := udb.Get(bucketName, "empty"); err != nil || val == nil || len(val) != 0 {
+		t.Error("empty val should not be nil", err)
+	}
+}
+
+func testFind(t *testing.T, udb db.UDB) {
+	// insert out of order, result should be in order of key
+	for _, i := range []int{5, 2, 8, 0, 9, 1, 7, 3, 6, 4} {
+		if err := udb.Set(bucketName, fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("val%d", i))); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, k := range []string{"ke", "kez", "other"} {
+		if err := udb.Set(bucketName, k, []byte(k)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	cases := []struct {
+		prefix string
+		args   []int
+		keys   []int
+	}{
+		{"key", []int{3}, []int{0, 1, 2}},
+		{"key", []int{0, 3}, []int{0, 1, 2}},
+		{"key", []int{2, 3}, []int{2, 3, 4}},
+		{"key", []int{8, 5}, []int{8, 9}},
+		{"key", []int{10, 5}, nil},
+		{"key", []int{0}, nil},
+		{"key", []int{20}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"key5", []int{20}, []int{5}},
+		{"kex", []int{20}, nil},
+	}
+	for _, c := range cases {
+		rows, err := udb.Find(bucketName, c.prefix, c.args...)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if len(rows) != len(c.keys) {
+			t.Errorf("find %s %v : should return %d rows, but %d", c.prefix, c.args, len(c.keys), len(rows))
+			continue
+		}
+		for i, row := range rows {
+			if row.K != fmt.Sprintf("key%d", c.keys[i]) || string(row.V) != fmt.Sprintf("val%d", c.keys[i]) {
+				t.Errorf("find %s %v : row %d not match, %s", c.prefix, c.args, i, row.K)
+			}
+		}
+	}
+
+	// empty prefix return all rows in bucket
+	if rows, err := udb.Find(bucketName, "", 100); err != nil || len(rows) != 13 {
+		t.Error("find all rows fail", err)
+	}
+}
+
+func testFindArgs(t *testing.T, udb db.UDB) {
+	if _, err := udb.Find(bucketName, ""); err == nil {
+		t.Error("find should fail if limit missing")
+	}
+	if _, err := udb.Find(bucketName, "", 1, 2, 3); err == nil {
+		t.Error("find should fail if args number not correct")
+	}
+}
+
+func testUpdate(t *testing.T, udb db.UDB) {
+	if err := udb.Set(bucketName, "key0", []byte("val0")); err != nil {
+		t.Error(err)
+	}
+	// commit
+	err := udb.Update(func(tx db.Tx) error {
+		if err := tx.Set(bucketName, "key1", []byte("val1")); err != nil {
+			return err
+		}
+		// read own write in transaction
+		if val, err := tx.Get(bucketName, "key1"); err != nil || string(val) != "val1" {
+			return fmt.Errorf("read own write fail %v", err)
+		}
+		if err := tx.Del(bucketName, "key0"); err != nil {
+			return err
+		}
+		if val, err := tx.Get(bucketName, "key0"); err != nil || val != nil {
+			return fmt.Errorf("read own delete fail %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if val, err := udb.Get(bucketName, "key1"); err != nil || string(val) != "val1" {
+		t.Error("update not committed", err)
+	}
+	if val, err := udb.Get(bucketName, "key0"); err != nil || val != nil {
+		t.Error("delete not committed", err)
+	}
+
+	// rollback by error
+	errRollback := errors.New("rollback")
+	err = udb.Update(func(tx db.Tx) error {
+		if err := tx.Set(bucketName, "key2", []byte("val2")); err != nil {
+			return err
+		}
+		if err := tx.Del(bucketName, "key1"); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Error("update should return error of func", err)
+	}
+	if val, err := udb.Get(bucketName, "key2"); err != nil || val != nil {
+		t.Error("set should be rollback", err)
+	}
+	if val, err := udb.Get(bucketName, "key1"); err != nil || string(val) != "val1" {
+		t.Error("delete should be rollback", err)
+	}
+
+	// rollback by missing bucket
+	err = udb.Update(func(tx db.Tx) error {
+		if err := tx.Set(bucketName, "key3", []byte("val3")); err != nil {
+			return err
+		}
+		return tx.Set(emptyBucket, "key3", []byte("val3"))
+	})
+	if err == nil {
+		t.Error("set should fail if bucket not exist")
+	}
+	if val, err := udb.Get(bucketName, "key3"); err != nil || val != nil {
+		t.Error("set should be rollback", err)
+	}
+
+	// rollback by panic
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("panic should not be recovered by update")
+			}
+		}()
+		udb.Update(func(tx db.Tx) error {
+			tx.Set(bucketName, "key4", []byte("val4"))
+			panic("crash")
+		})
+	}()
+	if val, err := udb.Get(bucketName, "key4"); err != nil || val != nil {
+		t.Error("set should be rollback after panic", err)
+	}
+}
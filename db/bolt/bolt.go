@@ -29,7 +29,6 @@ import (
 var (
 	errFindMissingLimit         = errors.New("find operate missing limit")
 	errFindArgsNumberNotCorrect = errors.New("find operate number not correct")
-	errBucketNotExist           = errors.New("bucket not exist")
 )
 
 // UBoltDB is the db struct by bolt
@@ -68,36 +67,31 @@ func (u *UBoltDB) DeleteBucket(bucketName string) error {
 
 // Set key/val into bucket
 func (u *UBoltDB) Set(bucketName, key string, val []byte) error {
-	return u.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return errBucketNotExist
-		}
-		return b.Put([]byte(key), val)
+	return u.Update(func(tx db.Tx) error {
+		return tx.Set(bucketName, key, val)
 	})
 }
 
 // Get val by key from bucket
 func (u *UBoltDB) Get(bucketName, key string) (val []byte, err error) {
 	err = u.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return errBucketNotExist
-		}
-		val = b.Get([]byte(key))
-		return nil
+		val, err = (&boltTx{tx}).Get(bucketName, key)
+		return err
 	})
 	return val, err
 }
 
 // Del val by key from bucket
 func (u *UBoltDB) Del(bucketName, key string) error {
+	return u.Update(func(tx db.Tx) error {
+		return tx.Del(bucketName, key)
+	})
+}
+
+// Update run the func in one bolt transaction
+func (u *UBoltDB) Update(fn func(db.Tx) error) error {
 	return u.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return errBucketNotExist
-		}
-		return b.Delete([]byte(key))
+		return fn(&boltTx{tx})
 	})
 }
 
@@ -119,7 +113,7 @@ func (u *UBoltDB) Find(bucketName, prefix string, args ...int) (rows []*db.Row,
 	err = u.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucketName))
 		if b == nil {
-			return errBucketNotExist
+			return db.ErrBucketNotExist
 		}
 		c := b.Cursor()
 		prefixBytes := []byte(prefix)
@@ -137,3 +131,39 @@ func (u *UBoltDB) Find(bucketName, prefix string, args ...int) (rows []*db.Row,
 	})
 	return rows, err
 }
+
+// boltTx is the db.Tx by bolt transaction
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+// Set key/val into bucket
+func (t *boltTx) Set(bucketName, key string, val []byte) error {
+	b := t.tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return db.ErrBucketNotExist
+	}
+	return b.Put([]byte(key), val)
+}
+
+// Get val by key from bucket, the val is copied because it is only valid in transaction
+func (t *boltTx) Get(bucketName, key string) ([]byte, error) {
+	b := t.tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return nil, db.ErrBucketNotExist
+	}
+	val := b.Get([]byte(key))
+	if val == nil {
+		return nil, nil
+	}
+	return append([]byte{}, val...), nil
+}
+
+// Del val by key from bucket
+func (t *boltTx) Del(bucketName, key string) error {
+	b := t.tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return db.ErrBucketNotExist
+	}
+	return b.Delete([]byte(key))
+}
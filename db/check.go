@@ -0,0 +1,501 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"container/heap"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// Kinds of issue found by CheckDB
+const (
+	// IssueCorrupted is the msg can not be decoded or the key not match msg.ID
+	IssueCorrupted = "corrupted"
+
+	// IssueGap is the order missing in BucketMID
+	IssueGap = "gap"
+
+	// IssueDuplicate is the msg saved with more than one order
+	IssueDuplicate = "duplicate"
+
+	// IssueMissing is the index of msg missing
+	IssueMissing = "missing"
+
+	// IssueMismatch is the index not agree with the other indexes
+	IssueMismatch = "mismatch"
+
+	// IssueDangling is the index or reference point to msg not in BucketMsg
+	IssueDangling = "dangling"
+
+	// IssueSignature is the signature of msg can not be verified by the sender
+	IssueSignature = "signature"
+
+	// IssueReplay is the msg can not be added into universe when replay
+	IssueReplay = "replay"
+)
+
+// checkPageSize is the number of rows read from bucket each time
+const checkPageSize = 1000
+
+// Issue is the problem found in db
+type Issue struct {
+	Kind   string `json:"kind"`
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Detail string `json:"detail"`
+}
+
+// String return the readable issue
+func (i Issue) String() string {
+	return fmt.Sprintf("[%s] %s/%s : %s", i.Kind, i.Bucket, i.Key, i.Detail)
+}
+
+// CheckResult is the result of CheckDB
+type CheckResult struct {
+	MsgCount int      `json:"msgCount"`
+	Issues   []*Issue `json:"issues"`
+}
+
+func (r *CheckResult) add(kind, bucket, key, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, &Issue{Kind: kind, Bucket: bucket, Key: key, Detail: fmt.Sprintf(format, args...)})
+}
+
+// msgIndex is the messages and indexes loaded from db
+type msgIndex struct {
+	msgs    map[string]*core.Message     // msg.ID / msg, only valid msg
+	rekey   map[string]string            // key / msg.ID, msg saved with wrong key
+	broken  []string                     // key of msg which can not be decoded
+	count   uint64                       // ConfigMsgCount
+	mid     map[uint64]string            // order / msg.ID
+	mod     map[string]uint64            // msg.ID / order
+	lastMID map[string]string            // user.ID / msg.ID
+	badKeys map[string][]string          // bucket / keys can not be parsed
+	indexes map[string]map[string]string // bucket / key / msg.ID, secondary indexes
+}
+
+// findAll return all rows in bucket
+func findAll(udb UDB, bucket string) ([]*Row, error) {
+	return findPrefix(udb, bucket, "")
+}
+
+// findPrefix return all rows in bucket with the prefix
+func findPrefix(udb UDB, bucket, prefix string) ([]*Row, error) {
+	var rows []*Row
+	for skip := 0; ; skip += checkPageSize {
+		page, err := udb.Find(bucket, prefix, skip, checkPageSize)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, page...)
+		if len(page) < checkPageSize {
+			return rows, nil
+		}
+	}
+}
+
+func loadMsgIndex(udb UDB) (*msgIndex, error) {
+	idx := &msgIndex{
+		msgs:    make(map[string]*core.Message),
+		rekey:   make(map[string]string),
+		mid:     make(map[uint64]string),
+		mod:     make(map[string]uint64),
+		lastMID: make(map[string]string),
+		badKeys: make(map[string][]string),
+		indexes: make(map[string]map[string]string),
+	}
+	rows, err := findAll(udb, BucketMsg)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		msg, err := core.UnmarshalMsg(row.V)
+		if err != nil || msg.Value == nil {
+			idx.broken = append(idx.broken, row.K)
+			continue
+		}
+		msgID := common.Hash2String(msg.ID())
+		if msgID != row.K {
+			idx.rekey[row.K] = msgID
+		}
+		idx.msgs[msgID] = msg
+	}
+
+	countBytes, err := udb.Get(BucketConfig, ConfigMsgCount)
+	if err != nil {
+		return nil, err
+	}
+	idx.count = new(big.Int).SetBytes(countBytes).Uint64()
+
+	if rows, err = findAll(udb, BucketMID); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		order, err := strconv.ParseUint(row.K, 10, 64)
+		if err != nil {
+			idx.badKeys[BucketMID] = append(idx.badKeys[BucketMID], row.K)
+			continue
+		}
+		idx.mid[order] = common.Bytes2String(row.V)
+	}
+	if rows, err = findAll(udb, BucketMOD); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		idx.mod[row.K] = new(big.Int).SetBytes(row.V).Uint64()
+	}
+	if rows, err = findAll(udb, BucketLastMID); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		idx.lastMID[row.K] = common.Bytes2String(row.V)
+	}
+	for _, bucket := range indexBuckets {
+		if rows, err = findAll(udb, bucket); err != nil {
+			return nil, err
+		}
+		idx.indexes[bucket] = make(map[string]string)
+		for _, row := range rows {
+			idx.indexes[bucket][row.K] = common.Bytes2String(row.V)
+		}
+	}
+	return idx, nil
+}
+
+// CheckDB walk the buckets of messages, check if BucketMsg, BucketMID, BucketMOD,
+// BucketLastMID, ConfigMsgCount and secondary indexes agree with each other, then replay all messages
+// by order to verify the signatures and references.
+func CheckDB(udb UDB) (*CheckResult, error) {
+	idx, err := loadMsgIndex(udb)
+	if err != nil {
+		return nil, err
+	}
+	res := &CheckResult{MsgCount: len(idx.msgs)}
+
+	for _, key := range idx.broken {
+		res.add(IssueCorrupted, BucketMsg, key, "msg can not be decoded")
+	}
+	for key, msgID := range idx.rekey {
+		res.add(IssueCorrupted, BucketMsg, key, "key not match msg %s", msgID)
+	}
+	for bucket, keys := range idx.badKeys {
+		for _, key := range keys {
+			res.add(IssueCorrupted, bucket, key, "key is not order")
+		}
+	}
+	if idx.count != uint64(len(idx.msgs)) {
+		res.add(IssueMismatch, BucketConfig, ConfigMsgCount, "msg count is %d, but %d msgs saved", idx.count, len(idx.msgs))
+	}
+
+	// BucketMID should be continuous from 0 to msg count, each msg once
+	var maxOrder uint64
+	for order := range idx.mid {
+		if order+1 > maxOrder {
+			maxOrder = order + 1
+		}
+	}
+	if idx.count > maxOrder {
+		maxOrder = idx.count
+	}
+	seen := make(map[string]uint64)
+	lastBySender := make(map[string]string)
+	for order := uint64(0); order < maxOrder; order++ {
+		key := strconv.FormatUint(order, 10)
+		msgID, ok := idx.mid[order]
+		if !ok {
+			res.add(IssueGap, BucketMID, key, "order missing")
+			continue
+		}
+		if order >= idx.count {
+			res.add(IssueMismatch, BucketMID, key, "order beyond msg count %d", idx.count)
+		}
+		msg, ok := idx.msgs[msgID]
+		if !ok {
+			res.add(IssueDangling, BucketMID, key, "msg %s not found", msgID)
+			continue
+		}
+		if first, ok := seen[msgID]; ok {
+			res.add(IssueDuplicate, BucketMID, key, "msg %s already saved with order %d", msgID, first)
+			continue
+		}
+		seen[msgID] = order
+		lastBySender[common.Hash2String(msg.SenderID)] = msgID
+	}
+
+	for msgID, order := range idx.mod {
+		if _, ok := idx.msgs[msgID]; !ok {
+			res.add(IssueDangling, BucketMOD, msgID, "msg not found")
+		} else if idx.mid[order] != msgID {
+			res.add(IssueMismatch, BucketMOD, msgID, "order %d not match BucketMID", order)
+		}
+	}
+	for userID, msgID := range idx.lastMID {
+		msg, ok := idx.msgs[msgID]
+		if !ok {
+			res.add(IssueDangling, BucketLastMID, userID, "msg %s not found", msgID)
+		} else if common.Hash2String(msg.SenderID) != userID {
+			res.add(IssueMismatch, BucketLastMID, userID, "msg %s not sent by user", msgID)
+		} else if last, ok := lastBySender[userID]; ok && last != msgID {
+			res.add(IssueMismatch, BucketLastMID, userID, "last msg should be %s, but %s", last, msgID)
+		}
+	}
+	for msgID, msg := range idx.msgs {
+		if _, ok := seen[msgID]; !ok {
+			res.add(IssueMissing, BucketMID, msgID, "msg has no order")
+		}
+		if _, ok := idx.mod[msgID]; !ok {
+			res.add(IssueMissing, BucketMOD, msgID, "msg has no order")
+		}
+		if _, ok := idx.lastMID[common.Hash2String(msg.SenderID)]; !ok {
+			res.add(IssueMissing, BucketLastMID, common.Hash2String(msg.SenderID), "sender has no last msg")
+		}
+		for _, ref := range msg.Reference {
+			if _, ok := idx.msgs[common.Hash2String(ref.MsgID)]; !ok {
+				res.add(IssueDangling, BucketMsg, msgID, "reference %s not found", common.Hash2String(ref.MsgID))
+			}
+		}
+	}
+
+	// secondary indexes should contain all ordered msgs only
+	expected := make(map[string]map[string]string)
+	for msgID, order := range seen {
+		for bucket, keys := range msgIndexKeys(idx.msgs[msgID], order) {
+			if _, ok := expected[bucket]; !ok {
+				expected[bucket] = make(map[string]string)
+			}
+			for _, key := range keys {
+				expected[bucket][key] = msgID
+			}
+		}
+	}
+	for _, bucket := range indexBuckets {
+		for key, msgID := range idx.indexes[bucket] {
+			if _, ok := idx.msgs[msgID]; !ok {
+				res.add(IssueDangling, bucket, key, "msg %s not found", msgID)
+			} else if expected[bucket][key] != msgID {
+				res.add(IssueMismatch, bucket, key, "msg %s not match order", msgID)
+			}
+		}
+		for key, msgID := range expected[bucket] {
+			if _, ok := idx.indexes[bucket][key]; !ok {
+				res.add(IssueMissing, bucket, key, "msg %s not indexed", msgID)
+			}
+		}
+	}
+
+	if err := replayMsgs(udb, idx, seen, res); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(res.Issues, func(i, j int) bool {
+		if res.Issues[i].Kind != res.Issues[j].Kind {
+			return res.Issues[i].Kind < res.Issues[j].Kind
+		}
+		return res.Issues[i].Key < res.Issues[j].Key
+	})
+	return res, nil
+}
+
+// replayMsgs add all ordered msgs into new universe, to verify signatures and references
+func replayMsgs(udb UDB, idx *msgIndex, orders map[string]uint64, res *CheckResult) error {
+	stepBytes, err := udb.Get(BucketConfig, ConfigCurrentStep)
+	if err != nil {
+		return err
+	}
+	if new(big.Int).SetBytes(stepBytes).Uint64() < StepRootsSaved {
+		return nil
+	}
+	user0, user1, err := GetRootUsers(udb)
+	if err != nil {
+		return err
+	}
+	universe, err := core.NewUniverse(user0, user1)
+	if err != nil {
+		return err
+	}
+	for _, msgID := range sortByOrder(idx.msgs, orders) {
+		if err := universe.AddMsg(idx.msgs[msgID]); err != nil {
+			switch err {
+			case core.ErrMsgSignatureMissing, core.ErrMsgSignatureNotMatchAuth, core.ErrMsgSignatureInvalid:
+				res.add(IssueSignature, BucketMsg, msgID, "%s", err)
+			default:
+				res.add(IssueReplay, BucketMsg, msgID, "%s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// sortByOrder return the msg.IDs in topological order, the msgs which reference
+// others are always after them, then sorted by original orders, the msgs without
+// order are at the end.
+func sortByOrder(msgs map[string]*core.Message, orders map[string]uint64) []string {
+	less := func(a, b string) bool {
+		oa, okA := orders[a]
+		ob, okB := orders[b]
+		if okA != okB {
+			return okA
+		}
+		if okA && oa != ob {
+			return oa < ob
+		}
+		return a < b
+	}
+	// count of references in msgs not sorted yet, and msgs reference it
+	pending := make(map[string]int)
+	children := make(map[string][]string)
+	ready := &msgHeap{less: less}
+	for msgID, msg := range msgs {
+		refs := make(map[string]bool)
+		for _, ref := range msg.Reference {
+			refID := common.Hash2String(ref.MsgID)
+			if _, ok := msgs[refID]; ok && refID != msgID && !refs[refID] {
+				refs[refID] = true
+				children[refID] = append(children[refID], msgID)
+			}
+		}
+		pending[msgID] = len(refs)
+		if len(refs) == 0 {
+			ready.ids = append(ready.ids, msgID)
+		}
+	}
+	heap.Init(ready)
+	var sorted []string
+	for ready.Len() > 0 {
+		msgID := heap.Pop(ready).(string)
+		sorted = append(sorted, msgID)
+		for _, child := range children[msgID] {
+			if pending[child]--; pending[child] == 0 {
+				heap.Push(ready, child)
+			}
+		}
+	}
+	return sorted
+}
+
+// msgHeap is the heap of msg.IDs, the first one by less is at the top
+type msgHeap struct {
+	ids  []string
+	less func(a, b string) bool
+}
+
+func (h msgHeap) Len() int            { return len(h.ids) }
+func (h msgHeap) Less(i, j int) bool  { return h.less(h.ids[i], h.ids[j]) }
+func (h msgHeap) Swap(i, j int)       { h.ids[i], h.ids[j] = h.ids[j], h.ids[i] }
+func (h *msgHeap) Push(x interface{}) { h.ids = append(h.ids, x.(string)) }
+func (h *msgHeap) Pop() interface{} {
+	last := h.ids[len(h.ids)-1]
+	h.ids = h.ids[:len(h.ids)-1]
+	return last
+}
+
+// RepairDB rebuild BucketMID, BucketMOD, BucketLastMID, ConfigMsgCount and secondary
+// indexes from BucketMsg in one transaction. The msgs which can not be decoded are removed, and the msgs saved
+// with wrong key are moved to msg.ID. The snapshot is removed because the order may be
+// changed, so the universe will be rebuilt by replay all msgs.
+func RepairDB(udb UDB) error {
+	idx, err := loadMsgIndex(udb)
+	if err != nil {
+		return err
+	}
+	orders := make(map[string]uint64)
+	for order, msgID := range idx.mid {
+		if _, ok := orders[msgID]; !ok || order < orders[msgID] {
+			orders[msgID] = order
+		}
+	}
+	sorted := sortByOrder(idx.msgs, orders)
+	var oldMID []string
+	for order := range idx.mid {
+		oldMID = append(oldMID, strconv.FormatUint(order, 10))
+	}
+	oldMID = append(oldMID, idx.badKeys[BucketMID]...)
+
+	return udb.Update(func(tx Tx) error {
+		codec, err := tx.Get(BucketConfig, ConfigMsgCodec)
+		if err != nil {
+			return err
+		}
+		for _, key := range idx.broken {
+			if err := tx.Del(BucketMsg, key); err != nil {
+				return err
+			}
+		}
+		for key, msgID := range idx.rekey {
+			msgBytes, err := marshalMsg(idx.msgs[msgID], codec)
+			if err != nil {
+				return err
+			}
+			if err := tx.Del(BucketMsg, key); err != nil {
+				return err
+			}
+			if err := tx.Set(BucketMsg, msgID, msgBytes); err != nil {
+				return err
+			}
+		}
+		for _, key := range oldMID {
+			if err := tx.Del(BucketMID, key); err != nil {
+				return err
+			}
+		}
+		for msgID := range idx.mod {
+			if err := tx.Del(BucketMOD, msgID); err != nil {
+				return err
+			}
+		}
+		for userID := range idx.lastMID {
+			if err := tx.Del(BucketLastMID, userID); err != nil {
+				return err
+			}
+		}
+		for bucket, rows := range idx.indexes {
+			for key := range rows {
+				if err := tx.Del(bucket, key); err != nil {
+					return err
+				}
+			}
+		}
+		for i, msgID := range sorted {
+			order := new(big.Int).SetUint64(uint64(i))
+			msg := idx.msgs[msgID]
+			if err := tx.Set(BucketMID, order.String(), common.Hash2Bytes(msg.ID())); err != nil {
+				return err
+			}
+			if err := tx.Set(BucketMOD, msgID, order.Bytes()); err != nil {
+				return err
+			}
+			if err := tx.Set(BucketLastMID, common.Hash2String(msg.SenderID), common.Hash2Bytes(msg.ID())); err != nil {
+				return err
+			}
+			if err := indexMsg(tx, msg, uint64(i)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Set(BucketConfig, ConfigMsgCount, new(big.Int).SetUint64(uint64(len(sorted))).Bytes()); err != nil {
+			return err
+		}
+		// terms of search are saved by msg.ID, index them again by new order is enough
+		if err := tx.Del(BucketConfig, ConfigSearchCount); err != nil {
+			return err
+		}
+		return tx.Del(BucketConfig, ConfigSnapshot)
+	})
+}
@@ -0,0 +1,230 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/core/coretest"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/backend"
+)
+
+// createCheckDB create db with root users and msgs, each msg reference the previous one,
+// msgs[2] is signed by wrong key.
+func createCheckDB(t *testing.T, dir string) (db.UDB, []*core.Message) {
+	users, priKeys := coretest.RootUsers(t)
+	udb := openTestDB(t, db.BackendMemory, dir)
+	if err := db.SaveRootUsers(udb, users); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []*core.Message
+	for i := 0; i < 4; i++ {
+		var refs []*core.MsgReference
+		if i > 0 {
+			refs = append(refs, &core.MsgReference{SenderID: msgs[i-1].SenderID, MsgID: msgs[i-1].ID()})
+		}
+		user, priKey := users[i%2], priKeys[i%2]
+		if i == 2 {
+			priKey = priKeys[1]
+		}
+		msg, err := core.CreateMsg(user, &core.MsgValue{ContentType: core.TypeText, Content: []byte(fmt.Sprintf("msg%d", i))}, priKey, refs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveMsg(udb, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return udb, msgs
+}
+
+// issueKinds return the count of issue by kind, except the signature of msgs[2]
+func issueKinds(res *db.CheckResult, msgs []*core.Message) map[string]int {
+	kinds := make(map[string]int)
+	for _, issue := range res.Issues {
+		if issue.Kind == db.IssueSignature && issue.Key == common.Hash2String(msgs[2].ID()) {
+			continue
+		}
+		kinds[issue.Kind]++
+	}
+	return kinds
+}
+
+func TestCheckDB(t *testing.T) {
+	cases := []struct {
+		name    string
+		corrupt func(db.UDB, []*core.Message) error
+		issues  map[string]int
+		// remain is the issues after repair
+		remain map[string]int
+	}{
+		{"clean", func(udb db.UDB, msgs []*core.Message) error {
+			return nil
+		}, map[string]int{}, map[string]int{}},
+		{"gap", func(udb db.UDB, msgs []*core.Message) error {
+			return udb.Del(db.BucketMID, "1")
+		}, map[string]int{db.IssueGap: 1, db.IssueMismatch: 4, db.IssueMissing: 1}, map[string]int{}},
+		{"duplicate", func(udb db.UDB, msgs []*core.Message) error {
+			return udb.Set(db.BucketMID, "2", common.Hash2Bytes(msgs[1].ID()))
+		}, map[string]int{db.IssueDuplicate: 1, db.IssueMismatch: 5, db.IssueMissing: 1}, map[string]int{}},
+		{"count", func(udb db.UDB, msgs []*core.Message) error {
+			return udb.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(6).Bytes())
+		}, map[string]int{db.IssueMismatch: 1, db.IssueGap: 2}, map[string]int{}},
+		{"last msg", func(udb db.UDB, msgs []*core.Message) error {
+			return udb.Set(db.BucketLastMID, common.Hash2String(msgs[1].SenderID), common.Hash2Bytes(msgs[1].ID()))
+		}, map[string]int{db.IssueMismatch: 1}, map[string]int{}},
+		{"dangling order", func(udb db.UDB, msgs []*core.Message) error {
+			return udb.Del(db.BucketMsg, common.Hash2String(msgs[3].ID()))
+		}, map[string]int{db.IssueMismatch: 1, db.IssueDangling: 6}, map[string]int{}},
+		{"dangling reference", func(udb db.UDB, msgs []*core.Message) error {
+			return udb.Del(db.BucketMsg, common.Hash2String(msgs[0].ID()))
+		}, map[string]int{db.IssueMismatch: 1, db.IssueDangling: 5}, map[string]int{db.IssueDangling: 1}},
+		{"corrupted", func(udb db.UDB, msgs []*core.Message) error {
+			return udb.Set(db.BucketMsg, common.Hash2String(msgs[3].ID()), []byte("corrupted"))
+		}, map[string]int{db.IssueCorrupted: 1, db.IssueMismatch: 1, db.IssueDangling: 6}, map[string]int{}},
+		{"wrong key", func(udb db.UDB, msgs []*core.Message) error {
+			msgBytes, err := udb.Get(db.BucketMsg, common.Hash2String(msgs[3].ID()))
+			if err != nil {
+				return err
+			}
+			if err := udb.Del(db.BucketMsg, common.Hash2String(msgs[3].ID())); err != nil {
+				return err
+			}
+			return udb.Set(db.BucketMsg, "wrong", msgBytes)
+		}, map[string]int{db.IssueCorrupted: 1}, map[string]int{}},
+	}
+
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "pdu_db_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		udb, msgs := createCheckDB(t, dir)
+		if err := c.corrupt(udb, msgs); err != nil {
+			t.Fatal(err)
+		}
+		res, err := db.CheckDB(udb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameKinds(issueKinds(res, msgs), c.issues) {
+			t.Errorf("%s : issues should be %v, but %v", c.name, c.issues, res.Issues)
+		}
+		if err := db.RepairDB(udb); err != nil {
+			t.Fatal(err)
+		}
+		if res, err = db.CheckDB(udb); err != nil {
+			t.Fatal(err)
+		}
+		if !sameKinds(issueKinds(res, msgs), c.remain) {
+			t.Errorf("%s : issues after repair should be %v, but %v", c.name, c.remain, res.Issues)
+		}
+		// signature of msgs[2] can not be repaired
+		if res.MsgCount > 2 && !hasIssue(res, db.IssueSignature, common.Hash2String(msgs[2].ID())) {
+			t.Errorf("%s : signature issue should be found", c.name)
+		}
+		udb.Close()
+	}
+}
+
+func TestRepairDB_Order(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdu_db_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	udb, msgs := createCheckDB(t, dir)
+	defer udb.Close()
+	// reverse the order, msgs should be sorted by references after repair
+	for i, msg := range msgs {
+		order := big.NewInt(int64(len(msgs) - 1 - i))
+		if err := udb.Set(db.BucketMID, order.String(), common.Hash2Bytes(msg.ID())); err != nil {
+			t.Fatal(err)
+		}
+		if err := udb.Set(db.BucketMOD, common.Hash2String(msg.ID()), order.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.RepairDB(udb); err != nil {
+		t.Fatal(err)
+	}
+	saved := db.GetMsgByOrder(udb, big.NewInt(0), len(msgs))
+	if len(saved) != len(msgs) {
+		t.Fatal("msg count not match", len(saved))
+	}
+	for i, msg := range saved {
+		if msg.ID() != msgs[i].ID() {
+			t.Error("msg order not match", i)
+		}
+	}
+}
+
+func TestCheckDB_Backends(t *testing.T) {
+	users, priKeys := coretest.RootUsers(t)
+	for _, name := range backend.Names() {
+		dir, err := ioutil.TempDir("", "pdu_db_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		udb := openTestDB(t, name, dir)
+		if err := db.SaveRootUsers(udb, users); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := core.CreateMsg(users[0], &core.MsgValue{ContentType: core.TypeText, Content: []byte("msg")}, priKeys[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveMsg(udb, msg); err != nil {
+			t.Fatal(err)
+		}
+		if res, err := db.CheckDB(udb); err != nil || len(res.Issues) != 0 {
+			t.Errorf("%s : should be no issues, %v %v", name, res, err)
+		}
+		udb.Close()
+	}
+}
+
+func sameKinds(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func hasIssue(res *db.CheckResult, kind, key string) bool {
+	for _, issue := range res.Issues {
+		if issue.Kind == kind && issue.Key == key {
+			return true
+		}
+	}
+	return false
+}
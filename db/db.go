@@ -16,6 +16,31 @@
 
 package db
 
+import "errors"
+
+var (
+	// ErrBackendNotSupport returns when the db backend is unknown
+	ErrBackendNotSupport = errors.New("db backend not support")
+
+	// ErrBucketNotExist returns when the bucket is not created yet
+	ErrBucketNotExist = errors.New("bucket not exist")
+)
+
+// Backends of UDB, selected by config
+const (
+	// BackendBolt is the bolt db, saved in single file
+	BackendBolt = "bolt"
+
+	// BackendLevelDB is the leveldb, saved in directory
+	BackendLevelDB = "leveldb"
+
+	// BackendMemory is the db in memory, used for test or ephemeral node
+	BackendMemory = "memory"
+
+	// DefaultBackend is the default backend of UDB
+	DefaultBackend = BackendBolt
+)
+
 const (
 	// BucketUser is used to save all users
 	BucketUser = "user"
@@ -32,6 +57,18 @@ const (
 	// BucketLastMID is used to save last msg.ID by user.ID
 	BucketLastMID = "lmid"
 
+	// BucketSenderMsg is used to index msg.ID by sender in order (user.ID/order/msg.ID)
+	BucketSenderMsg = "smsg"
+
+	// BucketTypeMsg is used to index msg.ID by content type in order (type/order/msg.ID)
+	BucketTypeMsg = "tmsg"
+
+	// BucketRefMsg is used to index msg.ID by referenced msg in order (ref.MsgID/order/msg.ID)
+	BucketRefMsg = "rmsg"
+
+	// BucketSearch is used to save terms in content of TypeText msg (term/msg.ID/msg.ID)
+	BucketSearch = "search"
+
 	// BucketConfig is used to save config info when universe be created
 	BucketConfig = "config"
 
@@ -64,8 +101,28 @@ const (
 
 	// ConfigUniverseRedshiftConstant is local constant for dynamice universe model.
 	ConfigUniverseRedshiftConstant = "universe_red_shift"
+
+	// ConfigSnapshot is the latest snapshot of universe, used to restart without
+	// replay all messages
+	ConfigSnapshot = "snapshot"
+
+	// ConfigSchemaVersion is the version of buckets layout and encoding in db,
+	// the data dir without it is version 0
+	ConfigSchemaVersion = "schema_version"
+
+	// ConfigMsgCodec is the codec of msgs saved in BucketMsg, core.CodecBinary if not set,
+	// the db before schema version 4 is set to core.CodecJSON by migration,
+	// the msgs saved by any codec can be read
+	ConfigMsgCodec = "msg_codec"
+
+	// ConfigSearchCount is the count of msgs by order which have been indexed for search
+	ConfigSearchCount = "search_count"
 )
 
+// Buckets is all buckets used by pdu
+var Buckets = []string{BucketConfig, BucketUser, BucketMsg, BucketMID, BucketMOD, BucketLastMID, BucketPeer,
+	BucketSenderMsg, BucketTypeMsg, BucketRefMsg, BucketSearch}
+
 const (
 	// StepInitDB is the step which all bucket in db have been created
 	StepInitDB = iota
@@ -80,6 +137,13 @@ type Row struct {
 	V []byte
 }
 
+// Tx is the transaction of UDB, all changes in one transaction are applied atomically
+type Tx interface {
+	Set(string, string, []byte) error
+	Get(string, string) ([]byte, error)
+	Del(string, string) error
+}
+
 // UDB is a database interface for embed database, default db is bolt
 type UDB interface {
 	Close() error
@@ -89,4 +153,7 @@ type UDB interface {
 	Get(string, string) ([]byte, error)
 	Del(string, string) error
 	Find(string, string, ...int) ([]*Row, error)
+	// Update run the func in one transaction, the changes are committed if func
+	// return nil, otherwise discarded. The func should only access db by tx.
+	Update(func(Tx) error) error
 }
@@ -0,0 +1,134 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// indexBuckets is the buckets of secondary indexes of msg
+var indexBuckets = []string{BucketSenderMsg, BucketTypeMsg, BucketRefMsg}
+
+func senderPrefix(senderID common.Hash) string {
+	return common.Hash2String(senderID) + "/"
+}
+
+func typePrefix(contentType int) string {
+	return fmt.Sprintf("%d/", contentType)
+}
+
+func refPrefix(msgID common.Hash) string {
+	return common.Hash2String(msgID) + "/"
+}
+
+// orderKey is the key in index, the order is padded by zero so the keys are sorted by order
+func orderKey(prefix string, order uint64) string {
+	return fmt.Sprintf("%s%020d", prefix, order)
+}
+
+// msgIndexKeys return the keys of msg in each index bucket
+func msgIndexKeys(msg *core.Message, order uint64) map[string][]string {
+	keys := map[string][]string{
+		BucketSenderMsg: {orderKey(senderPrefix(msg.SenderID), order)},
+		BucketTypeMsg:   {orderKey(typePrefix(msg.Value.ContentType), order)},
+	}
+	refs := make(map[common.Hash]bool)
+	for _, ref := range msg.Reference {
+		if !refs[ref.MsgID] {
+			refs[ref.MsgID] = true
+			keys[BucketRefMsg] = append(keys[BucketRefMsg], orderKey(refPrefix(ref.MsgID), order))
+		}
+	}
+	return keys
+}
+
+// indexMsg save the msg into all index buckets
+func indexMsg(tx Tx, msg *core.Message, order uint64) error {
+	for bucket, keys := range msgIndexKeys(msg, order) {
+		for _, key := range keys {
+			if err := tx.Set(bucket, key, common.Hash2Bytes(msg.ID())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexAllMsgs save all msgs in order into index buckets
+func indexAllMsgs(udb UDB) error {
+	count, err := GetMsgCount(udb)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < count.Uint64(); i++ {
+		mid, err := udb.Get(BucketMID, new(big.Int).SetUint64(i).String())
+		if err != nil {
+			return err
+		} else if mid == nil {
+			continue
+		}
+		msg, err := GetMsgByID(udb, common.Bytes2Hash(mid))
+		if err == ErrMessageNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		order := i
+		if err := udb.Update(func(tx Tx) error {
+			return indexMsg(tx, msg, order)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMsgsByIndex get the msgs from index bucket by prefix in order
+func getMsgsByIndex(udb UDB, bucket, prefix string, skip, limit int) ([]*core.Message, error) {
+	rows, err := udb.Find(bucket, prefix, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []*core.Message{}
+	for _, row := range rows {
+		msg, err := GetMsgByID(udb, common.Bytes2Hash(row.V))
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// GetMsgsBySender get the msgs sent by user in order, used as timeline of user
+func GetMsgsBySender(udb UDB, senderID common.Hash, skip, limit int) ([]*core.Message, error) {
+	return getMsgsByIndex(udb, BucketSenderMsg, senderPrefix(senderID), skip, limit)
+}
+
+// GetMsgsByType get the msgs by content type in order
+func GetMsgsByType(udb UDB, contentType int, skip, limit int) ([]*core.Message, error) {
+	return getMsgsByIndex(udb, BucketTypeMsg, typePrefix(contentType), skip, limit)
+}
+
+// GetChildMsgs get the msgs which reference the msg in order, used as thread of msg
+func GetChildMsgs(udb UDB, msgID common.Hash, skip, limit int) ([]*core.Message, error) {
+	return getMsgsByIndex(udb, BucketRefMsg, refPrefix(msgID), skip, limit)
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+func sameMsgs(a, b []*core.Message) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID() != b[i].ID() {
+			return false
+		}
+	}
+	return true
+}
+
+func checkIndexes(t *testing.T, udb db.UDB, msgs []*core.Message) {
+	cases := []struct {
+		name   string
+		query  func() ([]*core.Message, error)
+		expect []*core.Message
+	}{
+		{"sender", func() ([]*core.Message, error) {
+			return db.GetMsgsBySender(udb, msgs[0].SenderID, 0, 10)
+		}, []*core.Message{msgs[0], msgs[2]}},
+		{"sender page", func() ([]*core.Message, error) {
+			return db.GetMsgsBySender(udb, msgs[1].SenderID, 1, 1)
+		}, []*core.Message{msgs[3]}},
+		{"type", func() ([]*core.Message, error) {
+			return db.GetMsgsByType(udb, core.TypeText, 0, 10)
+		}, msgs},
+		{"type page", func() ([]*core.Message, error) {
+			return db.GetMsgsByType(udb, core.TypeText, 1, 2)
+		}, msgs[1:3]},
+		{"type empty", func() ([]*core.Message, error) {
+			return db.GetMsgsByType(udb, core.TypeBirth, 0, 10)
+		}, []*core.Message{}},
+		{"children", func() ([]*core.Message, error) {
+			return db.GetChildMsgs(udb, msgs[1].ID(), 0, 10)
+		}, []*core.Message{msgs[2]}},
+		{"no children", func() ([]*core.Message, error) {
+			return db.GetChildMsgs(udb, msgs[3].ID(), 0, 10)
+		}, []*core.Message{}},
+	}
+	for _, c := range cases {
+		res, err := c.query()
+		if err != nil {
+			t.Error(c.name, err)
+		} else if !sameMsgs(res, c.expect) {
+			t.Errorf("%s : should get %d msgs, but %d", c.name, len(c.expect), len(res))
+		}
+	}
+}
+
+func TestMsgIndexes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdu-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	udb, msgs := createCheckDB(t, dir)
+	defer udb.Close()
+
+	checkIndexes(t, udb, msgs)
+}
+
+func TestMsgIndexes_Migrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdu-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	udb, msgs := createCheckDB(t, dir)
+	defer udb.Close()
+
+	// db of schema version 1 has no index buckets
+	for _, bucket := range []string{db.BucketSenderMsg, db.BucketTypeMsg, db.BucketRefMsg} {
+		if err := udb.DeleteBucket(bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetSchemaVersion(udb, 1); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := db.Migrate(udb, db.Migrations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) == 0 || applied[0].Version != 2 {
+		t.Fatal("migration of version 2 should be applied")
+	}
+	checkIndexes(t, udb, msgs)
+
+	res, err := db.CheckDB(udb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, issue := range res.Issues {
+		if issue.Kind != db.IssueSignature || issue.Key != common.Hash2String(msgs[2].ID()) {
+			t.Error("index should be consistent after migrate", issue)
+		}
+	}
+}
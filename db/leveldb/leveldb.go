@@ -0,0 +1,239 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package leveldb
+
+import (
+	"errors"
+
+	"github.com/pdupub/go-pdu/db"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	errFindMissingLimit         = errors.New("find operate missing limit")
+	errFindArgsNumberNotCorrect = errors.New("find operate number not correct")
+	errBucketExist              = errors.New("bucket already exist")
+)
+
+const (
+	// prefixBucket is the prefix of key which mark the bucket exist
+	prefixBucket = 'b'
+	// prefixData is the prefix of key/val in bucket
+	prefixData = 'd'
+	// separator is between bucket name and key
+	separator = 0x00
+)
+
+// ULevelDB is the db struct by leveldb, buckets are mapped to key prefix
+type ULevelDB struct {
+	db *leveldb.DB
+}
+
+// NewDB initialize the new leveldb, create if no db in given path
+func NewDB(path string) (*ULevelDB, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ULevelDB{db}, nil
+}
+
+func bucketKey(bucketName string) []byte {
+	return append([]byte{prefixBucket}, bucketName...)
+}
+
+func dataKey(bucketName, key string) []byte {
+	k := append([]byte{prefixData}, bucketName...)
+	k = append(k, separator)
+	return append(k, key...)
+}
+
+func (u *ULevelDB) bucketExist(bucketName string) (bool, error) {
+	return u.db.Has(bucketKey(bucketName), nil)
+}
+
+func (u *ULevelDB) checkBucket(bucketName string) error {
+	return checkBucket(u.db, bucketName)
+}
+
+// reader is the common part of leveldb and its transaction used to check bucket
+type reader interface {
+	Has(key []byte, ro *opt.ReadOptions) (bool, error)
+}
+
+func checkBucket(r reader, bucketName string) error {
+	exist, err := r.Has(bucketKey(bucketName), nil)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return db.ErrBucketNotExist
+	}
+	return nil
+}
+
+// Close the leveldb
+func (u *ULevelDB) Close() error {
+	return u.db.Close()
+}
+
+// CreateBucket create new bucket by name
+func (u *ULevelDB) CreateBucket(bucketName string) error {
+	exist, err := u.bucketExist(bucketName)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return errBucketExist
+	}
+	return u.db.Put(bucketKey(bucketName), []byte{}, nil)
+}
+
+// DeleteBucket delete the bucket by name, with all key/val in it
+func (u *ULevelDB) DeleteBucket(bucketName string) error {
+	if err := u.checkBucket(bucketName); err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	iter := u.db.NewIterator(util.BytesPrefix(dataKey(bucketName, "")), nil)
+	for iter.Next() {
+		batch.Delete(append([]byte{}, iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	batch.Delete(bucketKey(bucketName))
+	return u.db.Write(batch, nil)
+}
+
+// Set key/val into bucket
+func (u *ULevelDB) Set(bucketName, key string, val []byte) error {
+	if err := u.checkBucket(bucketName); err != nil {
+		return err
+	}
+	return u.db.Put(dataKey(bucketName, key), val, nil)
+}
+
+// Get val by key from bucket
+func (u *ULevelDB) Get(bucketName, key string) ([]byte, error) {
+	if err := u.checkBucket(bucketName); err != nil {
+		return nil, err
+	}
+	val, err := u.db.Get(dataKey(bucketName, key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return val, err
+}
+
+// Del val by key from bucket
+func (u *ULevelDB) Del(bucketName, key string) error {
+	if err := u.checkBucket(bucketName); err != nil {
+		return err
+	}
+	return u.db.Delete(dataKey(bucketName, key), nil)
+}
+
+// Update run the func in one leveldb transaction, other writes are blocked until it done
+func (u *ULevelDB) Update(fn func(db.Tx) error) error {
+	tr, err := u.db.OpenTransaction()
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tr.Discard()
+		}
+	}()
+	if err := fn(&levelTx{tr}); err != nil {
+		return err
+	}
+	committed = true
+	return tr.Commit()
+}
+
+// Find the rows from bucket by prefix
+func (u *ULevelDB) Find(bucketName, prefix string, args ...int) (rows []*db.Row, err error) {
+	var skip, limit int
+	if len(args) == 0 {
+		return rows, errFindMissingLimit
+	} else if len(args) == 1 {
+		skip = 0
+		limit = args[0]
+	} else if len(args) == 2 {
+		skip = args[0]
+		limit = args[1]
+	} else {
+		return rows, errFindArgsNumberNotCorrect
+	}
+	if err := u.checkBucket(bucketName); err != nil {
+		return rows, err
+	}
+
+	keyStart := len(dataKey(bucketName, ""))
+	iter := u.db.NewIterator(util.BytesPrefix(dataKey(bucketName, prefix)), nil)
+	defer iter.Release()
+	count := 0
+	for iter.Next() {
+		if count >= skip+limit {
+			break
+		}
+		if count >= skip {
+			rows = append(rows, &db.Row{K: string(iter.Key()[keyStart:]), V: append([]byte{}, iter.Value()...)})
+		}
+		count++
+	}
+	return rows, iter.Error()
+}
+
+// levelTx is the db.Tx by leveldb transaction
+type levelTx struct {
+	tr *leveldb.Transaction
+}
+
+// Set key/val into bucket
+func (t *levelTx) Set(bucketName, key string, val []byte) error {
+	if err := checkBucket(t.tr, bucketName); err != nil {
+		return err
+	}
+	return t.tr.Put(dataKey(bucketName, key), val, nil)
+}
+
+// Get val by key from bucket
+func (t *levelTx) Get(bucketName, key string) ([]byte, error) {
+	if err := checkBucket(t.tr, bucketName); err != nil {
+		return nil, err
+	}
+	val, err := t.tr.Get(dataKey(bucketName, key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return val, err
+}
+
+// Del val by key from bucket
+func (t *levelTx) Del(bucketName, key string) error {
+	if err := checkBucket(t.tr, bucketName); err != nil {
+		return err
+	}
+	return t.tr.Delete(dataKey(bucketName, key), nil)
+}
@@ -0,0 +1,234 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package memory
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pdupub/go-pdu/db"
+)
+
+var (
+	errFindMissingLimit         = errors.New("find operate missing limit")
+	errFindArgsNumberNotCorrect = errors.New("find operate number not correct")
+	errBucketExist              = errors.New("bucket already exist")
+	errDBClosed                 = errors.New("db closed")
+)
+
+// UMemoryDB is the db struct in memory, all data lost after close
+type UMemoryDB struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewDB initialize the new memory DB
+func NewDB() *UMemoryDB {
+	return &UMemoryDB{buckets: make(map[string]map[string][]byte)}
+}
+
+// Close the memory DB
+func (u *UMemoryDB) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.buckets == nil {
+		return errDBClosed
+	}
+	u.buckets = nil
+	return nil
+}
+
+// CreateBucket create new bucket by name
+func (u *UMemoryDB) CreateBucket(bucketName string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.buckets == nil {
+		return errDBClosed
+	}
+	if _, ok := u.buckets[bucketName]; ok {
+		return errBucketExist
+	}
+	u.buckets[bucketName] = make(map[string][]byte)
+	return nil
+}
+
+// DeleteBucket delete the bucket by name
+func (u *UMemoryDB) DeleteBucket(bucketName string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.buckets[bucketName]; !ok {
+		return db.ErrBucketNotExist
+	}
+	delete(u.buckets, bucketName)
+	return nil
+}
+
+// Set key/val into bucket
+func (u *UMemoryDB) Set(bucketName, key string, val []byte) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	b, ok := u.buckets[bucketName]
+	if !ok {
+		return db.ErrBucketNotExist
+	}
+	b[key] = append([]byte{}, val...)
+	return nil
+}
+
+// Get val by key from bucket
+func (u *UMemoryDB) Get(bucketName, key string) ([]byte, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	b, ok := u.buckets[bucketName]
+	if !ok {
+		return nil, db.ErrBucketNotExist
+	}
+	val, ok := b[key]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, val...), nil
+}
+
+// Del val by key from bucket
+func (u *UMemoryDB) Del(bucketName, key string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	b, ok := u.buckets[bucketName]
+	if !ok {
+		return db.ErrBucketNotExist
+	}
+	delete(b, key)
+	return nil
+}
+
+// Update run the func with all changes staged, and apply them if func return nil,
+// the db is locked until func done
+func (u *UMemoryDB) Update(fn func(db.Tx) error) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.buckets == nil {
+		return errDBClosed
+	}
+	tx := &memoryTx{buckets: u.buckets, changes: make(map[string]map[string]*change)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for bucketName, changes := range tx.changes {
+		for key, c := range changes {
+			if c.deleted {
+				delete(u.buckets[bucketName], key)
+			} else {
+				u.buckets[bucketName][key] = c.val
+			}
+		}
+	}
+	return nil
+}
+
+// Find the rows from bucket by prefix, in order of key
+func (u *UMemoryDB) Find(bucketName, prefix string, args ...int) (rows []*db.Row, err error) {
+	var skip, limit int
+	if len(args) == 0 {
+		return rows, errFindMissingLimit
+	} else if len(args) == 1 {
+		skip = 0
+		limit = args[0]
+	} else if len(args) == 2 {
+		skip = args[0]
+		limit = args[1]
+	} else {
+		return rows, errFindArgsNumberNotCorrect
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	b, ok := u.buckets[bucketName]
+	if !ok {
+		return rows, db.ErrBucketNotExist
+	}
+	var keys []string
+	for k := range b {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for count, k := range keys {
+		if count >= skip+limit {
+			break
+		}
+		if count >= skip {
+			rows = append(rows, &db.Row{K: k, V: append([]byte{}, b[k]...)})
+		}
+	}
+	return rows, nil
+}
+
+// change is the staged change of key in memoryTx
+type change struct {
+	val     []byte
+	deleted bool
+}
+
+// memoryTx is the db.Tx which stage all changes until commit
+type memoryTx struct {
+	buckets map[string]map[string][]byte
+	changes map[string]map[string]*change
+}
+
+func (t *memoryTx) stage(bucketName, key string, c *change) error {
+	if _, ok := t.buckets[bucketName]; !ok {
+		return db.ErrBucketNotExist
+	}
+	if _, ok := t.changes[bucketName]; !ok {
+		t.changes[bucketName] = make(map[string]*change)
+	}
+	t.changes[bucketName][key] = c
+	return nil
+}
+
+// Set key/val into bucket
+func (t *memoryTx) Set(bucketName, key string, val []byte) error {
+	return t.stage(bucketName, key, &change{val: append([]byte{}, val...)})
+}
+
+// Get val by key from bucket, the staged change is returned if exist
+func (t *memoryTx) Get(bucketName, key string) ([]byte, error) {
+	b, ok := t.buckets[bucketName]
+	if !ok {
+		return nil, db.ErrBucketNotExist
+	}
+	if c, ok := t.changes[bucketName][key]; ok {
+		if c.deleted {
+			return nil, nil
+		}
+		return append([]byte{}, c.val...), nil
+	}
+	val, ok := b[key]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, val...), nil
+}
+
+// Del val by key from bucket
+func (t *memoryTx) Del(bucketName, key string) error {
+	return t.stage(bucketName, key, &change{deleted: true})
+}
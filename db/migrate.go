@@ -0,0 +1,181 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/pdupub/go-pdu/core"
+)
+
+// SchemaVersion is the current schema version of db, equal to the version of last migration
+const SchemaVersion = 4
+
+var (
+	// ErrSchemaTooNew returns when the schema version of db is newer than current
+	ErrSchemaTooNew = errors.New("schema version of db is newer than current")
+
+	// ErrMigrationOrder returns when the versions of migrations are not increasing
+	ErrMigrationOrder = errors.New("migrations not in order")
+)
+
+// Migration upgrade the db to Version from the version before it,
+// Migrate must be idempotent, because it will run again if the db
+// crashed before the version be saved.
+type Migration struct {
+	Version uint64
+	Name    string
+	Migrate func(udb UDB) error
+}
+
+// Migrations is all migrations of db in order
+var Migrations = []*Migration{
+	{Version: 1, Name: "create missing buckets", Migrate: createBuckets(BucketConfig, BucketUser, BucketMsg, BucketMID, BucketMOD, BucketLastMID, BucketPeer)},
+	{Version: 2, Name: "index msgs by sender, type and reference", Migrate: func(udb UDB) error {
+		if err := createBuckets(BucketSenderMsg, BucketTypeMsg, BucketRefMsg)(udb); err != nil {
+			return err
+		}
+		return indexAllMsgs(udb)
+	}},
+	{Version: 3, Name: "create bucket of full-text search", Migrate: createBuckets(BucketSearch)},
+	{Version: 4, Name: "keep json codec of msgs", Migrate: keepJSONCodec},
+}
+
+// createBuckets return the migration which create the buckets not exist in db,
+// each migration list the buckets introduced by it, so the later buckets
+// added into Buckets not change the migrations before.
+func createBuckets(buckets ...string) func(udb UDB) error {
+	return func(udb UDB) error {
+		for _, bucket := range buckets {
+			if _, err := udb.Find(bucket, "", 1); err == nil {
+				continue
+			} else if err != ErrBucketNotExist {
+				return err
+			}
+			if err := udb.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// keepJSONCodec set the msg codec to json if not set, because the msgs of
+// db before version 4 are saved in json, only new db use the binary codec.
+func keepJSONCodec(udb UDB) error {
+	codec, err := udb.Get(BucketConfig, ConfigMsgCodec)
+	if err != nil {
+		return err
+	}
+	if codec != nil {
+		return nil
+	}
+	return udb.Set(BucketConfig, ConfigMsgCodec, []byte(core.CodecJSON))
+}
+
+// GetSchemaVersion return the schema version of db, 0 if not set
+func GetSchemaVersion(udb UDB) (uint64, error) {
+	versionBytes, err := udb.Get(BucketConfig, ConfigSchemaVersion)
+	if err != nil {
+		return 0, err
+	}
+	return new(big.Int).SetBytes(versionBytes).Uint64(), nil
+}
+
+// SetSchemaVersion save the schema version of db
+func SetSchemaVersion(udb UDB, version uint64) error {
+	return udb.Set(BucketConfig, ConfigSchemaVersion, new(big.Int).SetUint64(version).Bytes())
+}
+
+// PendingMigrations return the migrations newer than the schema version of db
+func PendingMigrations(udb UDB, migrations []*Migration) ([]*Migration, error) {
+	version, err := GetSchemaVersion(udb)
+	if err != nil {
+		return nil, err
+	}
+	var pending []*Migration
+	var last uint64
+	for _, m := range migrations {
+		if m.Version <= last {
+			return nil, ErrMigrationOrder
+		}
+		last = m.Version
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	if version > last {
+		return nil, ErrSchemaTooNew
+	}
+	return pending, nil
+}
+
+// Migrate run the pending migrations in order, the backup is called before each
+// migration if not nil, and the schema version is saved after each migration.
+func Migrate(udb UDB, migrations []*Migration, backup func(*Migration) error) ([]*Migration, error) {
+	pending, err := PendingMigrations(udb, migrations)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range pending {
+		if backup != nil {
+			if err := backup(m); err != nil {
+				return pending[:i], err
+			}
+		}
+		if err := m.Migrate(udb); err != nil {
+			return pending[:i], err
+		}
+		if err := SetSchemaVersion(udb, m.Version); err != nil {
+			return pending[:i], err
+		}
+	}
+	return pending, nil
+}
+
+// CopyDB copy all buckets in Buckets from src to dst, the buckets not exist
+// in src are skipped, used to backup db or dry run migrations.
+func CopyDB(dst, src UDB) error {
+	for _, bucket := range Buckets {
+		rows, err := findAll(src, bucket)
+		if err == ErrBucketNotExist {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, err := dst.Find(bucket, "", 1); err == ErrBucketNotExist {
+			if err := dst.CreateBucket(bucket); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		err = dst.Update(func(tx Tx) error {
+			for _, row := range rows {
+				if err := tx.Set(bucket, row.K, row.V); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memory"
+)
+
+func TestMigrations(t *testing.T) {
+	last := db.Migrations[len(db.Migrations)-1]
+	if last.Version != db.SchemaVersion {
+		t.Errorf("schema version should be %d, but %d", last.Version, db.SchemaVersion)
+	}
+	// all migrations run on data dir of version 0
+	udb := memory.NewDB()
+	if err := udb.CreateBucket(db.BucketConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := udb.Set(db.BucketConfig, "key", []byte("val")); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := db.Migrate(udb, db.Migrations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(db.Migrations) {
+		t.Error("all migrations should be applied", len(applied))
+	}
+	for _, bucket := range db.Buckets {
+		if _, err := udb.Find(bucket, "", 1); err != nil {
+			t.Error("bucket should be created", bucket)
+		}
+	}
+	// migrations are idempotent
+	for _, m := range db.Migrations {
+		if err := m.Migrate(udb); err != nil {
+			t.Errorf("migration %d run again fail %s", m.Version, err)
+		}
+	}
+	if val, err := udb.Get(db.BucketConfig, "key"); err != nil || string(val) != "val" {
+		t.Error("data should not be changed", err)
+	}
+	// msgs saved before keep the json codec
+	if codec, err := udb.Get(db.BucketConfig, db.ConfigMsgCodec); err != nil || string(codec) != core.CodecJSON {
+		t.Error("msg codec should be json", string(codec), err)
+	}
+	if err := db.SetMsgCodec(udb, core.CodecBinary); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrations[len(db.Migrations)-1].Migrate(udb); err != nil {
+		t.Fatal(err)
+	}
+	if codec, err := udb.Get(db.BucketConfig, db.ConfigMsgCodec); err != nil || string(codec) != core.CodecBinary {
+		t.Error("msg codec set should not be changed", string(codec), err)
+	}
+	// bucket is only created if not exist, other errors are returned
+	if err := db.Migrations[0].Migrate(&failFindDB{UDB: udb}); err != errFind {
+		t.Errorf("migrate err should be %s, but now err : %v", errFind, err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	var ran []string
+	record := func(name string) func(db.UDB) error {
+		return func(udb db.UDB) error {
+			ran = append(ran, name)
+			return udb.Set(db.BucketConfig, name, []byte(name))
+		}
+	}
+	errFail := errors.New("fail")
+	migrations := []*db.Migration{
+		{Version: 1, Name: "m1", Migrate: record("m1")},
+		{Version: 2, Name: "m2", Migrate: record("m2")},
+		{Version: 4, Name: "m4", Migrate: record("m4")},
+	}
+
+	udb := memory.NewDB()
+	if err := udb.CreateBucket(db.BucketConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetSchemaVersion(udb, 1); err != nil {
+		t.Fatal(err)
+	}
+	// backup before each migration, with the version before it
+	var backups []string
+	backup := func(m *db.Migration) error {
+		version, err := db.GetSchemaVersion(udb)
+		if err != nil {
+			return err
+		}
+		backups = append(backups, fmt.Sprintf("%d>%d", version, m.Version))
+		return nil
+	}
+	applied, err := db.Migrate(udb, migrations, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || fmt.Sprint(ran) != "[m2 m4]" || fmt.Sprint(backups) != "[1>2 2>4]" {
+		t.Error("migrations not run in order", ran, backups)
+	}
+	if version, err := db.GetSchemaVersion(udb); err != nil || version != 4 {
+		t.Error("schema version not saved", version, err)
+	}
+	// nothing to run
+	if applied, err := db.Migrate(udb, migrations, backup); err != nil || len(applied) != 0 {
+		t.Error("no migration should run", applied, err)
+	}
+
+	// schema of db newer than migrations
+	if _, err := db.Migrate(udb, migrations[:2], nil); err != db.ErrSchemaTooNew {
+		t.Error("newer schema should not be migrated", err)
+	}
+	// versions not in order
+	if _, err := db.PendingMigrations(udb, []*db.Migration{migrations[1], migrations[0]}); err != db.ErrMigrationOrder {
+		t.Error("migrations not in order should fail", err)
+	}
+
+	// migration stop at fail, the version of last success is saved
+	ran = nil
+	udb = memory.NewDB()
+	if err := udb.CreateBucket(db.BucketConfig); err != nil {
+		t.Fatal(err)
+	}
+	failed := []*db.Migration{migrations[0], {Version: 2, Name: "fail", Migrate: func(db.UDB) error { return errFail }}, migrations[2]}
+	if applied, err := db.Migrate(udb, failed, nil); err != errFail || len(applied) != 1 {
+		t.Error("migration should stop at fail", applied, err)
+	}
+	if version, err := db.GetSchemaVersion(udb); err != nil || version != 1 {
+		t.Error("schema version should be last success", version, err)
+	}
+	// backup fail stop migration before it run
+	ran = nil
+	if _, err := db.Migrate(udb, migrations, func(*db.Migration) error { return errFail }); err != errFail || len(ran) != 0 {
+		t.Error("migration should not run if backup fail", ran, err)
+	}
+}
+
+func TestCopyDB(t *testing.T) {
+	src := memory.NewDB()
+	for _, bucket := range []string{db.BucketConfig, db.BucketMsg} {
+		if err := src.CreateBucket(bucket); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := src.Set(bucket, fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("%s%d", bucket, i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	dst := memory.NewDB()
+	if err := db.CopyDB(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	for _, bucket := range []string{db.BucketConfig, db.BucketMsg} {
+		for i := 0; i < 3; i++ {
+			if val, err := dst.Get(bucket, fmt.Sprintf("key%d", i)); err != nil || string(val) != fmt.Sprintf("%s%d", bucket, i) {
+				t.Error("val not copied", bucket, i, err)
+			}
+		}
+	}
+	if _, err := dst.Find(db.BucketUser, "", 1); err == nil {
+		t.Error("bucket not exist in src should not be created")
+	}
+	// other errors of src are returned
+	if err := db.CopyDB(memory.NewDB(), &failFindDB{UDB: src}); err != errFind {
+		t.Errorf("copy err should be %s, but now err : %v", errFind, err)
+	}
+}
+
+var errFind = errors.New("find fail")
+
+// failFindDB fail on every find
+type failFindDB struct {
+	db.UDB
+}
+
+func (f *failFindDB) Find(string, string, ...int) ([]*db.Row, error) {
+	return nil, errFind
+}
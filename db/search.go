@@ -0,0 +1,181 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"math/big"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// maxSearchTermLen is the max number of runes in one term, the longer is truncated
+const maxSearchTermLen = 64
+
+// searchTerms split the text into lower case terms without duplicate, the term is
+// continuous letters or digits, and each Han character is one term.
+func searchTerms(text string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	add := func(term []rune) {
+		if len(term) > maxSearchTermLen {
+			term = term[:maxSearchTermLen]
+		}
+		if t := string(term); !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	var term []rune
+	for _, r := range strings.ToLower(text) {
+		if unicode.Is(unicode.Han, r) {
+			if len(term) > 0 {
+				add(term)
+				term = nil
+			}
+			add([]rune{r})
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			term = append(term, r)
+		} else if len(term) > 0 {
+			add(term)
+			term = nil
+		}
+	}
+	if len(term) > 0 {
+		add(term)
+	}
+	return terms
+}
+
+func searchKey(term string, msgID common.Hash) string {
+	return term + "/" + common.Hash2String(msgID)
+}
+
+// indexText save the terms in content of TypeText msg
+func indexText(tx Tx, msg *core.Message) error {
+	if msg.Value == nil || msg.Value.ContentType != core.TypeText {
+		return nil
+	}
+	for _, term := range searchTerms(string(msg.Value.Content)) {
+		if err := tx.Set(BucketSearch, searchKey(term, msg.ID()), common.Hash2Bytes(msg.ID())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateSearchIndex index the content of TypeText msgs saved after last update, the
+// terms of each msg are saved with the progress in one transaction.
+func UpdateSearchIndex(udb UDB) error {
+	count, err := GetMsgCount(udb)
+	if err != nil {
+		return err
+	}
+	searchedBytes, err := udb.Get(BucketConfig, ConfigSearchCount)
+	if err != nil {
+		return err
+	}
+	for i := new(big.Int).SetBytes(searchedBytes); i.Cmp(count) < 0; {
+		var msg *core.Message
+		if mid, err := udb.Get(BucketMID, i.String()); err != nil {
+			return err
+		} else if mid != nil {
+			if msg, err = GetMsgByID(udb, common.Bytes2Hash(mid)); err != nil && err != ErrMessageNotFound {
+				return err
+			}
+		}
+		i.Add(i, big.NewInt(1))
+		if err := udb.Update(func(tx Tx) error {
+			if msg != nil {
+				if err := indexText(tx, msg); err != nil {
+					return err
+				}
+			}
+			return tx.Set(BucketConfig, ConfigSearchCount, i.Bytes())
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchMsgs return the TypeText msgs which content contains all terms of text, the
+// newest msg first. The msgs not accepted by filter are dropped before skip and limit.
+func SearchMsgs(udb UDB, text string, filter func(*core.Message) bool, skip, limit int) ([]*core.Message, error) {
+	msgs := []*core.Message{}
+	var matched map[string]bool
+	for _, term := range searchTerms(text) {
+		rows, err := findPrefix(udb, BucketSearch, term+"/")
+		if err != nil {
+			return nil, err
+		}
+		found := make(map[string]bool)
+		for _, row := range rows {
+			if msgID := common.Bytes2String(row.V); matched == nil || matched[msgID] {
+				found[msgID] = true
+			}
+		}
+		if matched = found; len(matched) == 0 {
+			return msgs, nil
+		}
+	}
+
+	type orderedMsg struct {
+		id    common.Hash
+		order uint64
+	}
+	var ordered []*orderedMsg
+	for msgID := range matched {
+		id, err := common.String2Hash(msgID)
+		if err != nil {
+			continue
+		}
+		// terms of msg removed by repair is ignored
+		order, _, err := GetOrderCntByMsg(udb, id)
+		if err == ErrMessageNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ordered = append(ordered, &orderedMsg{id: id, order: order.Uint64()})
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].order > ordered[j].order })
+
+	for _, m := range ordered {
+		if len(msgs) >= limit {
+			break
+		}
+		msg, err := GetMsgByID(udb, m.id)
+		if err == ErrMessageNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if filter != nil && !filter(msg) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"testing"
+
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/core/coretest"
+	"github.com/pdupub/go-pdu/db"
+)
+
+func TestSearchMsgs(t *testing.T) {
+	users, priKeys := coretest.RootUsers(t)
+	udb := openTestDB(t, db.BackendMemory, "")
+	defer udb.Close()
+	if err := db.SaveRootUsers(udb, users); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []*core.Message
+	saveText := func(i int, text string) {
+		var refs []*core.MsgReference
+		if len(msgs) > 0 {
+			last := msgs[len(msgs)-1]
+			refs = append(refs, &core.MsgReference{SenderID: last.SenderID, MsgID: last.ID()})
+		}
+		msg, err := core.CreateMsg(users[i], &core.MsgValue{ContentType: core.TypeText, Content: []byte(text)}, priKeys[i], refs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveMsg(udb, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	saveText(0, "Hello, World!")
+	saveText(1, "hello pdu")
+	saveText(0, "世界你好")
+	if err := db.UpdateSearchIndex(udb); err != nil {
+		t.Fatal(err)
+	}
+
+	sentBy := func(i int) func(*core.Message) bool {
+		return func(msg *core.Message) bool { return msg.SenderID == users[i].ID() }
+	}
+	check := func(name, text string, filter func(*core.Message) bool, skip, limit int, expect []*core.Message) {
+		res, err := db.SearchMsgs(udb, text, filter, skip, limit)
+		if err != nil {
+			t.Error(name, err)
+		} else if !sameMsgs(res, expect) {
+			t.Errorf("%s : should get %d msgs, but %d", name, len(expect), len(res))
+		}
+	}
+	check("one term", "hello", nil, 0, 10, []*core.Message{msgs[1], msgs[0]})
+	check("all terms", "WORLD hello", nil, 0, 10, []*core.Message{msgs[0]})
+	check("han", "世界", nil, 0, 10, []*core.Message{msgs[2]})
+	check("not found", "hello bye", nil, 0, 10, []*core.Message{})
+	check("empty", "!!", nil, 0, 10, []*core.Message{})
+	check("page", "hello", nil, 1, 1, []*core.Message{msgs[0]})
+	check("sender", "hello", sentBy(0), 0, 10, []*core.Message{msgs[0]})
+	check("sender page", "hello", sentBy(0), 1, 10, []*core.Message{})
+
+	// new msg is searchable after update
+	saveText(1, "hello again")
+	check("before update", "again", nil, 0, 10, []*core.Message{})
+	if err := db.UpdateSearchIndex(udb); err != nil {
+		t.Fatal(err)
+	}
+	check("after update", "again", nil, 0, 10, []*core.Message{msgs[3]})
+
+	// progress of search is reset by repair, terms are indexed again
+	if err := db.RepairDB(udb); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := udb.Get(db.BucketConfig, db.ConfigSearchCount); err != nil || count != nil {
+		t.Error("search count should be reset by repair", err)
+	}
+	if err := db.UpdateSearchIndex(udb); err != nil {
+		t.Fatal(err)
+	}
+	check("after repair", "hello", nil, 0, 10, []*core.Message{msgs[3], msgs[1], msgs[0]})
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+var (
+	// ErrSnapshotNotFound returns when there is no snapshot in db
+	ErrSnapshotNotFound = errors.New("snapshot can not be found")
+
+	// ErrSnapshotCorrupted returns when the checksum of snapshot not match
+	ErrSnapshotCorrupted = errors.New("snapshot corrupted")
+)
+
+// snapshotRecord is the snapshot saved in db, order is the count of messages
+// already contained by the snapshot.
+type snapshotRecord struct {
+	Order    uint64      `json:"order"`
+	Checksum common.Hash `json:"checksum"`
+	Data     []byte      `json:"data"`
+}
+
+// SaveSnapshot save the snapshot of universe, which contain first order messages
+func SaveSnapshot(udb UDB, snapshot *core.Snapshot, order uint64) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	recordBytes, err := json.Marshal(&snapshotRecord{Order: order, Checksum: sha256.Sum256(data), Data: data})
+	if err != nil {
+		return err
+	}
+	return udb.Set(BucketConfig, ConfigSnapshot, recordBytes)
+}
+
+// GetSnapshot get the latest snapshot and the count of messages it contains
+func GetSnapshot(udb UDB) (*core.Snapshot, uint64, error) {
+	recordBytes, err := udb.Get(BucketConfig, ConfigSnapshot)
+	if err != nil {
+		return nil, 0, err
+	} else if recordBytes == nil {
+		return nil, 0, ErrSnapshotNotFound
+	}
+	var record snapshotRecord
+	if err := json.Unmarshal(recordBytes, &record); err != nil {
+		return nil, 0, ErrSnapshotCorrupted
+	}
+	if common.Hash(sha256.Sum256(record.Data)) != record.Checksum {
+		return nil, 0, ErrSnapshotCorrupted
+	}
+	var snapshot core.Snapshot
+	if err := json.Unmarshal(record.Data, &snapshot); err != nil {
+		return nil, 0, ErrSnapshotCorrupted
+	}
+	return &snapshot, record.Order, nil
+}
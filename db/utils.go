@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"errors"
 	"math/big"
+	"strings"
 
 	"github.com/pdupub/go-pdu/common"
 	"github.com/pdupub/go-pdu/core"
@@ -28,38 +29,39 @@ import (
 var (
 	// ErrMessageNotFound returns when the message not be found
 	ErrMessageNotFound = errors.New("message can not be found")
+
+	// ErrUserNotFound returns when the user not be found
+	ErrUserNotFound = errors.New("user can not be found")
+
+	// ErrUserPrefixNotUnique returns when more than one user match the ID prefix
+	ErrUserPrefixNotUnique = errors.New("user ID which has this prefix are not unique")
 )
 
-// SaveRootUsers is save two root users to db
+// SaveRootUsers is save two root users to db in one transaction
 func SaveRootUsers(udb UDB, users []*core.User) (err error) {
-	// save root users
 	var root0, root1 []byte
 	if root0, err = json.Marshal(users[0]); err != nil {
 		return err
 	}
-	if err = udb.Set(BucketConfig, ConfigRoot0, root0); err != nil {
-		return err
-	}
-	if err = udb.Set(BucketUser, common.Hash2String(users[0].ID()), root0); err != nil {
-		return err
-	}
-
 	if root1, err = json.Marshal(users[1]); err != nil {
 		return err
 	}
-
-	if err = udb.Set(BucketConfig, ConfigRoot1, root1); err != nil {
-		return err
-	}
-
-	if err = udb.Set(BucketUser, common.Hash2String(users[1].ID()), root1); err != nil {
-		return err
-	}
-
-	if err := udb.Set(BucketConfig, ConfigCurrentStep, big.NewInt(StepRootsSaved).Bytes()); err != nil {
-		return err
-	}
-	return nil
+	// save root users
+	return udb.Update(func(tx Tx) error {
+		if err := tx.Set(BucketConfig, ConfigRoot0, root0); err != nil {
+			return err
+		}
+		if err := tx.Set(BucketUser, common.Hash2String(users[0].ID()), root0); err != nil {
+			return err
+		}
+		if err := tx.Set(BucketConfig, ConfigRoot1, root1); err != nil {
+			return err
+		}
+		if err := tx.Set(BucketUser, common.Hash2String(users[1].ID()), root1); err != nil {
+			return err
+		}
+		return tx.Set(BucketConfig, ConfigCurrentStep, big.NewInt(StepRootsSaved).Bytes())
+	})
 }
 
 // GetRootUsers get two root users from db
@@ -83,45 +85,146 @@ func GetRootUsers(udb UDB) (*core.User, *core.User, error) {
 	return &user0, &user1, nil
 }
 
-// SaveMsg save new msg to db
-func SaveMsg(udb UDB, msg *core.Message) error {
-	msgBytes, err := json.Marshal(msg)
+// SaveUser save the user created by birth msg to db, the key is user.ID, so
+// the user can be found by prefix of ID. The parents and birth msg are contained
+// in user.BirthMsg.
+func SaveUser(udb UDB, user *core.User) error {
+	return udb.Update(func(tx Tx) error {
+		return saveUser(tx, user)
+	})
+}
+
+func saveUser(tx Tx, user *core.User) error {
+	userBytes, err := json.Marshal(user)
 	if err != nil {
 		return err
 	}
-	countBytes, err := udb.Get(BucketConfig, ConfigMsgCount)
+	return tx.Set(BucketUser, common.Hash2String(user.ID()), userBytes)
+}
+
+// GetUserByID get the user from db by userID
+func GetUserByID(udb UDB, userID common.Hash) (*core.User, error) {
+	userBytes, err := udb.Get(BucketUser, common.Hash2String(userID))
 	if err != nil {
-		return err
+		return nil, err
+	} else if userBytes == nil {
+		return nil, ErrUserNotFound
 	}
-	count := new(big.Int).SetBytes(countBytes)
-	err = udb.Set(BucketMsg, common.Hash2String(msg.ID()), msgBytes)
+	var user core.User
+	if err := json.Unmarshal(userBytes, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserByPrefix get the only user which ID start with prefix
+func GetUserByPrefix(udb UDB, prefix string) (*core.User, error) {
+	rows, err := udb.Find(BucketUser, strings.ToUpper(prefix), 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrUserNotFound
+	}
+	if len(rows) > 1 {
+		return nil, ErrUserPrefixNotUnique
+	}
+	var user core.User
+	if err := json.Unmarshal(rows[0].V, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SaveMsg save new msg to db, the msg, order, indexes, count and last msg of
+// sender are saved in one transaction
+func SaveMsg(udb UDB, msg *core.Message) error {
+	return udb.Update(func(tx Tx) error {
+		return saveMsg(tx, msg)
+	})
+}
+
+// SaveBirthMsg save the birth msg and the user created by it in one transaction
+func SaveBirthMsg(udb UDB, msg *core.Message, user *core.User) error {
+	return udb.Update(func(tx Tx) error {
+		if err := saveMsg(tx, msg); err != nil {
+			return err
+		}
+		return saveUser(tx, user)
+	})
+}
+
+func saveMsg(tx Tx, msg *core.Message) error {
+	codec, err := tx.Get(BucketConfig, ConfigMsgCodec)
 	if err != nil {
 		return err
 	}
-	err = udb.Set(BucketMID, count.String(), common.Hash2Bytes(msg.ID()))
+	msgBytes, err := marshalMsg(msg, codec)
 	if err != nil {
 		return err
 	}
-	err = udb.Set(BucketMOD, common.Hash2String(msg.ID()), count.Bytes())
+	countBytes, err := tx.Get(BucketConfig, ConfigMsgCount)
 	if err != nil {
 		return err
 	}
+	count := new(big.Int).SetBytes(countBytes)
+	if err := tx.Set(BucketMsg, common.Hash2String(msg.ID()), msgBytes); err != nil {
+		return err
+	}
+	if err := tx.Set(BucketMID, count.String(), common.Hash2Bytes(msg.ID())); err != nil {
+		return err
+	}
+	if err := tx.Set(BucketMOD, common.Hash2String(msg.ID()), count.Bytes()); err != nil {
+		return err
+	}
+	if err := indexMsg(tx, msg, count.Uint64()); err != nil {
+		return err
+	}
 	count = count.Add(count, big.NewInt(1))
-	err = udb.Set(BucketConfig, ConfigMsgCount, count.Bytes())
-	if err != nil {
+	if err := tx.Set(BucketConfig, ConfigMsgCount, count.Bytes()); err != nil {
 		return err
 	}
+	return tx.Set(BucketLastMID, common.Hash2String(msg.SenderID), common.Hash2Bytes(msg.ID()))
+}
 
-	err = udb.Set(BucketLastMID, common.Hash2String(msg.SenderID), common.Hash2Bytes(msg.ID()))
-	if err != nil {
+// marshalMsg marshal the msg by the codec saved in config
+func marshalMsg(msg *core.Message, codec []byte) ([]byte, error) {
+	if codec == nil {
+		return core.MarshalMsg(msg, core.CodecBinary)
+	}
+	return core.MarshalMsg(msg, string(codec))
+}
+
+// SetMsgCodec set the codec of msgs saved after, core.CodecJSON is readable for debugging
+func SetMsgCodec(udb UDB, codec string) error {
+	if _, err := core.MarshalMsg(&core.Message{}, codec); err != nil {
 		return err
 	}
-	return nil
+	return udb.Set(BucketConfig, ConfigMsgCodec, []byte(codec))
+}
+
+// HasMsg return true if the msg is saved in db
+func HasMsg(udb UDB, msgID common.Hash) (bool, error) {
+	orderBytes, err := udb.Get(BucketMOD, common.Hash2String(msgID))
+	if err != nil {
+		return false, err
+	}
+	return orderBytes != nil, nil
+}
+
+// GetMsgByID get the message from db by msg.ID
+func GetMsgByID(udb UDB, msgID common.Hash) (*core.Message, error) {
+	msgBytes, err := udb.Get(BucketMsg, common.Hash2String(msgID))
+	if err != nil {
+		return nil, err
+	} else if msgBytes == nil {
+		return nil, ErrMessageNotFound
+	}
+	return core.UnmarshalMsg(msgBytes)
 }
 
 // GetLastMsg get the last message by order from db
 func GetLastMsg(udb UDB) (*core.Message, error) {
-	var msg core.Message
 	countBytes, err := udb.Get(BucketConfig, ConfigMsgCount)
 	if err != nil {
 		return nil, err
@@ -139,11 +242,7 @@ func GetLastMsg(udb UDB) (*core.Message, error) {
 	} else if msgBytes == nil {
 		return nil, ErrMessageNotFound
 	}
-	err = json.Unmarshal(msgBytes, &msg)
-	if err != nil {
-		return nil, err
-	}
-	return &msg, nil
+	return core.UnmarshalMsg(msgBytes)
 }
 
 // GetMsgByOrder get the message by order, for sync message between peers
@@ -157,12 +256,11 @@ func GetMsgByOrder(udb UDB, start *big.Int, size int) (msgs []*core.Message) {
 		if err != nil || msgBytes == nil {
 			continue
 		}
-		var msg core.Message
-		err = json.Unmarshal(msgBytes, &msg)
+		msg, err := core.UnmarshalMsg(msgBytes)
 		if err != nil {
 			continue
 		}
-		msgs = append(msgs, &msg)
+		msgs = append(msgs, msg)
 		start = start.Add(start, big.NewInt(1))
 	}
 	return msgs
@@ -197,7 +295,6 @@ func GetMsgCount(udb UDB) (count *big.Int, err error) {
 
 // GetLastMsgByUser return the last message by userID
 func GetLastMsgByUser(udb UDB, userID common.Hash) (*core.Message, error) {
-	var msg core.Message
 	lastMsgBytes, err := udb.Get(BucketLastMID, common.Hash2String(userID))
 	if err != nil {
 		return nil, err
@@ -210,10 +307,5 @@ func GetLastMsgByUser(udb UDB, userID common.Hash) (*core.Message, error) {
 	} else if msgBytes == nil {
 		return nil, ErrMessageNotFound
 	}
-	err = json.Unmarshal(msgBytes, &msg)
-	if err != nil {
-		return nil, err
-	}
-
-	return &msg, nil
+	return core.UnmarshalMsg(msgBytes)
 }
@@ -0,0 +1,330 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package db_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/core/coretest"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/backend"
+)
+
+var errCrash = errors.New("crash")
+
+// crashDB interrupt the transaction after number of writes,
+// by error or panic, to simulate the crash during writing
+type crashDB struct {
+	db.UDB
+	writes  int
+	byPanic bool
+}
+
+type crashTx struct {
+	db.Tx
+	db *crashDB
+}
+
+func (c *crashDB) Update(fn func(db.Tx) error) error {
+	return c.UDB.Update(func(tx db.Tx) error {
+		return fn(&crashTx{Tx: tx, db: c})
+	})
+}
+
+func (t *crashTx) write() error {
+	if t.db.writes == 0 {
+		if t.db.byPanic {
+			panic(errCrash)
+		}
+		return errCrash
+	}
+	t.db.writes--
+	return nil
+}
+
+func (t *crashTx) Set(bucketName, key string, val []byte) error {
+	if err := t.write(); err != nil {
+		return err
+	}
+	return t.Tx.Set(bucketName, key, val)
+}
+
+func (t *crashTx) Del(bucketName, key string) error {
+	if err := t.write(); err != nil {
+		return err
+	}
+	return t.Tx.Del(bucketName, key)
+}
+
+// run the update on crashDB, recover if panic
+func runCrash(udb db.UDB, writes int, byPanic bool, fn func(db.UDB) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errCrash
+		}
+	}()
+	return fn(&crashDB{UDB: udb, writes: writes, byPanic: byPanic})
+}
+
+func openTestDB(t *testing.T, name, dir string) db.UDB {
+	udb, err := backend.Open(name, path.Join(dir, "u.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bucket := range db.Buckets {
+		if err := udb.CreateBucket(bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(0).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigCurrentStep, big.NewInt(db.StepInitDB).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return udb
+}
+
+func TestSaveMsg_Crash(t *testing.T) {
+	users, priKeys := coretest.RootUsers(t)
+	sender, priKey := users[1], priKeys[1]
+	var msgs []*core.Message
+	for i := 0; i < 2; i++ {
+		msg, err := core.CreateMsg(sender, &core.MsgValue{ContentType: core.TypeText, Content: []byte(fmt.Sprintf("msg%d", i))}, priKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	for _, name := range backend.Names() {
+		dir, err := ioutil.TempDir("", "pdu_db_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		udb := openTestDB(t, name, dir)
+		if err := db.SaveMsg(udb, msgs[0]); err != nil {
+			t.Fatal(err)
+		}
+		// SaveMsg write 7 keys for msg without reference, interrupt before each of them
+		for writes := 0; writes < 7; writes++ {
+			for _, byPanic := range []bool{false, true} {
+				err := runCrash(udb, writes, byPanic, func(udb db.UDB) error {
+					return db.SaveMsg(udb, msgs[1])
+				})
+				if err != errCrash {
+					t.Errorf("%s : save msg should be interrupted after %d writes, %v", name, writes, err)
+				}
+				checkMsgs(t, fmt.Sprintf("%s interrupted after %d writes", name, writes), udb, msgs[:1])
+			}
+		}
+		if err := runCrash(udb, 7, false, func(udb db.UDB) error {
+			return db.SaveMsg(udb, msgs[1])
+		}); err != nil {
+			t.Errorf("%s : save msg fail %v", name, err)
+		}
+		checkMsgs(t, name, udb, msgs)
+		udb.Close()
+	}
+}
+
+// checkMsgs check the msgs are the only msgs saved in db, in order
+func checkMsgs(t *testing.T, name string, udb db.UDB, msgs []*core.Message) {
+	if count, err := db.GetMsgCount(udb); err != nil || count.Int64() != int64(len(msgs)) {
+		t.Errorf("%s : msg count should be %d, but %v %v", name, len(msgs), count, err)
+	}
+	saved := db.GetMsgByOrder(udb, big.NewInt(0), len(msgs)+1)
+	if len(saved) != len(msgs) {
+		t.Errorf("%s : msg by order should be %d, but %d", name, len(msgs), len(saved))
+	}
+	for i, msg := range saved {
+		if i < len(msgs) && msg.ID() != msgs[i].ID() {
+			t.Errorf("%s : msg %d not match", name, i)
+		}
+	}
+	last := msgs[len(msgs)-1]
+	if msg, err := db.GetLastMsgByUser(udb, last.SenderID); err != nil || msg.ID() != last.ID() {
+		t.Errorf("%s : last msg of user not match %v", name, err)
+	}
+	if rows, err := udb.Find(db.BucketMsg, "", len(msgs)+1); err != nil || len(rows) != len(msgs) {
+		t.Errorf("%s : msg bucket should contain %d msgs, %v", name, len(msgs), err)
+	}
+	if rows, err := udb.Find(db.BucketMOD, "", len(msgs)+1); err != nil || len(rows) != len(msgs) {
+		t.Errorf("%s : mod bucket should contain %d msgs, %v", name, len(msgs), err)
+	}
+}
+
+func TestSaveBirthMsg_Crash(t *testing.T) {
+	users, priKeys := coretest.RootUsers(t)
+	universe, err := core.NewUniverse(users[0], users[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := utils.SelectEngine(crypto.PDU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := core.CreateContentBirth("child", "extra", &core.Auth{PublicKey: *pubKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, user := range users {
+		if err := content.SignByParent(user, *priKeys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	value := &core.MsgValue{ContentType: core.TypeBirth}
+	if value.Content, err = json.Marshal(content); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := core.CreateMsg(users[0], value, priKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := core.CreateNewUser(universe, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range backend.Names() {
+		dir, err := ioutil.TempDir("", "pdu_db_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		udb := openTestDB(t, name, dir)
+		// interrupt before each write until all writes done, neither msg nor user is saved
+		writes := 0
+		for done := false; !done; writes++ {
+			for _, byPanic := range []bool{false, true} {
+				err := runCrash(udb, writes, byPanic, func(udb db.UDB) error {
+					return db.SaveBirthMsg(udb, msg, child)
+				})
+				if err == nil {
+					done = true
+					break
+				} else if err != errCrash {
+					t.Fatalf("%s : save birth msg fail %v", name, err)
+				}
+				if _, err := db.GetMsgByID(udb, msg.ID()); err != db.ErrMessageNotFound {
+					t.Errorf("%s : msg should not be saved after %d writes, %v", name, writes, err)
+				}
+				if _, err := db.GetUserByID(udb, child.ID()); err != db.ErrUserNotFound {
+					t.Errorf("%s : user should not be saved after %d writes, %v", name, writes, err)
+				}
+			}
+		}
+		checkMsgs(t, name, udb, []*core.Message{msg})
+		if user, err := db.GetUserByID(udb, child.ID()); err != nil || user.ID() != child.ID() {
+			t.Errorf("%s : user not match %v", name, err)
+		}
+		udb.Close()
+	}
+}
+
+func TestSaveRootUsers_Crash(t *testing.T) {
+	users, _ := coretest.RootUsers(t)
+	for _, name := range backend.Names() {
+		dir, err := ioutil.TempDir("", "pdu_db_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		udb := openTestDB(t, name, dir)
+		// SaveRootUsers write 5 keys, interrupt before each of them
+		for writes := 0; writes < 5; writes++ {
+			for _, byPanic := range []bool{false, true} {
+				err := runCrash(udb, writes, byPanic, func(udb db.UDB) error {
+					return db.SaveRootUsers(udb, users)
+				})
+				if err != errCrash {
+					t.Errorf("%s : save root users should be interrupted after %d writes, %v", name, writes, err)
+				}
+				if step, err := udb.Get(db.BucketConfig, db.ConfigCurrentStep); err != nil || new(big.Int).SetBytes(step).Int64() != db.StepInitDB {
+					t.Errorf("%s : current step should not change, %v", name, err)
+				}
+				if rows, err := udb.Find(db.BucketUser, "", 3); err != nil || len(rows) != 0 {
+					t.Errorf("%s : no user should be saved, %v", name, err)
+				}
+				if root0, err := udb.Get(db.BucketConfig, db.ConfigRoot0); err != nil || root0 != nil {
+					t.Errorf("%s : root should not be saved, %v", name, err)
+				}
+			}
+		}
+		if err := db.SaveRootUsers(udb, users); err != nil {
+			t.Errorf("%s : save root users fail %v", name, err)
+		}
+		if user0, user1, err := db.GetRootUsers(udb); err != nil || user0.ID() != users[0].ID() || user1.ID() != users[1].ID() {
+			t.Errorf("%s : root users not match %v", name, err)
+		}
+		udb.Close()
+	}
+}
+
+func TestSetMsgCodec(t *testing.T) {
+	users, priKeys := coretest.RootUsers(t)
+	dir, err := ioutil.TempDir("", "pdu_db_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	udb := openTestDB(t, backend.Names()[0], dir)
+	defer udb.Close()
+
+	if err := db.SetMsgCodec(udb, "unknown"); err != core.ErrCodecNotSupport {
+		t.Errorf("err should be %s, but now err : %v", core.ErrCodecNotSupport, err)
+	}
+	// msgs saved by different codec can be read together
+	var msgs []*core.Message
+	for i, codec := range []string{core.CodecJSON, core.CodecBinary} {
+		if err := db.SetMsgCodec(udb, codec); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := core.CreateMsg(users[1], &core.MsgValue{ContentType: core.TypeText, Content: []byte(fmt.Sprintf("msg%d", i))}, priKeys[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveMsg(udb, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgBytes, err := udb.Get(db.BucketMsg, common.Hash2String(msg.ID()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (msgBytes[0] == '{') != (codec == core.CodecJSON) {
+			t.Errorf("msg should be saved by %s", codec)
+		}
+		msgs = append(msgs, msg)
+	}
+	checkMsgs(t, "codec", udb, msgs)
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package galaxy
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/encoding"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+)
+
+// binaryWaveMark is the first byte of wave body in core.CodecBinary,
+// the wave body in core.CodecJSON always start with '{'.
+const binaryWaveMark = 0x01
+
+var errWaveBodyNotValid = errors.New("wave body not valid")
+
+// marshalWave marshal the wave body by codec
+func marshalWave(wave Wave, codec string) ([]byte, error) {
+	switch codec {
+	case core.CodecJSON:
+		return json.Marshal(wave)
+	case core.CodecBinary:
+	default:
+		return nil, core.ErrCodecNotSupport
+	}
+	w := &encoding.Writer{}
+	w.PutRaw(binaryWaveMark)
+	switch wave := wave.(type) {
+	case *WaveQuestion:
+		w.PutHash(wave.WaveID)
+		w.PutString(wave.Cmd)
+		w.PutBytesList(wave.Args)
+	case *WaveVersion:
+		w.PutHash(wave.WaveID)
+		w.PutString(wave.Version)
+		w.PutUint64(wave.Protocol)
+		w.PutUint64(wave.MinProtocol)
+		w.PutHashList(wave.Roots[:])
+		w.PutUint64(uint64(len(wave.Capabilities)))
+		for _, c := range wave.Capabilities {
+			w.PutString(c)
+		}
+	case *WaveRoots:
+		w.PutHash(wave.WaveID)
+		for _, user := range wave.Users {
+			var userBytes []byte
+			if user != nil {
+				var err error
+				if userBytes, err = core.MarshalUser(user, core.CodecBinary); err != nil {
+					return nil, err
+				}
+			}
+			w.PutBytes(userBytes)
+		}
+	case *WaveMessages:
+		w.PutHash(wave.WaveID)
+		w.PutBytesList(wave.Msgs)
+	case *WavePing:
+		w.PutHash(wave.WaveID)
+	case *WavePong:
+		w.PutHash(wave.WaveID)
+	case *WaveUser:
+		w.PutHash(wave.WaveID)
+		w.PutHash(wave.UserID)
+		if w.PutOptional(wave.Signature != nil) {
+			w.PutString(wave.Signature.Source)
+			w.PutString(wave.Signature.SigType)
+			w.PutBytes(wave.Signature.Signature)
+		}
+	case *WavePeers:
+		w.PutHash(wave.WaveID)
+		w.PutBytesList(wave.Peers)
+	case *WaveTips:
+		w.PutHash(wave.WaveID)
+		w.PutHashList(wave.MsgIDs)
+	case *WaveErr:
+		w.PutHash(wave.WaveID)
+		w.PutString(wave.Err)
+	default:
+		return nil, core.ErrCodecNotSupport
+	}
+	return w.Bytes(), nil
+}
+
+// unmarshalWave unmarshal the wave body into wave, the codec is decided by the first byte
+func unmarshalWave(body []byte, wave Wave) error {
+	if len(body) > 0 && body[0] == '{' {
+		return json.Unmarshal(body, wave)
+	}
+	if len(body) == 0 || body[0] != binaryWaveMark {
+		return errWaveBodyNotValid
+	}
+	r := encoding.NewReader(body[1:])
+	switch wave := wave.(type) {
+	case *WaveQuestion:
+		wave.WaveID = r.GetHash()
+		wave.Cmd = r.GetString()
+		wave.Args = r.GetBytesList()
+	case *WaveVersion:
+		wave.WaveID = r.GetHash()
+		wave.Version = r.GetString()
+		wave.Protocol = r.GetUint64()
+		wave.MinProtocol = r.GetUint64()
+		if roots := r.GetHashList(); len(roots) == len(wave.Roots) {
+			wave.Roots = [2]common.Hash{roots[0], roots[1]}
+		} else {
+			r.Fail(errWaveBodyNotValid)
+		}
+		for n := r.GetUint64(); n > 0 && r.Err() == nil; n-- {
+			wave.Capabilities = append(wave.Capabilities, r.GetString())
+		}
+	case *WaveRoots:
+		wave.WaveID = r.GetHash()
+		for i := range wave.Users {
+			if userBytes := r.GetBytes(); userBytes != nil {
+				user, err := core.UnmarshalUser(userBytes)
+				if err != nil {
+					return err
+				}
+				wave.Users[i] = user
+			}
+		}
+	case *WaveMessages:
+		wave.WaveID = r.GetHash()
+		wave.Msgs = r.GetBytesList()
+	case *WavePing:
+		wave.WaveID = r.GetHash()
+	case *WavePong:
+		wave.WaveID = r.GetHash()
+	case *WaveUser:
+		wave.WaveID = r.GetHash()
+		wave.UserID = r.GetHash()
+		if r.GetOptional() {
+			wave.Signature = &crypto.Signature{PublicKey: crypto.PublicKey{Source: r.GetString(), SigType: r.GetString()}}
+			wave.Signature.Signature = r.GetBytes()
+		}
+	case *WavePeers:
+		wave.WaveID = r.GetHash()
+		wave.Peers = r.GetBytesList()
+	case *WaveTips:
+		wave.WaveID = r.GetHash()
+		wave.MsgIDs = r.GetHashList()
+	case *WaveErr:
+		wave.WaveID = r.GetHash()
+		wave.Err = r.GetString()
+	default:
+		return core.ErrCodecNotSupport
+	}
+	return r.Finish()
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package galaxy
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/pdu"
+)
+
+func TestCodec_Binary(t *testing.T) {
+	engine := pdu.New()
+	_, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user0 := core.CreateRootUser(*pubKey, "user0", "extra0")
+	user1 := core.CreateRootUser(*pubKey, "user1", "extra1")
+	signature := &crypto.Signature{PublicKey: crypto.PublicKey{Source: crypto.PDU, SigType: crypto.Signature2PublicKey}, Signature: []byte("signature")}
+
+	waves := []Wave{
+		&WaveQuestion{WaveID: common.CreateHash(), Cmd: CmdMessages, Args: [][]byte{[]byte("arg0"), []byte("arg1")}},
+		&WaveVersion{WaveID: common.CreateHash(), Version: "v1", Protocol: 2, MinProtocol: 2, Roots: [2]common.Hash{common.CreateHash(), common.CreateHash()}, Capabilities: DefaultCapabilities},
+		&WaveMessages{WaveID: common.CreateHash(), Msgs: [][]byte{[]byte("msg0"), []byte("msg1")}},
+		&WavePing{WaveID: common.CreateHash()},
+		&WavePong{WaveID: common.CreateHash()},
+		&WaveUser{WaveID: common.CreateHash(), UserID: common.CreateHash(), Signature: signature},
+		&WaveUser{WaveID: common.CreateHash(), UserID: common.CreateHash()},
+		&WavePeers{WaveID: common.CreateHash(), Peers: [][]byte{[]byte("peer0")}},
+		&WaveTips{WaveID: common.CreateHash(), MsgIDs: []common.Hash{common.CreateHash()}},
+		&WaveErr{WaveID: common.CreateHash(), Err: "error content"},
+	}
+	for _, wave := range waves {
+		var buf bytes.Buffer
+		if _, err := SendWave(&buf, testMagic, wave, core.CodecBinary); err != nil {
+			t.Fatal("send wave fail", err)
+		}
+		w, err := ReceiveWave(&buf, testMagic)
+		if err != nil {
+			t.Fatal("receive wave fail", wave.Command(), err)
+		}
+		if !reflect.DeepEqual(w, wave) {
+			t.Errorf("%s : wave not match, %v", wave.Command(), w)
+		}
+	}
+
+	// users of roots are compared by id
+	for _, users := range [][2]*core.User{{user0, user1}, {user0, nil}} {
+		var buf bytes.Buffer
+		if _, err := SendWave(&buf, testMagic, &WaveRoots{WaveID: common.CreateHash(), Users: users}, core.CodecBinary); err != nil {
+			t.Fatal("send wave fail", err)
+		}
+		w, err := ReceiveWave(&buf, testMagic)
+		if err != nil {
+			t.Fatal("receive wave fail", err)
+		}
+		for i, user := range w.(*WaveRoots).Users {
+			if (user == nil) != (users[i] == nil) || (user != nil && user.ID() != users[i].ID()) {
+				t.Errorf("root user %d not match", i)
+			}
+		}
+	}
+
+	if err := unmarshalWave([]byte{binaryWaveMark, 0x01}, &WavePing{}); err == nil {
+		t.Error("wave body should not be valid")
+	}
+	if _, err := marshalWave(&WavePing{}, "unknown"); err != core.ErrCodecNotSupport {
+		t.Errorf("err should be %s, but now err : %v", core.ErrCodecNotSupport, err)
+	}
+}
+
+func BenchmarkCodec_WaveMessages(b *testing.B) {
+	msgs := make([][]byte, 16)
+	for i := range msgs {
+		msgs[i] = []byte(fmt.Sprintf("%0256d", i))
+	}
+	wave := &WaveMessages{WaveID: common.CreateHash(), Msgs: msgs}
+	for _, codec := range []string{core.CodecJSON, core.CodecBinary} {
+		b.Run(codec, func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				body, err := marshalWave(wave, codec)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := unmarshalWave(body, &WaveMessages{}); err != nil {
+					b.Fatal(err)
+				}
+				size = len(body)
+			}
+			b.ReportMetric(float64(size), "bytes/wave")
+		})
+	}
+}
@@ -18,10 +18,13 @@ package galaxy
 
 import (
 	"bytes"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+
+	"github.com/pdupub/go-pdu/common"
 )
 
 // WaveSize is the number of bytes in a wave
@@ -29,12 +32,18 @@ import (
 const WaveSize = 1048 * 64
 
 // WaveHeaderSize is the number of bytes in a wave header
-// command 12 bytes + length 4 bytes + checksum 4 bytes
+// magic 4 bytes + command 12 bytes + length 4 bytes + checksum 4 bytes
 const WaveHeaderSize = 24
 
 // CommandSize is the fixed size of all commands
 const CommandSize = 12
 
+// MagicSize is the fixed size of network magic
+const MagicSize = 4
+
+// ChecksumSize is the fixed size of body checksum
+const ChecksumSize = 4
+
 // Commands used in wave which describe the type of wave.
 const (
 	CmdQuestion = "question"
@@ -45,19 +54,46 @@ const (
 	CmdPong     = "pong"
 	CmdUser     = "user"
 	CmdPeers    = "peers"
+	CmdTips     = "tips"
 	CmdErr      = "error"
 )
 
+// CmdAncestors is only used in question, which ask the messages with their
+// ancestors, the answer is the wave of CmdMessages.
+const CmdAncestors = "ancestors"
+
 var (
+	// ErrMagicNotMatch returns when the magic of wave is not the magic of local universe
+	ErrMagicNotMatch = errors.New("wave magic not match")
+
+	// ErrChecksumNotMatch returns when the checksum of wave body is not correct
+	ErrChecksumNotMatch = errors.New("wave checksum not match")
+
 	errWaveLengthTooLong = errors.New("wave length too long")
-	errWaveHeaderMissing = errors.New("wave header missing")
 )
 
+// EmptyMagic is used by the node which universe is not exist yet,
+// the wave with empty magic can not contain messages.
+var EmptyMagic [MagicSize]byte
+
 // Wave is an interface that describes a galaxy information.
 type Wave interface {
 	Command() string
 }
 
+// CreateMagic create the network magic from the root users of universe
+func CreateMagic(root0, root1 common.Hash) (magic [MagicSize]byte) {
+	hash := sha256.Sum256(append(common.Hash2Bytes(root0), common.Hash2Bytes(root1)...))
+	copy(magic[:], hash[:MagicSize])
+	return magic
+}
+
+func checksum(body []byte) (sum [ChecksumSize]byte) {
+	hash := sha256.Sum256(body)
+	copy(sum[:], hash[:ChecksumSize])
+	return sum
+}
+
 func makeEmptyWave(command string) (Wave, error) {
 	var wave Wave
 	switch command {
@@ -77,6 +113,8 @@ func makeEmptyWave(command string) (Wave, error) {
 		wave = &WaveUser{}
 	case CmdPeers:
 		wave = &WavePeers{}
+	case CmdTips:
+		wave = &WaveTips{}
 	case CmdErr:
 		wave = &WaveErr{}
 	default:
@@ -85,56 +123,76 @@ func makeEmptyWave(command string) (Wave, error) {
 	return wave, nil
 }
 
-// SendWave send a wave message to w
-func SendWave(w io.Writer, wave Wave) (int, error) {
-	var magic, waveLen, checkSum [4]byte
+// SendWave send a wave message to w, the header contain the magic of
+// universe, the length and checksum of wave body, which is marshaled by codec.
+func SendWave(w io.Writer, magic [MagicSize]byte, wave Wave, codec string) (int, error) {
+	var waveLen [4]byte
 	var command [CommandSize]byte
 	cmd := wave.Command()
 	if len(cmd) > CommandSize {
 		return 0, fmt.Errorf("command [%s] is too long [max %v]", wave, CommandSize)
 	}
 	copy(command[:], []byte(cmd))
-	copy(magic[:], []byte(""))
-	copy(waveLen[:], []byte(""))
-	copy(checkSum[:], []byte(""))
 
-	waveHeader := bytes.NewBuffer(make([]byte, 0, WaveHeaderSize))
-	waveHeader.Write((magic[:]))
-	waveHeader.Write(command[:])
-	waveHeader.Write(waveLen[:])
-	waveHeader.Write(checkSum[:])
-	waveBody, err := json.Marshal(wave)
+	waveBody, err := marshalWave(wave, codec)
 	if err != nil {
 		return 0, err
 	}
-	waveBytes := append(waveHeader.Bytes(), waveBody...)
-	if len(waveBytes) > WaveSize {
+	if len(waveBody)+WaveHeaderSize > WaveSize {
 		return 0, errWaveLengthTooLong
 	}
+	binary.LittleEndian.PutUint32(waveLen[:], uint32(len(waveBody)))
+	checkSum := checksum(waveBody)
+
+	waveHeader := bytes.NewBuffer(make([]byte, 0, WaveHeaderSize))
+	waveHeader.Write(magic[:])
+	waveHeader.Write(command[:])
+	waveHeader.Write(waveLen[:])
+	waveHeader.Write(checkSum[:])
+	waveBytes := append(waveHeader.Bytes(), waveBody...)
 	return w.Write(waveBytes)
 }
 
-// ReceiveWave receive a wave message from r
-func ReceiveWave(r io.Reader) (Wave, error) {
-	waveBytes := make([]byte, WaveSize)
-	n, err := r.Read(waveBytes)
-	if err != nil {
+// ReceiveWave receive a wave message from r, the wave may be split or
+// coalesced by the transport, so the header and body are read in full.
+// The wave body can be marshaled by any codec.
+func ReceiveWave(r io.Reader, magic [MagicSize]byte) (Wave, error) {
+	waveHeader := make([]byte, WaveHeaderSize)
+	if _, err := io.ReadFull(r, waveHeader); err != nil {
 		return nil, err
 	}
-	if n <= WaveHeaderSize {
-		return nil, errWaveHeaderMissing
+	var waveMagic [MagicSize]byte
+	copy(waveMagic[:], waveHeader[:MagicSize])
+	// Strip trailing zeros from command string.
+	command := string(bytes.TrimRight(waveHeader[MagicSize:CommandSize+MagicSize], "\x00"))
+	waveLen := binary.LittleEndian.Uint32(waveHeader[CommandSize+MagicSize : CommandSize+MagicSize+4])
+	if int(waveLen)+WaveHeaderSize > WaveSize {
+		return nil, errWaveLengthTooLong
+	}
+	waveBody := make([]byte, waveLen)
+	if _, err := io.ReadFull(r, waveBody); err != nil {
+		return nil, err
+	}
+
+	if waveMagic != magic && waveMagic != EmptyMagic && magic != EmptyMagic {
+		return nil, ErrMagicNotMatch
+	}
+	// messages must belong to the same universe
+	if waveMagic != magic && command == CmdMessages {
+		return nil, ErrMagicNotMatch
+	}
+	var checkSum [ChecksumSize]byte
+	copy(checkSum[:], waveHeader[WaveHeaderSize-ChecksumSize:])
+	if checksum(waveBody) != checkSum {
+		return nil, ErrChecksumNotMatch
 	}
-	waveHeader := waveBytes[:WaveHeaderSize]
-	waveBody := waveBytes[WaveHeaderSize:n]
 
-	// Strip trailing zeros from command string.
-	command := string(bytes.TrimRight(waveHeader[4:CommandSize+4], string(0)))
 	msg, err := makeEmptyWave(command)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := json.Unmarshal(waveBody, msg); err != nil {
+	if err := unmarshalWave(waveBody, msg); err != nil {
 		return nil, err
 	}
 	return msg, nil
@@ -15,3 +15,79 @@
 // along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
 
 package galaxy
+
+import (
+	"bytes"
+	"testing"
+	"testing/iotest"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+var (
+	testMagic  = CreateMagic(common.Bytes2Hash([]byte("root0")), common.Bytes2Hash([]byte("root1")))
+	testMagic2 = CreateMagic(common.Bytes2Hash([]byte("root1")), common.Bytes2Hash([]byte("root0")))
+)
+
+func TestWave_SplitAndCoalesced(t *testing.T) {
+	var buf bytes.Buffer
+	waveIDs := []common.Hash{common.CreateHash(), common.CreateHash(), common.CreateHash()}
+	// coalesced, all waves in one buffer
+	for _, waveID := range waveIDs {
+		if _, err := SendWave(&buf, testMagic, &WaveQuestion{WaveID: waveID, Cmd: CmdMessages, Args: [][]byte{waveID[:]}}, core.CodecJSON); err != nil {
+			t.Error("send wave fail", err)
+		}
+	}
+	// split, read one byte each time
+	r := iotest.OneByteReader(&buf)
+	for _, waveID := range waveIDs {
+		w, err := ReceiveWave(r, testMagic)
+		if err != nil {
+			t.Fatal("receive wave fail", err)
+		}
+		wq, ok := w.(*WaveQuestion)
+		if !ok {
+			t.Fatal("wave type not match")
+		}
+		if wq.WaveID != waveID || wq.Cmd != CmdMessages || !bytes.Equal(wq.Args[0], waveID[:]) {
+			t.Error("wave content not match")
+		}
+	}
+}
+
+func TestWave_MagicNotMatch(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := SendWave(&buf, testMagic, &WavePing{WaveID: common.CreateHash()}, core.CodecJSON); err != nil {
+		t.Error("send wave fail", err)
+	}
+	if _, err := ReceiveWave(&buf, testMagic2); err != ErrMagicNotMatch {
+		t.Errorf("err should be %s, but now err : %v", ErrMagicNotMatch, err)
+	}
+
+	// empty magic can be used before the universe is known, except messages
+	if _, err := SendWave(&buf, EmptyMagic, &WavePing{WaveID: common.CreateHash()}, core.CodecJSON); err != nil {
+		t.Error("send wave fail", err)
+	}
+	if _, err := ReceiveWave(&buf, testMagic); err != nil {
+		t.Error("receive wave fail", err)
+	}
+	if _, err := SendWave(&buf, EmptyMagic, &WaveMessages{WaveID: common.CreateHash()}, core.CodecJSON); err != nil {
+		t.Error("send wave fail", err)
+	}
+	if _, err := ReceiveWave(&buf, testMagic); err != ErrMagicNotMatch {
+		t.Errorf("err should be %s, but now err : %v", ErrMagicNotMatch, err)
+	}
+}
+
+func TestWave_ChecksumNotMatch(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := SendWave(&buf, testMagic, &WaveErr{WaveID: common.CreateHash(), Err: "error content"}, core.CodecJSON); err != nil {
+		t.Error("send wave fail", err)
+	}
+	waveBytes := buf.Bytes()
+	waveBytes[len(waveBytes)-3] ^= 0xff
+	if _, err := ReceiveWave(bytes.NewReader(waveBytes), testMagic); err != ErrChecksumNotMatch {
+		t.Errorf("err should be %s, but now err : %v", ErrChecksumNotMatch, err)
+	}
+}
@@ -16,11 +16,18 @@
 
 package galaxy
 
-import "github.com/pdupub/go-pdu/common"
+import (
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/crypto"
+)
 
 // WaveUser implements the Wave interface and represents a checkUser message.
+// It is the response of user question, the nonce in question is signed by
+// the key of user, the public key is not included in signature.
 type WaveUser struct {
-	WaveID common.Hash `json:"waveID"`
+	WaveID    common.Hash       `json:"waveID"`
+	UserID    common.Hash       `json:"userID"`
+	Signature *crypto.Signature `json:"signature"`
 }
 
 // Command returns the protocol command string for the wave.
@@ -16,14 +16,85 @@
 
 package galaxy
 
-import "github.com/pdupub/go-pdu/common"
+import (
+	"errors"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+const (
+	// ProtocolVersion is the version of galaxy protocol used by local node,
+	// version 2 sync messages by the tips of message dag and msg.ID instead of order
+	ProtocolVersion = 2
+
+	// MinProtocolVersion is the min version of galaxy protocol local node can work with
+	MinProtocolVersion = 2
+)
+
+// Capabilities of node, only the capabilities supported by both side can be used.
+const (
+	// CapRoots is capability to answer the question of roots
+	CapRoots = "roots"
+
+	// CapPeers is capability to exchange peers
+	CapPeers = "peers"
+
+	// CapMessages is capability to sync messages by tips and msg.ID
+	CapMessages = "messages"
+
+	// CapAncestors is capability to answer the messages with their ancestors,
+	// so the deep missing messages are synced in batch
+	CapAncestors = "ancestors"
+
+	// CapBinary is capability to receive waves and messages in core.CodecBinary,
+	// the version wave is always in core.CodecJSON
+	CapBinary = "binary"
+)
+
+var (
+	// ErrProtocolNotCompatible returns when the protocol version of peer not compatible
+	ErrProtocolNotCompatible = errors.New("protocol version not compatible")
+
+	// ErrUniverseNotMatch returns when the roots of peer is not same with local
+	ErrUniverseNotMatch = errors.New("universe not match")
+)
+
+// DefaultCapabilities is the capabilities supported by local node
+var DefaultCapabilities = []string{CapRoots, CapPeers, CapMessages, CapAncestors, CapBinary}
 
 // WaveVersion implements the Wave interface and represents a galaxy protocol version message.
+// It is the first wave on each connection, the roots is empty if the universe of node is not exist yet.
 type WaveVersion struct {
-	WaveID common.Hash `json:"waveID"`
+	WaveID       common.Hash    `json:"waveID"`
+	Version      string         `json:"version"`
+	Protocol     uint64         `json:"protocol"`
+	MinProtocol  uint64         `json:"minProtocol"`
+	Roots        [2]common.Hash `json:"roots"`
+	Capabilities []string       `json:"capabilities"`
 }
 
 // Command returns the protocol command string for the wave.
 func (w *WaveVersion) Command() string {
 	return CmdVersion
 }
+
+// Negotiate check if the remote version is compatible with local version,
+// return the capabilities supported by both side.
+func (w *WaveVersion) Negotiate(remote *WaveVersion) ([]string, error) {
+	if w.Protocol < remote.MinProtocol || remote.Protocol < w.MinProtocol {
+		return nil, ErrProtocolNotCompatible
+	}
+	if w.Roots != [2]common.Hash{} && remote.Roots != [2]common.Hash{} && w.Roots != remote.Roots {
+		return nil, ErrUniverseNotMatch
+	}
+	var capabilities []string
+	for _, c := range w.Capabilities {
+		for _, rc := range remote.Capabilities {
+			if c == rc {
+				capabilities = append(capabilities, c)
+				break
+			}
+		}
+	}
+	return capabilities, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package galaxy
+
+import (
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+func TestWaveVersion_Negotiate(t *testing.T) {
+	roots := [2]common.Hash{common.CreateHash(), common.CreateHash()}
+	local := &WaveVersion{Protocol: 2, MinProtocol: 1, Roots: roots, Capabilities: DefaultCapabilities}
+
+	remote := &WaveVersion{Protocol: 1, MinProtocol: 1, Roots: roots, Capabilities: []string{CapMessages, "unknown", CapRoots}}
+	capabilities, err := local.Negotiate(remote)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(capabilities) != 2 || capabilities[0] != CapRoots || capabilities[1] != CapMessages {
+		t.Error("capabilities not match", capabilities)
+	}
+
+	// remote universe not exist yet
+	remote = &WaveVersion{Protocol: 1, MinProtocol: 1, Capabilities: DefaultCapabilities}
+	if _, err := local.Negotiate(remote); err != nil {
+		t.Error(err)
+	}
+
+	remote = &WaveVersion{Protocol: 1, MinProtocol: 1, Roots: [2]common.Hash{roots[1], roots[0]}}
+	if _, err := local.Negotiate(remote); err != ErrUniverseNotMatch {
+		t.Errorf("error should be %s, but get %s", ErrUniverseNotMatch, err)
+	}
+
+	remote = &WaveVersion{Protocol: 4, MinProtocol: 3, Roots: roots}
+	if _, err := local.Negotiate(remote); err != ErrProtocolNotCompatible {
+		t.Errorf("error should be %s, but get %s", ErrProtocolNotCompatible, err)
+	}
+
+	local = &WaveVersion{Protocol: 5, MinProtocol: 5, Roots: roots}
+	if _, err := local.Negotiate(remote); err != ErrProtocolNotCompatible {
+		t.Errorf("error should be %s, but get %s", ErrProtocolNotCompatible, err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+// absentMark is the peers which be asked but not respond the msg
+type absentMark struct {
+	id     common.Hash
+	peers  map[common.Hash]bool
+	marked time.Time
+}
+
+// absentSet keep the msg IDs which peers can not respond. The msg is absent
+// only if it is missed by at least minPeers peers, the marks are forgotten
+// after ttl, and the oldest mark is removed if the set is full.
+type absentSet struct {
+	maxSize  int
+	minPeers int
+	ttl      time.Duration
+	marks    map[common.Hash]*list.Element
+	order    *list.List
+}
+
+func newAbsentSet(maxSize, minPeers int, ttl time.Duration) *absentSet {
+	return &absentSet{
+		maxSize:  maxSize,
+		minPeers: minPeers,
+		ttl:      ttl,
+		marks:    make(map[common.Hash]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// mark record the msg missed by peer, return true if the msg become absent
+func (as *absentSet) mark(msgID, pid common.Hash, now time.Time) bool {
+	as.expire(now)
+	var m *absentMark
+	if e, ok := as.marks[msgID]; ok {
+		m = e.Value.(*absentMark)
+		as.order.MoveToBack(e)
+	} else {
+		if len(as.marks) >= as.maxSize {
+			as.remove(as.order.Front().Value.(*absentMark).id)
+		}
+		m = &absentMark{id: msgID, peers: make(map[common.Hash]bool)}
+		as.marks[msgID] = as.order.PushBack(m)
+	}
+	m.marked = now
+	wasAbsent := len(m.peers) >= as.minPeers
+	m.peers[pid] = true
+	return !wasAbsent && len(m.peers) >= as.minPeers
+}
+
+// absent return true if the msg is missed by enough peers and not expired
+func (as *absentSet) absent(msgID common.Hash, now time.Time) bool {
+	e, ok := as.marks[msgID]
+	if !ok {
+		return false
+	}
+	m := e.Value.(*absentMark)
+	return len(m.peers) >= as.minPeers && now.Sub(m.marked) <= as.ttl
+}
+
+// remove the mark of msg
+func (as *absentSet) remove(msgID common.Hash) {
+	if e, ok := as.marks[msgID]; ok {
+		as.order.Remove(e)
+		delete(as.marks, msgID)
+	}
+}
+
+// expire remove the marks older than ttl, return the number removed
+func (as *absentSet) expire(now time.Time) int {
+	cnt := 0
+	for e := as.order.Front(); e != nil; e = as.order.Front() {
+		m := e.Value.(*absentMark)
+		if now.Sub(m.marked) <= as.ttl {
+			break
+		}
+		as.remove(m.id)
+		cnt++
+	}
+	return cnt
+}
+
+// len return the number of marks in set
+func (as *absentSet) len() int {
+	return len(as.marks)
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+)
+
+func TestAbsentSet(t *testing.T) {
+	ttl := time.Minute
+	as := newAbsentSet(2, 2, ttl)
+	now := time.Now()
+	msgA, msgB, msgC := common.CreateHash(), common.CreateHash(), common.CreateHash()
+	peerA, peerB := common.CreateHash(), common.CreateHash()
+
+	if as.mark(msgA, peerA, now) || as.absent(msgA, now) {
+		t.Error("msg missed by one peer should not be absent")
+	}
+	if as.mark(msgA, peerA, now) || as.absent(msgA, now) {
+		t.Error("msg missed by same peer twice should not be absent")
+	}
+	if !as.mark(msgA, peerB, now) || !as.absent(msgA, now) {
+		t.Error("msg missed by two peers should be absent")
+	}
+	if as.mark(msgA, peerB, now) {
+		t.Error("msg already absent should not be returned again")
+	}
+	if as.absent(msgA, now.Add(2*ttl)) {
+		t.Error("absent mark should expire after ttl")
+	}
+
+	// the oldest mark is removed when set is full
+	as.mark(msgB, peerA, now)
+	as.mark(msgC, peerA, now)
+	if as.len() != 2 {
+		t.Errorf("size of set should be %d, but %d", 2, as.len())
+	}
+	if _, ok := as.marks[msgA]; ok {
+		t.Error("oldest mark should be removed")
+	}
+
+	as.remove(msgB)
+	if as.len() != 1 {
+		t.Errorf("size of set should be %d, but %d", 1, as.len())
+	}
+	if cnt := as.expire(now.Add(2 * ttl)); cnt != 1 || as.len() != 0 {
+		t.Errorf("expired should be %d, but %d, left %d", 1, cnt, as.len())
+	}
+}
@@ -0,0 +1,537 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/params"
+)
+
+// Methods of local node api, request by json-rpc 2.0 on /node,
+// all of IDs in params and results are hex string.
+const (
+	MethodNodeInfo      = "node_info"
+	MethodPeers         = "node_peers"
+	MethodRoots         = "universe_roots"
+	MethodUser          = "universe_user"
+	MethodUserInfo      = "universe_userInfo"
+	MethodSpaceTimes    = "universe_spaceTimes"
+	MethodMaxSeq        = "universe_maxSeq"
+	MethodCheckBirth    = "universe_checkBirth"
+	MethodMsgByID       = "msg_byID"
+	MethodMsgByOrder    = "msg_byOrder"
+	MethodLastMsgByUser = "msg_lastByUser"
+	MethodMsgBySender   = "msg_bySender"
+	MethodMsgByType     = "msg_byType"
+	MethodChildMsgs     = "msg_children"
+	MethodSearch        = "msg_search"
+	MethodSendMsg       = "msg_send"
+)
+
+// Error codes of json-rpc 2.0
+const (
+	ErrCodeParse          = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternal       = -32603
+)
+
+const (
+	// JSONRPCVersion is the version of json-rpc used by local node api
+	JSONRPCVersion = "2.0"
+
+	// MaxMsgCountPerRequest is the max number of msg return by one request
+	MaxMsgCountPerRequest = 100
+)
+
+// RPCRequest is the request of local node api
+type RPCRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// RPCResponse is the response of local node api
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is the error of local node api
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error return the message of error
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// NodeInfo is the basic information of local node
+type NodeInfo struct {
+	Version   string      `json:"version"`
+	Protocol  uint64      `json:"protocol"`
+	NodeKey   string      `json:"nodeKey"`
+	Port      uint64      `json:"port"`
+	UserID    string      `json:"userID"`
+	TPEnable  bool        `json:"tpEnable"`
+	Search    bool        `json:"search"`
+	Roots     []string    `json:"roots"`
+	MsgCount  uint64      `json:"msgCount"`
+	PeerCount int         `json:"peerCount"`
+	Orphans   OrphanStats `json:"orphans"`
+}
+
+// PeerInfo is the information of peer of local node
+type PeerInfo struct {
+	ID         string `json:"id"`
+	Address    string `json:"address"`
+	URL        string `json:"url"`
+	UserID     string `json:"userID"`
+	Connected  bool   `json:"connected"`
+	Handshaked bool   `json:"handshaked"`
+	Verified   bool   `json:"verified"`
+	Version    string `json:"version"`
+}
+
+// SpaceTimeInfo is the information of space time in universe
+type SpaceTimeInfo struct {
+	ID     string `json:"id"`
+	MaxSeq uint64 `json:"maxSeq"`
+}
+
+type apiFunc func(n *Node, args []json.RawMessage) (interface{}, error)
+
+var apiMethods = map[string]apiFunc{
+	MethodNodeInfo:      (*Node).apiNodeInfo,
+	MethodPeers:         (*Node).apiPeers,
+	MethodRoots:         (*Node).apiRoots,
+	MethodUser:          (*Node).apiUser,
+	MethodUserInfo:      (*Node).apiUserInfo,
+	MethodSpaceTimes:    (*Node).apiSpaceTimes,
+	MethodMaxSeq:        (*Node).apiMaxSeq,
+	MethodCheckBirth:    (*Node).apiCheckBirth,
+	MethodMsgByID:       (*Node).apiMsgByID,
+	MethodMsgByOrder:    (*Node).apiMsgByOrder,
+	MethodLastMsgByUser: (*Node).apiLastMsgByUser,
+	MethodMsgBySender:   (*Node).apiMsgBySender,
+	MethodMsgByType:     (*Node).apiMsgByType,
+	MethodChildMsgs:     (*Node).apiChildMsgs,
+	MethodSearch:        (*Node).apiSearch,
+	MethodSendMsg:       (*Node).apiSendMsg,
+}
+
+// nodeHandler serve the local node api, GET return the node info,
+// POST is the json-rpc request.
+func (n *Node) nodeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case "GET":
+		info, _ := n.callAPI((*Node).apiNodeInfo, nil)
+		json.NewEncoder(w).Encode(info)
+	case "POST":
+		json.NewEncoder(w).Encode(n.serveRPC(r))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (n *Node) serveRPC(r *http.Request) *RPCResponse {
+	res := &RPCResponse{JSONRPC: JSONRPCVersion}
+	var req RPCRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		res.Error = &RPCError{Code: ErrCodeParse, Message: err.Error()}
+		return res
+	}
+	res.ID = req.ID
+	if req.JSONRPC != JSONRPCVersion {
+		res.Error = &RPCError{Code: ErrCodeInvalidRequest, Message: "jsonrpc version not support"}
+		return res
+	}
+	method, ok := apiMethods[req.Method]
+	if !ok {
+		res.Error = &RPCError{Code: ErrCodeMethodNotFound, Message: fmt.Sprintf("method [%s] not found", req.Method)}
+		return res
+	}
+	result, err := n.callAPI(method, req.Params)
+	if err == nil {
+		res.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		if rpcErr, ok := err.(*RPCError); ok {
+			res.Error = rpcErr
+		} else {
+			res.Error = &RPCError{Code: ErrCodeInternal, Message: err.Error()}
+		}
+	}
+	return res
+}
+
+// callAPI call the method with the node locked, the lock is released even if method panic
+func (n *Node) callAPI(method apiFunc, args []json.RawMessage) (interface{}, error) {
+	n.mu.Lock()
+	defer n.unlock()
+	return method(n, args)
+}
+
+func invalidParams(err error) *RPCError {
+	return &RPCError{Code: ErrCodeInvalidParams, Message: err.Error()}
+}
+
+func paramHash(args []json.RawMessage, i int) (common.Hash, error) {
+	var s string
+	if len(args) <= i {
+		return common.Hash{}, invalidParams(fmt.Errorf("param %d missing", i))
+	}
+	if err := json.Unmarshal(args[i], &s); err != nil {
+		return common.Hash{}, invalidParams(err)
+	}
+	h, err := common.String2Hash(s)
+	if err != nil {
+		return common.Hash{}, invalidParams(err)
+	}
+	return h, nil
+}
+
+// paramOptHash parse the hash from args[i], empty string means not set
+func paramOptHash(args []json.RawMessage, i int) (*common.Hash, error) {
+	var s string
+	if len(args) <= i {
+		return nil, invalidParams(fmt.Errorf("param %d missing", i))
+	}
+	if err := json.Unmarshal(args[i], &s); err != nil {
+		return nil, invalidParams(err)
+	}
+	if s == "" {
+		return nil, nil
+	}
+	h, err := common.String2Hash(s)
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+	return &h, nil
+}
+
+func paramUint64(args []json.RawMessage, i int) (uint64, error) {
+	var v uint64
+	if len(args) <= i {
+		return 0, invalidParams(fmt.Errorf("param %d missing", i))
+	}
+	if err := json.Unmarshal(args[i], &v); err != nil {
+		return 0, invalidParams(err)
+	}
+	return v, nil
+}
+
+// paramPage parse the skip and limit start from args[i], limit is no more than MaxMsgCountPerRequest
+func paramPage(args []json.RawMessage, i int) (int, int, error) {
+	skip, err := paramUint64(args, i)
+	if err != nil {
+		return 0, 0, err
+	}
+	limit, err := paramUint64(args, i+1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if limit > MaxMsgCountPerRequest {
+		limit = MaxMsgCountPerRequest
+	}
+	return int(skip), int(limit), nil
+}
+
+func paramMsg(args []json.RawMessage, i int) (*core.Message, error) {
+	var msg core.Message
+	if len(args) <= i {
+		return nil, invalidParams(fmt.Errorf("param %d missing", i))
+	}
+	if err := json.Unmarshal(args[i], &msg); err != nil {
+		return nil, invalidParams(err)
+	}
+	if msg.Value == nil {
+		return nil, invalidParams(fmt.Errorf("param %d value missing", i))
+	}
+	return &msg, nil
+}
+
+func (n *Node) apiNodeInfo(args []json.RawMessage) (interface{}, error) {
+	info := &NodeInfo{
+		Version:   params.Version,
+		Protocol:  galaxy.ProtocolVersion,
+		NodeKey:   n.localNodeKey,
+		Port:      n.localPort,
+		TPEnable:  n.tpEnable,
+		Search:    n.searchEnable,
+		PeerCount: len(n.peers),
+		Orphans:   n.orphans.Stats(),
+	}
+	if n.tpUnlockedUser != nil {
+		info.UserID = common.Hash2String(n.tpUnlockedUser.ID())
+	}
+	if n.universe != nil {
+		info.Roots = []string{common.Hash2String(n.roots[0]), common.Hash2String(n.roots[1])}
+	}
+	if count, err := db.GetMsgCount(n.udb); err == nil {
+		info.MsgCount = count.Uint64()
+	}
+	return info, nil
+}
+
+func (n *Node) apiPeers(args []json.RawMessage) (interface{}, error) {
+	peers := []*PeerInfo{}
+	for k, p := range n.peers {
+		peers = append(peers, &PeerInfo{
+			ID:         common.Hash2String(k),
+			Address:    p.Address(),
+			URL:        p.Url(),
+			UserID:     common.Hash2String(p.UserID),
+			Connected:  p.Connected(),
+			Handshaked: p.Handshaked(),
+			Verified:   p.Verified,
+			Version:    p.Version(),
+		})
+	}
+	return peers, nil
+}
+
+func (n *Node) apiRoots(args []json.RawMessage) (interface{}, error) {
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	user0, user1, err := db.GetRootUsers(n.udb)
+	if err != nil {
+		return nil, err
+	}
+	return []*core.User{user0, user1}, nil
+}
+
+func (n *Node) apiUser(args []json.RawMessage) (interface{}, error) {
+	userID, err := paramHash(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n.universe != nil {
+		if user := n.universe.GetUserByID(userID); user != nil {
+			return user, nil
+		}
+	}
+	return db.GetUserByID(n.udb, userID)
+}
+
+func (n *Node) apiUserInfo(args []json.RawMessage) (interface{}, error) {
+	userID, err := paramHash(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	stID, err := paramHash(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	userInfo := n.universe.GetUserInfo(userID, stID)
+	if userInfo == nil {
+		return nil, db.ErrUserNotFound
+	}
+	return userInfo, nil
+}
+
+func (n *Node) apiSpaceTimes(args []json.RawMessage) (interface{}, error) {
+	sts := []*SpaceTimeInfo{}
+	if n.universe == nil {
+		return sts, nil
+	}
+	for _, stID := range n.universe.GetSpaceTimeIDs() {
+		sts = append(sts, &SpaceTimeInfo{ID: common.Hash2String(stID), MaxSeq: n.universe.GetMaxSeq(stID)})
+	}
+	return sts, nil
+}
+
+func (n *Node) apiMaxSeq(args []json.RawMessage) (interface{}, error) {
+	stID, err := paramHash(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	return n.universe.GetMaxSeq(stID), nil
+}
+
+func (n *Node) apiCheckBirth(args []json.RawMessage) (interface{}, error) {
+	msg, err := paramMsg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	if err := n.universe.CheckBirthMsg(msg); err != nil {
+		return nil, err
+	}
+	user, err := core.CreateNewUser(n.universe, msg)
+	if err != nil {
+		return nil, err
+	}
+	return common.Hash2String(user.ID()), nil
+}
+
+func (n *Node) apiMsgByID(args []json.RawMessage) (interface{}, error) {
+	msgID, err := paramHash(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetMsgByID(n.udb, msgID)
+}
+
+func (n *Node) apiMsgByOrder(args []json.RawMessage) (interface{}, error) {
+	start, err := paramUint64(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	count, err := paramUint64(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if count > MaxMsgCountPerRequest {
+		count = MaxMsgCountPerRequest
+	}
+	msgs := db.GetMsgByOrder(n.udb, new(big.Int).SetUint64(start), int(count))
+	if msgs == nil {
+		msgs = []*core.Message{}
+	}
+	return msgs, nil
+}
+
+func (n *Node) apiLastMsgByUser(args []json.RawMessage) (interface{}, error) {
+	userID, err := paramHash(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetLastMsgByUser(n.udb, userID)
+}
+
+func (n *Node) apiMsgBySender(args []json.RawMessage) (interface{}, error) {
+	userID, err := paramHash(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	skip, limit, err := paramPage(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetMsgsBySender(n.udb, userID, skip, limit)
+}
+
+func (n *Node) apiMsgByType(args []json.RawMessage) (interface{}, error) {
+	contentType, err := paramUint64(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	skip, limit, err := paramPage(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetMsgsByType(n.udb, int(contentType), skip, limit)
+}
+
+func (n *Node) apiChildMsgs(args []json.RawMessage) (interface{}, error) {
+	msgID, err := paramHash(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	skip, limit, err := paramPage(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetChildMsgs(n.udb, msgID, skip, limit)
+}
+
+// apiSearch search TypeText msgs by [text, senderID, spacetimeID, skip, limit], the msgs
+// are filtered by sender, and by the sender still visible in the spacetime, if ID is not empty.
+func (n *Node) apiSearch(args []json.RawMessage) (interface{}, error) {
+	if !n.searchEnable {
+		return nil, errSearchNotEnabled
+	}
+	var text string
+	if len(args) == 0 {
+		return nil, invalidParams(fmt.Errorf("param %d missing", 0))
+	}
+	if err := json.Unmarshal(args[0], &text); err != nil {
+		return nil, invalidParams(err)
+	}
+	senderID, err := paramOptHash(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	stID, err := paramOptHash(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	skip, limit, err := paramPage(args, 3)
+	if err != nil {
+		return nil, err
+	}
+	if stID != nil && n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	return db.SearchMsgs(n.udb, text, func(msg *core.Message) bool {
+		if senderID != nil && msg.SenderID != *senderID {
+			return false
+		}
+		if stID != nil {
+			userInfo := n.universe.GetUserInfo(msg.SenderID, *stID)
+			return userInfo != nil && !userInfo.Punished()
+		}
+		return true
+	}, skip, limit)
+}
+
+func (n *Node) apiSendMsg(args []json.RawMessage) (interface{}, error) {
+	msg, err := paramMsg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n.universe == nil {
+		return nil, errUniverseNotExist
+	}
+	// birth msg is checked before save, so the failed one would not stay in universe
+	if msg.Value != nil && msg.Value.ContentType == core.TypeBirth {
+		if err := n.universe.CheckBirthMsg(msg); err != nil {
+			return nil, err
+		}
+	}
+	// message is verified by universe, signature and references
+	if err := n.saveMsg(msg); err != nil {
+		return nil, err
+	}
+	n.broadcastMsg(msg)
+	return common.Hash2String(msg.ID()), nil
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+// apiCase is the request to local node api, result is the pointer which the result is
+// unmarshal into, code is the code of RPCError, 0 means no error.
+type apiCase struct {
+	name   string
+	method string
+	args   []interface{}
+	result interface{}
+	check  func(result interface{}) bool
+	code   int
+}
+
+func runAPICases(t *testing.T, n *Node, cases []apiCase) {
+	server := httptest.NewServer(http.HandlerFunc(n.nodeHandler))
+	defer server.Close()
+	client := NewClient(server.URL)
+	for _, c := range cases {
+		err := client.Call(c.result, c.method, c.args...)
+		if c.code != 0 {
+			if rpcErr, ok := err.(*RPCError); !ok || rpcErr.Code != c.code {
+				t.Errorf("%s : error code should be %d, but now err : %v", c.name, c.code, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s : call %s fail, %s", c.name, c.method, err)
+		} else if c.check != nil && !c.check(c.result) {
+			t.Errorf("%s : result of %s not match", c.name, c.method)
+		}
+	}
+}
+
+// msgIDs return the IDs of msgs in order
+func msgIDs(result interface{}) []common.Hash {
+	var ids []common.Hash
+	for _, msg := range *result.(*[]*core.Message) {
+		ids = append(ids, msg.ID())
+	}
+	return ids
+}
+
+func sameIDs(ids []common.Hash, targets ...common.Hash) bool {
+	if len(ids) != len(targets) {
+		return false
+	}
+	for i, id := range ids {
+		if id != targets[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNode_API(t *testing.T) {
+	users, priKeys, first := createTestUniverse(t)
+	cfg := testConfig(nil, nil)
+	cfg.Search = true
+	n := newTestNode(t, users, first, cfg)
+
+	second, err := core.CreateMsg(users[1], &core.MsgValue{ContentType: core.TypeText, Content: []byte("second msg")}, priKeys[1],
+		&core.MsgReference{SenderID: users[0].ID(), MsgID: first.ID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stID := users[0].ID()
+	unknownID := common.Hash2String(common.CreateHash())
+
+	runAPICases(t, n, []apiCase{
+		{"send msg", MethodSendMsg, []interface{}{second}, new(string), func(r interface{}) bool {
+			return *r.(*string) == common.Hash2String(second.ID())
+		}, 0},
+		{"send msg again", MethodSendMsg, []interface{}{second}, nil, nil, ErrCodeInternal},
+		{"send msg missing", MethodSendMsg, nil, nil, nil, ErrCodeInvalidParams},
+		{"node info", MethodNodeInfo, nil, new(NodeInfo), func(r interface{}) bool {
+			info := r.(*NodeInfo)
+			return info.NodeKey == n.localNodeKey && info.MsgCount == 2 && info.Search &&
+				len(info.Roots) == 2 && info.Roots[0] == common.Hash2String(users[0].ID())
+		}, 0},
+		{"peers", MethodPeers, nil, new([]*PeerInfo), func(r interface{}) bool {
+			return len(*r.(*[]*PeerInfo)) == 0
+		}, 0},
+		{"roots", MethodRoots, nil, new([]*core.User), func(r interface{}) bool {
+			roots := *r.(*[]*core.User)
+			return len(roots) == 2 && roots[0].ID() == users[0].ID() && roots[1].ID() == users[1].ID()
+		}, 0},
+		{"user", MethodUser, []interface{}{common.Hash2String(users[1].ID())}, new(core.User), func(r interface{}) bool {
+			return r.(*core.User).ID() == users[1].ID()
+		}, 0},
+		{"user not exist", MethodUser, []interface{}{unknownID}, nil, nil, ErrCodeInternal},
+		{"user id not valid", MethodUser, []interface{}{"not hash"}, nil, nil, ErrCodeInvalidParams},
+		{"user info", MethodUserInfo, []interface{}{common.Hash2String(users[1].ID()), common.Hash2String(stID)}, new(core.UserInfo), nil, 0},
+		{"user info not exist", MethodUserInfo, []interface{}{unknownID, common.Hash2String(stID)}, nil, nil, ErrCodeInternal},
+		{"user info missing space time", MethodUserInfo, []interface{}{unknownID}, nil, nil, ErrCodeInvalidParams},
+		{"space times", MethodSpaceTimes, nil, new([]*SpaceTimeInfo), func(r interface{}) bool {
+			sts := *r.(*[]*SpaceTimeInfo)
+			return len(sts) == 1 && sts[0].ID == common.Hash2String(stID) && sts[0].MaxSeq == n.universe.GetMaxSeq(stID)
+		}, 0},
+		{"max seq", MethodMaxSeq, []interface{}{common.Hash2String(stID)}, new(uint64), func(r interface{}) bool {
+			return *r.(*uint64) == n.universe.GetMaxSeq(stID)
+		}, 0},
+		{"max seq of unknown space time", MethodMaxSeq, []interface{}{unknownID}, new(uint64), func(r interface{}) bool {
+			return *r.(*uint64) == 0
+		}, 0},
+		{"check birth of text msg", MethodCheckBirth, []interface{}{first}, nil, nil, ErrCodeInternal},
+		{"check birth missing", MethodCheckBirth, nil, nil, nil, ErrCodeInvalidParams},
+		{"msg by id", MethodMsgByID, []interface{}{common.Hash2String(first.ID())}, new(core.Message), func(r interface{}) bool {
+			return r.(*core.Message).ID() == first.ID()
+		}, 0},
+		{"msg by id not exist", MethodMsgByID, []interface{}{unknownID}, nil, nil, ErrCodeInternal},
+		{"msg by order", MethodMsgByOrder, []interface{}{0, 10}, new([]*core.Message), func(r interface{}) bool {
+			return sameIDs(msgIDs(r), first.ID(), second.ID())
+		}, 0},
+		{"msg by order out of range", MethodMsgByOrder, []interface{}{5, 10}, new([]*core.Message), func(r interface{}) bool {
+			return len(msgIDs(r)) == 0
+		}, 0},
+		{"msg by order missing count", MethodMsgByOrder, []interface{}{0}, nil, nil, ErrCodeInvalidParams},
+		{"last msg by user", MethodLastMsgByUser, []interface{}{common.Hash2String(users[1].ID())}, new(core.Message), func(r interface{}) bool {
+			return r.(*core.Message).ID() == second.ID()
+		}, 0},
+		{"msg by sender", MethodMsgBySender, []interface{}{common.Hash2String(users[0].ID()), 0, 10}, new([]*core.Message), func(r interface{}) bool {
+			return sameIDs(msgIDs(r), first.ID())
+		}, 0},
+		{"msg by sender missing page", MethodMsgBySender, []interface{}{common.Hash2String(users[0].ID())}, nil, nil, ErrCodeInvalidParams},
+		{"msg by type", MethodMsgByType, []interface{}{core.TypeText, 0, 10}, new([]*core.Message), func(r interface{}) bool {
+			return len(msgIDs(r)) == 2
+		}, 0},
+		{"child msgs", MethodChildMsgs, []interface{}{common.Hash2String(first.ID()), 0, 10}, new([]*core.Message), func(r interface{}) bool {
+			return sameIDs(msgIDs(r), second.ID())
+		}, 0},
+		{"search", MethodSearch, []interface{}{"second", "", "", 0, 10}, new([]*core.Message), func(r interface{}) bool {
+			return sameIDs(msgIDs(r), second.ID())
+		}, 0},
+		{"search by other sender", MethodSearch, []interface{}{"second", common.Hash2String(users[0].ID()), "", 0, 10}, new([]*core.Message), func(r interface{}) bool {
+			return len(msgIDs(r)) == 0
+		}, 0},
+		{"search missing text", MethodSearch, nil, nil, nil, ErrCodeInvalidParams},
+		{"method not found", "universe_unknown", nil, nil, nil, ErrCodeMethodNotFound},
+	})
+}
+
+func TestNode_APIEmptyUniverse(t *testing.T) {
+	users, priKeys, _ := createTestUniverse(t)
+	// only root users in universe, so no space time exist
+	n := newTestNode(t, users, nil, testConfig(nil, nil))
+	stID := users[0].ID()
+
+	engine, err := utils.SelectEngine(crypto.PDU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pubKey, err := engine.GenKey(crypto.Signature2PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := core.CreateContentBirth("child", "extra", &core.Auth{PublicKey: *pubKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, user := range users {
+		if err := content.SignByParent(user, *priKeys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	value := &core.MsgValue{ContentType: core.TypeBirth}
+	if value.Content, err = json.Marshal(content); err != nil {
+		t.Fatal(err)
+	}
+	birth, err := core.CreateMsg(users[0], value, priKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runAPICases(t, n, []apiCase{
+		{"node info", MethodNodeInfo, nil, new(NodeInfo), func(r interface{}) bool {
+			info := r.(*NodeInfo)
+			return info.MsgCount == 0 && len(info.Roots) == 2
+		}, 0},
+		{"roots", MethodRoots, nil, new([]*core.User), func(r interface{}) bool {
+			return len(*r.(*[]*core.User)) == 2
+		}, 0},
+		{"user", MethodUser, []interface{}{common.Hash2String(users[0].ID())}, new(core.User), func(r interface{}) bool {
+			return r.(*core.User).ID() == users[0].ID()
+		}, 0},
+		{"user info", MethodUserInfo, []interface{}{common.Hash2String(users[0].ID()), common.Hash2String(stID)}, nil, nil, ErrCodeInternal},
+		{"space times", MethodSpaceTimes, nil, new([]*SpaceTimeInfo), func(r interface{}) bool {
+			return len(*r.(*[]*SpaceTimeInfo)) == 0
+		}, 0},
+		{"max seq", MethodMaxSeq, []interface{}{common.Hash2String(stID)}, new(uint64), func(r interface{}) bool {
+			return *r.(*uint64) == 0
+		}, 0},
+		{"check birth", MethodCheckBirth, []interface{}{birth}, nil, nil, ErrCodeInternal},
+		{"msg by order", MethodMsgByOrder, []interface{}{0, 10}, new([]*core.Message), func(r interface{}) bool {
+			return len(msgIDs(r)) == 0
+		}, 0},
+		{"last msg by user", MethodLastMsgByUser, []interface{}{common.Hash2String(users[0].ID())}, nil, nil, ErrCodeInternal},
+		{"search not enabled", MethodSearch, []interface{}{"text", "", "", 0, 10}, nil, nil, ErrCodeInternal},
+	})
+
+	// the node is still available after all requests
+	if _, err := n.callAPI((*Node).apiNodeInfo, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -20,34 +20,58 @@ import (
 	"encoding/json"
 
 	"github.com/pdupub/go-pdu/common"
-	"github.com/pdupub/go-pdu/db"
 	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/peer"
 )
 
 func (n *Node) askPeers(pid common.Hash) error {
-	p := n.peers[pid]
 	localPeerBytes, err := json.Marshal(n.localPeer())
 	if err != nil {
 		return err
 	}
 	waveID := common.CreateHash()
-	if err := p.SendQuestion(waveID, galaxy.CmdPeers, localPeerBytes); err != nil {
+	n.queueWave(pid, n.peers[pid], func(p *peer.Peer) error {
+		return p.SendQuestion(waveID, galaxy.CmdPeers, localPeerBytes)
+	})
+	if err := n.recordQuestion(pid, waveID); err != nil {
 		return err
 	}
+	return nil
+}
+
+func (n *Node) askVersion(pid common.Hash) error {
+	waveID := common.CreateHash()
+	roots, capabilities := n.roots, n.capabilities
+	n.queueWave(pid, n.peers[pid], func(p *peer.Peer) error {
+		return p.SendVersion(waveID, roots, capabilities)
+	})
 	if err := n.recordQuestion(pid, waveID); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (n *Node) askPing(pid common.Hash) error {
-	p := n.peers[pid]
-	// ping each of peer
+func (n *Node) askUser(pid common.Hash) error {
+	nonce, err := peer.CreateNonce()
+	if err != nil {
+		return err
+	}
 	waveID := common.CreateHash()
-	if err := p.SendPing(waveID); err != nil {
-		n.removePeer(pid)
+	n.queueWave(pid, n.peers[pid], func(p *peer.Peer) error {
+		return p.SendQuestion(waveID, galaxy.CmdUser, nonce)
+	})
+	if err := n.recordChallenge(pid, waveID, nonce); err != nil {
 		return err
 	}
+	return nil
+}
+
+func (n *Node) askPing(pid common.Hash) error {
+	// ping each of peer
+	waveID := common.CreateHash()
+	n.queueWave(pid, n.peers[pid], func(p *peer.Peer) error {
+		return p.SendPing(waveID)
+	})
 	if err := n.recordPing(pid, waveID); err != nil {
 		return err
 	}
@@ -55,11 +79,10 @@ func (n *Node) askPing(pid common.Hash) error {
 }
 
 func (n *Node) askRoots(pid common.Hash) error {
-	p := n.peers[pid]
 	waveID := common.CreateHash()
-	if err := p.SendQuestion(waveID, galaxy.CmdRoots); err != nil {
-		return err
-	}
+	n.queueWave(pid, n.peers[pid], func(p *peer.Peer) error {
+		return p.SendQuestion(waveID, galaxy.CmdRoots)
+	})
 	if err := n.recordQuestion(pid, waveID); err != nil {
 		return err
 	}
@@ -67,40 +90,56 @@ func (n *Node) askRoots(pid common.Hash) error {
 	return nil
 }
 
-func (n *Node) askMsg(pid common.Hash) error {
-	p := n.peers[pid]
-	// get current last message
-	lastMsg, err := db.GetLastMsg(n.udb)
-	var lastMsgID common.Hash
-	if err != nil && err != db.ErrMessageNotFound {
-		return err
-	}
-	if lastMsg != nil {
-		lastMsgID = lastMsg.ID()
-	}
-
-	if lastMsg != nil && lastMsgID == n.lastSyncMsg {
-		return errNoNewMsgSync
-	}
-
+func (n *Node) askTips(pid common.Hash) error {
 	waveID := common.CreateHash()
-	if err := p.SendQuestion(waveID, galaxy.CmdMessages, lastMsgID); err != nil {
-		return err
-	}
+	n.queueWave(pid, n.peers[pid], func(p *peer.Peer) error {
+		return p.SendQuestion(waveID, galaxy.CmdTips)
+	})
 	if err := n.recordQuestion(pid, waveID); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (n *Node) reAskMsg(waveID common.Hash) error {
-	if r, ok := n.questionRecord[waveID]; ok {
-		if left, ok := n.peerSyncCnt[r.pid]; ok && left > 0 {
-			n.peerSyncCnt[r.pid] = left - 1
-			if err := n.askMsg(r.pid); err != nil {
-				n.peerSyncCnt[r.pid] = 0
-				return err
-			}
+// askMsgs ask messages by msg.ID from peer, the msg.ID already asked
+// from any peer will be skipped, so each message only be asked once.
+func (n *Node) askMsgs(pid common.Hash, msgIDs []common.Hash) error {
+	return n.askMsgsBy(pid, galaxy.CmdMessages, msgIDs)
+}
+
+// askAncestors ask messages with their ancestors from peer, the messages
+// are asked without ancestors if the peer not support.
+func (n *Node) askAncestors(pid common.Hash, msgIDs []common.Hash) error {
+	if p, ok := n.peers[pid]; ok && p.HasCapability(galaxy.CapAncestors) {
+		return n.askMsgsBy(pid, galaxy.CmdAncestors, msgIDs)
+	}
+	return n.askMsgsBy(pid, galaxy.CmdMessages, msgIDs)
+}
+
+func (n *Node) askMsgsBy(pid common.Hash, cmd string, msgIDs []common.Hash) error {
+	var ids []common.Hash
+	seen := make(map[common.Hash]bool)
+	for _, id := range msgIDs {
+		if _, ok := n.requestedMsgs[id]; !ok && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for start := 0; start < len(ids); start += peer.MaxMsgCountPerWave {
+		end := start + peer.MaxMsgCountPerWave
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var args []interface{}
+		for _, id := range ids[start:end] {
+			args = append(args, id)
+		}
+		waveID := common.CreateHash()
+		n.queueWave(pid, n.peers[pid], func(p *peer.Peer) error {
+			return p.SendQuestion(waveID, cmd, args...)
+		})
+		if err := n.recordMsgsQuestion(pid, waveID, ids[start:end]); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -110,6 +149,14 @@ func (n *Node) delRecord(waveID common.Hash, cmd string) {
 	if cmd == galaxy.CmdPong {
 		delete(n.pingpongRecord, waveID)
 	} else {
+		// msg.ID not received by this question can be asked again
+		if r, ok := n.questionRecord[waveID]; ok {
+			for _, id := range r.msgIDs {
+				if n.requestedMsgs[id] == waveID {
+					delete(n.requestedMsgs, id)
+				}
+			}
+		}
 		delete(n.questionRecord, waveID)
 	}
 }
@@ -131,3 +178,24 @@ func (n *Node) recordPing(peerID, waveID common.Hash) error {
 	}
 	return nil
 }
+
+func (n *Node) recordChallenge(peerID, waveID common.Hash, nonce []byte) error {
+	if _, ok := n.questionRecord[waveID]; !ok {
+		n.questionRecord[waveID] = &Record{pid: peerID, delay: 0, nonce: nonce}
+	} else {
+		return errDuplicateWaveID
+	}
+	return nil
+}
+
+func (n *Node) recordMsgsQuestion(peerID, waveID common.Hash, msgIDs []common.Hash) error {
+	if _, ok := n.questionRecord[waveID]; !ok {
+		n.questionRecord[waveID] = &Record{pid: peerID, delay: 0, msgIDs: msgIDs}
+	} else {
+		return errDuplicateWaveID
+	}
+	for _, id := range msgIDs {
+		n.requestedMsgs[id] = waveID
+	}
+	return nil
+}
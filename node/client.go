@@ -0,0 +1,85 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultClientTimeout is the default timeout of request to local node api
+const DefaultClientTimeout = 10 * time.Second
+
+// Client is used to call the local node api of running node
+type Client struct {
+	url    string
+	client *http.Client
+	nextID uint64
+}
+
+// NewClient create the client of local node api, url is like http://127.0.0.1:8342/node
+func NewClient(url string) *Client {
+	return &Client{url: url, client: &http.Client{Timeout: DefaultClientTimeout}}
+}
+
+// URL return the url of local node api
+func (c *Client) URL() string {
+	return c.url
+}
+
+// Call the method of local node api, the result is unmarshal into result if not nil
+func (c *Client) Call(result interface{}, method string, args ...interface{}) error {
+	c.nextID++
+	id, err := json.Marshal(c.nextID)
+	if err != nil {
+		return err
+	}
+	req := &RPCRequest{JSONRPC: JSONRPCVersion, ID: id, Method: method, Params: []json.RawMessage{}}
+	for _, arg := range args {
+		argBytes, err := json.Marshal(arg)
+		if err != nil {
+			return err
+		}
+		req.Params = append(req.Params, argBytes)
+	}
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpRes, err := c.client.Post(c.url, "application/json", bytes.NewReader(reqBytes))
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close()
+	if httpRes.StatusCode != http.StatusOK {
+		return fmt.Errorf("request fail with status %s", httpRes.Status)
+	}
+	var res RPCResponse
+	if err := json.NewDecoder(httpRes.Body).Decode(&res); err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Result, result)
+}
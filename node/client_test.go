@@ -0,0 +1,88 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient_Call(t *testing.T) {
+	var lastReq RPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastReq = RPCRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&lastReq); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		res := &RPCResponse{JSONRPC: JSONRPCVersion, ID: lastReq.ID}
+		switch lastReq.Method {
+		case "echo":
+			res.Result, _ = json.Marshal(lastReq.Params)
+		case "fail":
+			res.Error = &RPCError{Code: ErrCodeInternal, Message: "fail"}
+		case "broken":
+			w.Write([]byte("not json"))
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if client.URL() != server.URL {
+		t.Errorf("url should be %s, but %s", server.URL, client.URL())
+	}
+	var params []interface{}
+	cases := []struct {
+		name   string
+		method string
+		args   []interface{}
+		result interface{}
+		fail   bool
+	}{
+		{"echo params", "echo", []interface{}{"a", 1}, &params, false},
+		{"result not needed", "echo", nil, nil, false},
+		{"rpc error", "fail", nil, nil, true},
+		{"response not json", "broken", nil, nil, true},
+		{"http status not ok", "unknown", nil, nil, true},
+		{"args can not marshal", "echo", []interface{}{make(chan int)}, nil, true},
+	}
+	for _, c := range cases {
+		err := client.Call(c.result, c.method, c.args...)
+		if c.fail != (err != nil) {
+			t.Errorf("%s : fail should be %t, but err : %v", c.name, c.fail, err)
+		}
+	}
+	if len(params) != 2 || params[0] != "a" || params[1] != float64(1) {
+		t.Errorf("params should be echo back, but %v", params)
+	}
+	if _, ok := client.Call(nil, "fail").(*RPCError); !ok {
+		t.Error("error of response should be RPCError")
+	}
+	// each request has its own id
+	id := string(lastReq.ID)
+	client.Call(nil, "echo")
+	if string(lastReq.ID) == id || lastReq.JSONRPC != JSONRPCVersion {
+		t.Errorf("request not valid, id %s, jsonrpc %s", lastReq.ID, lastReq.JSONRPC)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"time"
+
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+)
+
+// Config is the config of node, the node is created by DefaultConfig if nil
+type Config struct {
+	// LocalPort is the port of incoming connections, any free port is used if 0
+	LocalPort uint64
+
+	// APIPort is the port of local api, any free port is used if 0
+	APIPort uint64
+
+	// ExposeAPI listen the local api on all interfaces,
+	// otherwise the local api is only listened on DefaultAPIHost
+	ExposeAPI bool
+
+	// Nodes is the target nodes split by comma [userid@ip:port/nodeKey]
+	Nodes string
+
+	// MsgVerifiedOnly only accept messages from verified peers
+	MsgVerifiedOnly bool
+
+	// PeersVerifiedOnly only exchange peers with verified peers
+	PeersVerifiedOnly bool
+
+	// Codec of waves and messages, the codec saved in db is used if empty
+	Codec string
+
+	// Search enable the full-text search of text messages
+	Search bool
+
+	// TPUser and TPPrivateKey enable the time proof if set, a new message
+	// is created by TPUser every TPInterval seconds
+	TPUser       *core.User
+	TPPrivateKey *crypto.PrivateKey
+	TPInterval   uint64
+
+	// CheckInterval is the interval of checking and syncing from peers
+	CheckInterval time.Duration
+}
+
+// DefaultConfig return the default config of node
+func DefaultConfig() *Config {
+	return &Config{
+		LocalPort:     DefaultLocalPort,
+		APIPort:       DefaultAPIPort,
+		Codec:         core.CodecBinary,
+		TPInterval:    DefaultTimeProofInterval,
+		CheckInterval: DefaultCheckInterval,
+	}
+}
@@ -21,7 +21,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/big"
 	"strings"
 
 	"github.com/pdupub/go-pdu/common"
@@ -39,29 +38,68 @@ const (
 
 var (
 	errQuestionUnsupport = errors.New("question unsupport")
+	errArgsNotValid      = errors.New("arguments not valid")
+	errRootsNotValid     = errors.New("root users not valid")
 )
 
 func (n *Node) handleMessages(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
 	wm := w.(*galaxy.WaveMessages)
+	if n.msgVerifiedOnly && !n.verifiedPeer(ws, wm.WaveID) {
+		return wm.WaveID, errPeerNotVerified
+	}
+	if n.universe == nil {
+		return wm.WaveID, errUniverseNotExist
+	}
+	var pid common.Hash
+	if r, ok := n.questionRecord[wm.WaveID]; ok && ws == nil {
+		pid = r.pid
+	}
+	// the invalid msg will not stop the others in same wave, first error is returned
+	var firstErr error
+	received := make(map[common.Hash]bool)
 	for _, wmsg := range wm.Msgs {
-		var msg core.Message
-		if err := json.Unmarshal(wmsg, &msg); err != nil {
+		msg, err := core.UnmarshalMsg(wmsg)
+		if err != nil {
 			return wm.WaveID, err
 		}
-		// save msg (universe & udb)
-		if err := n.saveMsg(&msg); err != nil {
-			return wm.WaveID, err
-		} else if err := n.broadcastMsg(&msg); err != nil {
-			return wm.WaveID, err
+		received[msg.ID()] = true
+		// save msg (universe & udb) or keep as orphan
+		if err := n.receiveMsg(pid, msg); err != nil && firstErr == nil {
+			firstErr = err
 		}
 	}
-	return wm.WaveID, nil
+	if ws == nil {
+		n.processOrphans(n.markAbsentMsgs(wm.WaveID, received)...)
+	}
+	return wm.WaveID, firstErr
+}
+
+func (n *Node) handleTips(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
+	wm := w.(*galaxy.WaveTips)
+	r, ok := n.questionRecord[wm.WaveID]
+	if !ok || ws != nil {
+		return wm.WaveID, errTargetWaveIDMissing
+	}
+	if n.universe == nil {
+		return wm.WaveID, errUniverseNotExist
+	}
+	var unknown []common.Hash
+	for _, id := range wm.MsgIDs {
+		if !n.knownMsg(id) {
+			unknown = append(unknown, id)
+		}
+	}
+	// the references of orphans may be lost by timeout, ask again
+	unknown = append(unknown, n.missingOrphanRefs()...)
+	return wm.WaveID, n.askMsgs(r.pid, unknown)
 }
 
-func (n Node) handlePing(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
+func (n *Node) handlePing(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
 	wm := w.(*galaxy.WavePing)
-	p := peer.Peer{Conn: ws}
-	return wm.WaveID, p.SendPong(wm.WaveID)
+	n.queueWave(common.Hash{}, n.wsPeer(ws), func(p *peer.Peer) error {
+		return p.SendPong(wm.WaveID)
+	})
+	return wm.WaveID, nil
 }
 
 func (n *Node) handlePong(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
@@ -74,6 +112,9 @@ func (n *Node) handleRoots(ws *websocket.Conn, w galaxy.Wave) (common.Hash, erro
 	if n.initStep < db.StepRootsSaved {
 		user0 := wm.Users[0]
 		user1 := wm.Users[1]
+		if user0 == nil || user1 == nil {
+			return wm.WaveID, errRootsNotValid
+		}
 		log.Info("user0", common.Hash2String(user0.ID()))
 		log.Info("user1", common.Hash2String(user1.ID()))
 		// update init step
@@ -86,12 +127,85 @@ func (n *Node) handleRoots(ws *websocket.Conn, w galaxy.Wave) (common.Hash, erro
 		if err := db.SaveRootUsers(n.udb, wm.Users[:]); err != nil {
 			return wm.WaveID, err
 		}
+		n.setRoots(user0.ID(), user1.ID())
+	}
+	return wm.WaveID, nil
+}
+
+func (n *Node) handleVersion(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
+	wm := w.(*galaxy.WaveVersion)
+	capabilities, err := n.localVersion().Negotiate(wm)
+	if ws != nil {
+		// version from remote node, response local version if compatible
+		if err != nil {
+			return wm.WaveID, err
+		}
+		p := n.wsPeer(ws)
+		roots, localCapabilities := n.roots, n.capabilities
+		n.queueWave(common.Hash{}, p, func(p *peer.Peer) error {
+			return p.SendVersion(wm.WaveID, roots, localCapabilities)
+		})
+		p.SetHandshake(wm.Version, capabilities)
+		return wm.WaveID, nil
+	}
+	// response of local version, disconnect if not compatible
+	r, ok := n.questionRecord[wm.WaveID]
+	if !ok {
+		return wm.WaveID, errTargetWaveIDMissing
+	}
+	p, ok := n.peers[r.pid]
+	if !ok {
+		return wm.WaveID, errTargetWaveIDMissing
+	}
+	if err != nil {
+		log.Error("Disconnect peer", p.Address(), err)
+		p.Close()
+		n.removePeer(r.pid)
+		return wm.WaveID, err
+	}
+	p.SetHandshake(wm.Version, capabilities)
+	log.Info("Handshake with peer", p.Address(), "version", wm.Version)
+	if n.needChallenge(p) {
+		if err := n.askUser(r.pid); err != nil {
+			return wm.WaveID, err
+		}
+	}
+	// ask peers after verified if only exchange peers with verified peers
+	if !n.peersVerifiedOnly && p.HasCapability(galaxy.CapPeers) {
+		return wm.WaveID, n.askPeers(r.pid)
+	}
+	return wm.WaveID, nil
+}
+
+func (n *Node) handleUser(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
+	wm := w.(*galaxy.WaveUser)
+	r, ok := n.questionRecord[wm.WaveID]
+	if !ok || r.nonce == nil {
+		return wm.WaveID, errTargetWaveIDMissing
+	}
+	p, ok := n.peers[r.pid]
+	if !ok {
+		return wm.WaveID, errTargetWaveIDMissing
+	}
+	if n.universe == nil || wm.UserID != p.UserID {
+		return wm.WaveID, peer.ErrUserNotMatch
+	}
+	if err := p.VerifyChallenge(r.nonce, n.universe.GetUserByID(wm.UserID), wm.Signature); err != nil {
+		log.Error("Verify peer fail", p.Address(), err)
+		return wm.WaveID, err
+	}
+	log.Info("Peer verified", p.Address())
+	if n.peersVerifiedOnly && p.HasCapability(galaxy.CapPeers) {
+		return wm.WaveID, n.askPeers(r.pid)
 	}
 	return wm.WaveID, nil
 }
 
 func (n *Node) handlePeers(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error) {
 	wm := w.(*galaxy.WavePeers)
+	if n.peersVerifiedOnly && !n.verifiedPeer(ws, wm.WaveID) {
+		return wm.WaveID, errPeerNotVerified
+	}
 	for _, peerBytes := range wm.Peers {
 		var targetPeer peer.Peer
 		err := json.Unmarshal(peerBytes, &targetPeer)
@@ -99,7 +213,7 @@ func (n *Node) handlePeers(ws *websocket.Conn, w galaxy.Wave) (common.Hash, erro
 			return wm.WaveID, err
 		}
 
-		if err := n.AddPeer(&targetPeer); err != nil {
+		if err := n.addPeer(&targetPeer); err != nil {
 			if err != errPeerAlreadyExist {
 				return wm.WaveID, err
 			}
@@ -115,23 +229,48 @@ func (n *Node) handleErr(ws *websocket.Conn, w galaxy.Wave) (common.Hash, error)
 	return wm.WaveID, nil
 }
 
-func (n Node) handleQuestionRoots(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
-	p := peer.Peer{Conn: ws}
+func (n *Node) handleQuestionRoots(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
 	user0, user1, err := db.GetRootUsers(n.udb)
 	if err != nil {
 		return wq.WaveID, err
 	}
-	if err = p.SendRoots(wq.WaveID, user0, user1); err != nil {
+	n.queueWave(common.Hash{}, n.wsPeer(ws), func(p *peer.Peer) error {
+		return p.SendRoots(wq.WaveID, user0, user1)
+	})
+	return wq.WaveID, nil
+}
+
+func (n *Node) handleQuestionUser(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
+	if n.tpUnlockedUser == nil || n.tpUnlockedPrivateKey == nil {
+		return wq.WaveID, errUserNotUnlocked
+	}
+	if len(wq.Args) == 0 || len(wq.Args[0]) != peer.NonceSize {
+		return wq.WaveID, errArgsNotValid
+	}
+	sig, err := peer.SignChallenge(wq.Args[0], n.localNodeKey, n.tpUnlockedPrivateKey)
+	if err != nil {
 		return wq.WaveID, err
 	}
+	userID := n.tpUnlockedUser.ID()
+	n.queueWave(common.Hash{}, n.wsPeer(ws), func(p *peer.Peer) error {
+		return p.SendUser(wq.WaveID, userID, sig)
+	})
 	return wq.WaveID, nil
 }
 
-func (n Node) handleQuestionPeers(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
-	p := peer.Peer{Conn: ws}
-	if err := p.SendPeers(wq.WaveID, n.peers, n.localPeer()); err != nil {
-		return wq.WaveID, err
+func (n *Node) handleQuestionPeers(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
+	// the peers are copied, so they can be sent after lock released
+	sharedPeers := make(map[common.Hash]*peer.Peer)
+	for k, v := range n.peers {
+		if v.Verified || !n.peersVerifiedOnly {
+			cp := *v
+			sharedPeers[k] = &cp
+		}
 	}
+	localPeer := n.localPeer()
+	n.queueWave(common.Hash{}, n.wsPeer(ws), func(p *peer.Peer) error {
+		return p.SendPeers(wq.WaveID, sharedPeers, localPeer)
+	})
 	// add request peer to node.peers
 	var remotePeer peer.Peer
 	if err := json.Unmarshal(wq.Args[0], &remotePeer); err != nil {
@@ -140,44 +279,59 @@ func (n Node) handleQuestionPeers(ws *websocket.Conn, wq *galaxy.WaveQuestion) (
 	// get remote ip address
 	remoteAddr := strings.Split(ws.Request().RemoteAddr, ":")
 	remotePeer.IP = remoteAddr[0]
-	if err := n.AddPeer(&remotePeer); err != nil {
+	if err := n.addPeer(&remotePeer); err != nil {
 		return wq.WaveID, err
 	}
 	return wq.WaveID, nil
 }
 
-func (n Node) handleQuestionMsg(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
-	p := peer.Peer{Conn: ws}
+func (n *Node) handleQuestionTips(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
+	var tips []common.Hash
+	if n.universe != nil {
+		tips = n.universe.GetTips()
+	}
+	n.queueWave(common.Hash{}, n.wsPeer(ws), func(p *peer.Peer) error {
+		return p.SendTips(wq.WaveID, tips)
+	})
+	return wq.WaveID, nil
+}
 
-	var order, count *big.Int
-	var err error
+func (n *Node) handleQuestionMsg(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
 	var msgs []*core.Message
-	msgID := common.Bytes2Hash(wq.Args[0])
-
-	if msgID != common.Bytes2Hash([]byte{}) {
-		order, count, err = db.GetOrderCntByMsg(n.udb, msgID)
-		if err != nil {
-			return wq.WaveID, err
+	for i, arg := range wq.Args {
+		if i >= peer.MaxMsgCountPerWave {
+			break
 		}
-		order = order.Add(order, big.NewInt(1))
-	} else {
-		order = big.NewInt(0)
-		count, err = db.GetMsgCount(n.udb)
-		if err != nil {
+		msg, err := db.GetMsgByID(n.udb, common.Bytes2Hash(arg))
+		if err == db.ErrMessageNotFound {
+			continue
+		} else if err != nil {
 			return wq.WaveID, err
 		}
+		msgs = append(msgs, msg)
 	}
+	n.queueWave(common.Hash{}, n.wsPeer(ws), func(p *peer.Peer) error {
+		return p.SendMsgs(wq.WaveID, msgs)
+	})
+	return wq.WaveID, nil
+}
 
-	if order != nil && count != nil && count.Uint64()-order.Uint64() > peer.MaxMsgCountPerWave {
-		//log.Debug("Send msg from order", order, "size", peer.MaxMsgCountPerWave)
-		msgs = db.GetMsgByOrder(n.udb, order, peer.MaxMsgCountPerWave)
+func (n *Node) handleQuestionAncestors(ws *websocket.Conn, wq *galaxy.WaveQuestion) (common.Hash, error) {
+	var msgIDs []common.Hash
+	for _, arg := range wq.Args {
+		msgIDs = append(msgIDs, common.Bytes2Hash(arg))
 	}
-	if err = p.SendMsgs(wq.WaveID, msgs); err != nil {
+	msgs, err := n.ancestorMsgs(msgIDs, peer.MaxMsgCountPerWave)
+	if err != nil {
 		return wq.WaveID, err
 	}
+	n.queueWave(common.Hash{}, n.wsPeer(ws), func(p *peer.Peer) error {
+		return p.SendMsgs(wq.WaveID, msgs)
+	})
 	return wq.WaveID, nil
 }
-func (n Node) handleQuestion(ws *websocket.Conn, w galaxy.Wave) (waveID common.Hash, err error) {
+
+func (n *Node) handleQuestion(ws *websocket.Conn, w galaxy.Wave) (waveID common.Hash, err error) {
 	waveQuestion := w.(*galaxy.WaveQuestion)
 	switch waveQuestion.Cmd {
 	case galaxy.CmdRoots:
@@ -186,6 +340,12 @@ func (n Node) handleQuestion(ws *websocket.Conn, w galaxy.Wave) (waveID common.H
 		waveID, err = n.handleQuestionPeers(ws, waveQuestion)
 	case galaxy.CmdMessages:
 		waveID, err = n.handleQuestionMsg(ws, waveQuestion)
+	case galaxy.CmdAncestors:
+		waveID, err = n.handleQuestionAncestors(ws, waveQuestion)
+	case galaxy.CmdTips:
+		waveID, err = n.handleQuestionTips(ws, waveQuestion)
+	case galaxy.CmdUser:
+		waveID, err = n.handleQuestionUser(ws, waveQuestion)
 	default:
 		waveID, err = waveQuestion.WaveID, errQuestionUnsupport
 	}
@@ -206,10 +366,16 @@ func (n *Node) handleWave(ws *websocket.Conn, w galaxy.Wave, alwaysTrue bool) (w
 		waveID, err = n.handlePing(ws, w)
 	case galaxy.CmdPong:
 		waveID, err = n.handlePong(ws, w)
+	case galaxy.CmdVersion:
+		waveID, err = n.handleVersion(ws, w)
 	case galaxy.CmdRoots:
 		waveID, err = n.handleRoots(ws, w)
 	case galaxy.CmdPeers:
 		waveID, err = n.handlePeers(ws, w)
+	case galaxy.CmdUser:
+		waveID, err = n.handleUser(ws, w)
+	case galaxy.CmdTips:
+		waveID, err = n.handleTips(ws, w)
 	case galaxy.CmdErr:
 		waveID, err = n.handleErr(ws, w)
 	default:
@@ -218,35 +384,122 @@ func (n *Node) handleWave(ws *websocket.Conn, w galaxy.Wave, alwaysTrue bool) (w
 	return waveID, err
 }
 
-func (n Node) serveReceiveWave(r io.Reader, kh common.Hash, chanWave chan<- galaxy.Wave, chanSig chan<- common.Hash) {
+func (n *Node) serveReceiveWave(r io.Reader, kh common.Hash, chanWave chan<- galaxy.Wave, chanSig chan<- common.Hash) {
+	defer n.wg.Done()
 	log.Trace("Start receive wave", common.Hash2String(kh))
 	for {
-		w, err := galaxy.ReceiveWave(r)
+		w, err := galaxy.ReceiveWave(r, n.currentMagic())
 		if err != nil {
 			log.Error("Serve receive wave fail", err)
-			chanSig <- kh
+			select {
+			case chanSig <- kh:
+			case <-n.quit:
+			}
 			log.Trace("Stop receive wave", common.Hash2String(kh))
-			break
+			return
 		}
-		chanWave <- w
+		select {
+		case chanWave <- w:
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+// currentMagic return the network magic, which is changed after roots received
+func (n *Node) currentMagic() [galaxy.MagicSize]byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.magic
+}
+
+// wsPeer return the peer of ws connection to send response, which keep the
+// handshake of connection, the temporary peer is created if not found
+func (n *Node) wsPeer(ws *websocket.Conn) *peer.Peer {
+	p, ok := n.wsPeers[ws]
+	if !ok {
+		p = &peer.Peer{Conn: ws}
 	}
+	p.SetMagic(n.magic)
+	return p
+}
+
+// addWSPeer add the peer of incoming connection, false is returned if node is stopping
+func (n *Node) addWSPeer(ws *websocket.Conn) (*peer.Peer, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil, false
+	}
+	p := n.wsPeer(ws)
+	n.wsPeers[ws] = p
+	n.wg.Add(2)
+	return p, true
+}
+
+// removeWSPeer remove the peer of incoming connection after it closed
+func (n *Node) removeWSPeer(ws *websocket.Conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.wsPeers, ws)
+	n.wg.Done()
 }
 
-func (n Node) wsHandler(ws *websocket.Conn) {
+func (n *Node) wsHandler(ws *websocket.Conn) {
 	chanWave := make(chan galaxy.Wave)
 	chanSig := make(chan common.Hash)
-	p := peer.Peer{Conn: ws}
+	// connection accepted while stopping is closed, the others are closed by Stop
+	p, ok := n.addWSPeer(ws)
+	if !ok {
+		return
+	}
+	defer n.removeWSPeer(ws)
 	go n.serveReceiveWave(ws, common.Hash{}, chanWave, chanSig)
+	// the first wave must be version, connection will be closed if handshake fail,
+	// and the waves received after closed are dropped until serveReceiveWave stop
+	handshaked, closed := false, false
 	for {
 		select {
 		case w := <-chanWave:
-			waveID, err := n.handleWave(ws, w, false)
-			if err != nil {
-				log.Error("Socket Handler", err)
-				p.SendErr(waveID, err)
+			if closed {
+				continue
 			}
+			handshaked, closed = n.handleWSWave(ws, p, w, handshaked)
 		case <-chanSig:
 			return
+		case <-n.quit:
+			return
 		}
 	}
 }
+
+// handleWSWave handle the wave from incoming connection, return whether the
+// connection is handshaked and whether it is closed after the wave
+func (n *Node) handleWSWave(ws *websocket.Conn, p *peer.Peer, w galaxy.Wave, handshaked bool) (bool, bool) {
+	n.mu.Lock()
+	defer n.unlock()
+	if !handshaked && w.Command() != galaxy.CmdVersion {
+		log.Error("Socket Handler", errHandshakeRequired)
+		n.queueErr(p, common.Hash{}, errHandshakeRequired, true)
+		return false, true
+	}
+	waveID, err := n.handleWave(ws, w, false)
+	if err != nil {
+		log.Error("Socket Handler", err)
+		n.queueErr(p, waveID, err, !handshaked)
+		return handshaked, !handshaked
+	}
+	return handshaked || w.Command() == galaxy.CmdVersion, false
+}
+
+// queueErr queue the error to the peer of incoming connection, and close
+// the connection after the error sent if needed
+func (n *Node) queueErr(p *peer.Peer, waveID common.Hash, err error, closeConn bool) {
+	n.queueWave(common.Hash{}, p, func(p *peer.Peer) error {
+		p.SendErr(waveID, err)
+		if closeConn {
+			return p.Close()
+		}
+		return nil
+	})
+}
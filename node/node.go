@@ -17,16 +17,19 @@
 package node
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pdupub/go-pdu/common"
@@ -35,6 +38,7 @@ import (
 	"github.com/pdupub/go-pdu/crypto"
 	"github.com/pdupub/go-pdu/db"
 	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/params"
 	"github.com/pdupub/go-pdu/peer"
 	"golang.org/x/net/websocket"
 )
@@ -42,11 +46,9 @@ import (
 const (
 	displayInterval     = 1000
 	maxLoadPeersCount   = 1000
-	checkPeerInterval   = 10
 	maxPingPongDelayCnt = 10
 	maxQuestionDelayCnt = 10
 	maxPeerLoopCnt      = 4
-	syncMsgLoopCnt      = 100
 )
 
 var (
@@ -54,17 +56,34 @@ var (
 	errPeerAlreadyExist     = errors.New("peer already exist")
 	errDuplicateWaveID      = errors.New("duplicate wave id")
 	errTargetWaveIDMissing  = errors.New("target wave id missing")
-	errNoNewMsgSync         = errors.New("no new message sync")
+	errUniverseNotExist     = errors.New("universe not exist")
+	errHandshakeRequired    = errors.New("version handshake required")
+	errPeerNotVerified      = errors.New("peer not verified")
+	errUserNotUnlocked      = errors.New("user of local node not unlocked")
+	errSearchNotEnabled     = errors.New("search not enabled")
+	errNodeAlreadyStarted   = errors.New("node already started")
+	errNodeNotStarted       = errors.New("node not started or already stopped")
 )
 
 // Record is the struct of wave request
 type Record struct {
-	pid   common.Hash
-	delay int
+	pid    common.Hash
+	delay  int
+	nonce  []byte
+	msgIDs []common.Hash
 }
 
-// Node is struct of node
+// Node is struct of node, the state is shared by the goroutines of node loop,
+// time proof, incoming connections and local api, each of them hold mu while
+// handling one event, so the state is only touched by one goroutine at a time.
 type Node struct {
+	mu                   sync.Mutex
+	wg                   sync.WaitGroup // goroutines stopped by quit
+	quit                 chan struct{}
+	done                 chan struct{}
+	stopped              bool
+	server               *http.Server
+	apiServer            *http.Server
 	udb                  db.UDB
 	tpEnable             bool
 	tpInterval           uint64
@@ -72,31 +91,53 @@ type Node struct {
 	tpUnlockedUser       *core.User
 	tpUnlockedPrivateKey *crypto.PrivateKey
 	localPort            uint64
+	apiPort              uint64
+	exposeAPI            bool
 	localNodeKey         string
 	peers                map[common.Hash]*peer.Peer
 	initStep             uint64
 	pingpongRecord       map[common.Hash]*Record
 	questionRecord       map[common.Hash]*Record
 	wsAcceptMsg          bool
-	peerSyncCnt          map[common.Hash]int
-	lastSyncMsg          common.Hash
+	requestedMsgs        map[common.Hash]common.Hash
+	orphans              *orphanPool
+	absentMsgs           *absentSet
 	standardLoopCnt      map[common.Hash]uint64
+	snapshotInterval     uint64
+	msgVerifiedOnly      bool
+	peersVerifiedOnly    bool
+	searchEnable         bool
+	capabilities         []string
+	wsPeers              map[*websocket.Conn]*peer.Peer // peers of incoming connections
+	checkInterval        time.Duration
+	roots                [2]common.Hash
+	magic                [galaxy.MagicSize]byte
+	outbox               []*outWave // waves sent after n.mu released
 }
 
-// New is used to create new node
-func New(udb db.UDB) (node *Node, err error) {
+// New is used to create new node by config, DefaultConfig is used if cfg is nil
+func New(udb db.UDB, cfg *Config) (node *Node, err error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
 	node = &Node{
-		udb:             udb,
-		tpInterval:      uint64(1),
-		localPort:       DefaultLocalPort,
-		peers:           make(map[common.Hash]*peer.Peer),
-		pingpongRecord:  make(map[common.Hash]*Record),
-		questionRecord:  make(map[common.Hash]*Record),
-		wsAcceptMsg:     false,
-		peerSyncCnt:     make(map[common.Hash]int),
-		lastSyncMsg:     common.Hash{},
-		standardLoopCnt: make(map[common.Hash]uint64),
+		udb:              udb,
+		tpInterval:       uint64(1),
+		localPort:        DefaultLocalPort,
+		apiPort:          DefaultAPIPort,
+		peers:            make(map[common.Hash]*peer.Peer),
+		pingpongRecord:   make(map[common.Hash]*Record),
+		questionRecord:   make(map[common.Hash]*Record),
+		wsAcceptMsg:      false,
+		requestedMsgs:    make(map[common.Hash]common.Hash),
+		absentMsgs:       newAbsentSet(DefaultAbsentSetSize, DefaultAbsentPeers, DefaultAbsentTTL),
+		standardLoopCnt:  make(map[common.Hash]uint64),
+		snapshotInterval: DefaultSnapshotInterval,
+		capabilities:     galaxy.DefaultCapabilities,
+		wsPeers:          make(map[*websocket.Conn]*peer.Peer),
+		checkInterval:    DefaultCheckInterval,
 	}
+	node.orphans = newOrphanPool(DefaultOrphanPoolSize, DefaultOrphanMaxAge, node.msgRequested)
 	rand.Seed(time.Now().UnixNano())
 	if err := node.loadUniverse(); err != nil {
 		return nil, err
@@ -106,18 +147,76 @@ func New(udb db.UDB) (node *Node, err error) {
 		return nil, err
 	}
 
+	if err := node.applyConfig(cfg); err != nil {
+		return nil, err
+	}
 	return node, nil
 }
 
+func (n *Node) applyConfig(cfg *Config) error {
+	n.SetLocalPort(cfg.LocalPort)
+	n.SetAPI(cfg.APIPort, cfg.ExposeAPI)
+	n.SetPeerPolicy(cfg.MsgVerifiedOnly, cfg.PeersVerifiedOnly)
+	if cfg.CheckInterval > 0 {
+		n.checkInterval = cfg.CheckInterval
+	}
+	if cfg.Codec != "" {
+		if err := n.SetCodec(cfg.Codec); err != nil {
+			return err
+		}
+	}
+	if cfg.Search {
+		if err := n.EnableSearch(); err != nil {
+			return err
+		}
+	}
+	if cfg.TPUser != nil {
+		if err := n.EnableTP(cfg.TPUser, cfg.TPPrivateKey, cfg.TPInterval); err != nil {
+			return err
+		}
+	}
+	if cfg.Nodes != "" {
+		return n.SetNodes(cfg.Nodes)
+	}
+	return nil
+}
+
 // SetLocalPort set local listen port
 func (n *Node) SetLocalPort(port uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 	n.localPort = port
 }
 
-// AddPeer add peer to local node peers
+// SetAPI set the port of local api, and listen it on all interfaces if expose
+func (n *Node) SetAPI(port uint64, expose bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.apiPort = port
+	n.exposeAPI = expose
+}
+
+// SetPeerPolicy set the policy of peers which are not verified by user challenge,
+// accept messages or exchange peers only with verified peers if true
+func (n *Node) SetPeerPolicy(msgVerifiedOnly, peersVerifiedOnly bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.msgVerifiedOnly = msgVerifiedOnly
+	n.peersVerifiedOnly = peersVerifiedOnly
+}
+
+// AddPeer add peer to local node peers, the peer need to be verified by local node
 func (n *Node) AddPeer(p *peer.Peer) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.addPeer(p)
+}
+
+func (n *Node) addPeer(p *peer.Peer) error {
 	if po, ok := n.peers[p.ID()]; (!ok || po.Url() != p.Url()) && p.NodeKey != n.localNodeKey {
 		p.Conn = nil
+		p.Verified = false
+		p.SetMagic(n.magic)
 		peerBytes, err := json.Marshal(p)
 		if err != nil {
 			return err
@@ -205,6 +304,8 @@ func (n *Node) loadPeers() error {
 			continue
 		}
 		if newPeer.NodeKey != n.localNodeKey {
+			newPeer.Verified = false
+			newPeer.SetMagic(n.magic)
 			n.peers[h] = &newPeer
 			log.Info("Peers load", newPeer.Url(), "peerID", common.Hash2String(h))
 		}
@@ -234,8 +335,31 @@ func (n *Node) setLocalNodeKey() error {
 	return nil
 }
 
+// setRoots set the root users of local universe, and the network magic
+// created by them for node and all peers
+func (n *Node) setRoots(root0, root1 common.Hash) {
+	n.roots = [2]common.Hash{root0, root1}
+	n.magic = galaxy.CreateMagic(root0, root1)
+	for _, p := range n.peers {
+		p.SetMagic(n.magic)
+	}
+}
+
+// localVersion return the version wave of local node
+func (n *Node) localVersion() *galaxy.WaveVersion {
+	return &galaxy.WaveVersion{
+		Version:      params.Version,
+		Protocol:     galaxy.ProtocolVersion,
+		MinProtocol:  galaxy.MinProtocolVersion,
+		Roots:        n.roots,
+		Capabilities: n.capabilities,
+	}
+}
+
 // EnableTP set the time proof settings
 func (n *Node) EnableTP(user *core.User, priKey *crypto.PrivateKey, val uint64) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 	n.tpEnable = true
 	n.tpUnlockedUser = user
 	n.tpUnlockedPrivateKey = priKey
@@ -244,63 +368,193 @@ func (n *Node) EnableTP(user *core.User, priKey *crypto.PrivateKey, val uint64)
 	return nil
 }
 
-// Run the node
+// SetCodec set the codec of waves and messages sent to peers and saved in db,
+// core.CodecJSON is readable for debugging
+func (n *Node) SetCodec(codec string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := db.SetMsgCodec(n.udb, codec); err != nil {
+		return err
+	}
+	n.capabilities = nil
+	for _, c := range galaxy.DefaultCapabilities {
+		if c != galaxy.CapBinary || codec == core.CodecBinary {
+			n.capabilities = append(n.capabilities, c)
+		}
+	}
+	return nil
+}
+
+// EnableSearch enable the full-text search of TypeText messages, the messages
+// saved before are indexed at once
+func (n *Node) EnableSearch() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := db.UpdateSearchIndex(n.udb); err != nil {
+		return err
+	}
+	n.searchEnable = true
+	return nil
+}
+
+// Run the node until signal received
 func (n *Node) Run(c <-chan os.Signal) {
-	sigN, waitN := make(chan struct{}), make(chan struct{})
-	sigTP, waitTP := make(chan struct{}), make(chan struct{})
-	go n.runNode(sigN, waitN)
-	log.Info("Start node server")
-	go n.runLocalServe()
-	log.Info("Start listen on port", n.localPort)
+	if err := n.Start(context.Background()); err != nil {
+		log.Error("Start node fail", err)
+		return
+	}
+	<-c
+	if err := n.Stop(); err != nil {
+		log.Error("Stop node fail", err)
+	}
+}
+
+// Start the node, the incoming connections and local api are served on local port
+// by the mux of node, and the loops of peers and time proof run in background.
+// The node is stopped when ctx is done or Stop is called, and can not be started again.
+func (n *Node) Start(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.quit != nil {
+		return errNodeAlreadyStarted
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", n.localPort))
+	if err != nil {
+		return err
+	}
+	apiHost := DefaultAPIHost
+	if n.exposeAPI {
+		apiHost = ""
+	}
+	apiListener, err := net.Listen("tcp", net.JoinHostPort(apiHost, strconv.FormatUint(n.apiPort, 10)))
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	n.localPort = uint64(listener.Addr().(*net.TCPAddr).Port)
+	n.apiPort = uint64(apiListener.Addr().(*net.TCPAddr).Port)
+	mux := http.NewServeMux()
+	mux.Handle("/"+n.localNodeKey, websocket.Handler(n.wsHandler))
+	n.server = &http.Server{Handler: mux}
+	apiMux := http.NewServeMux()
+	apiMux.HandleFunc("/node", n.nodeHandler)
+	n.apiServer = &http.Server{Handler: apiMux}
+	n.quit = make(chan struct{})
+	n.done = make(chan struct{})
+	n.standardLoopCnt = make(map[common.Hash]uint64)
 
+	n.serve(n.server, listener)
+	log.Info("Start listen on port", n.localPort)
+	n.serve(n.apiServer, apiListener)
+	log.Info("Start local api on", apiListener.Addr())
+	n.wg.Add(1)
+	go n.runNode()
+	log.Info("Start node server")
 	if n.tpEnable {
-		go n.runTimeProof(sigTP, waitTP)
+		n.wg.Add(1)
+		go n.runTimeProof()
 		log.Info("Start time proof server")
 	}
-
-	for {
+	go func(quit <-chan struct{}) {
 		select {
-		case <-c:
-			close(sigN)
-			close(sigTP)
+		case <-ctx.Done():
+			n.Stop()
+		case <-quit:
+		}
+	}(n.quit)
+	return nil
+}
 
-			if n.tpEnable {
-				<-waitTP
-			}
+// serve the server on listener until the server is shutdown
+func (n *Node) serve(server *http.Server, listener net.Listener) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Error("Local serve fail", err)
+		}
+	}()
+}
 
-			<-waitN
-			log.Info("Stop node")
-			return
+// Stop the node gracefully, the local serve is shutdown, the connections of peers
+// are closed, and it returns after all the goroutines of node stopped.
+func (n *Node) Stop() error {
+	if err := n.setStopped(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+	defer cancel()
+	err := n.server.Shutdown(ctx)
+	if apiErr := n.apiServer.Shutdown(ctx); err == nil {
+		err = apiErr
+	}
 
-		}
+	n.closeConns()
+	n.wg.Wait()
+	close(n.done)
+	log.Info("Stop node")
+	return err
+}
+
+// setStopped mark the node stopped and notify the goroutines to quit
+func (n *Node) setStopped() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.quit == nil || n.stopped {
+		return errNodeNotStarted
 	}
+	n.stopped = true
+	close(n.quit)
+	return nil
 }
 
-func (n Node) nodeHandler(w http.ResponseWriter, r *http.Request) {
-	// todo: w.Write return the basic information of local node
-	switch r.Method {
-	case "GET":
-		w.Write([]byte(r.Method))
-	case "POST":
-		w.Write([]byte(r.Method))
-	default:
-		w.Write([]byte(""))
+// closeConns close the connections of peers, incoming connections are hijacked
+// by websocket, which are not closed by shutdown of server
+func (n *Node) closeConns() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, p := range n.peers {
+		p.Close()
+	}
+	for ws := range n.wsPeers {
+		ws.Close()
 	}
 }
 
-func (n *Node) runLocalServe() {
-	http.Handle("/"+n.localNodeKey, websocket.Handler(n.wsHandler))
-	http.HandleFunc("/node", n.nodeHandler)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", n.localPort), nil); err != nil {
-		log.Error("Start local ws serve fail", err)
+// Done return the channel closed after node stopped, nil if node not started
+func (n *Node) Done() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.done
+}
+
+// Snapshot return the snapshot of current universe in node
+func (n *Node) Snapshot() (*core.Snapshot, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.universe == nil {
+		return nil, errUniverseNotExist
 	}
+	return n.universe.Snapshot(n.msgSaved)
+}
+
+// LocalPeer return the peer of local node, which can be added into other nodes
+func (n *Node) LocalPeer() *peer.Peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.localPeer()
+}
+
+// APIURL return the url of local api of node, which can be used by Client
+func (n *Node) APIURL() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return fmt.Sprintf("http://%s/node", net.JoinHostPort(DefaultAPIHost, strconv.FormatUint(n.apiPort, 10)))
 }
 
 func (n *Node) removePeer(k common.Hash) {
 	// remove fail conn from n.peers
 	delete(n.peers, k)
-	//
-	delete(n.peerSyncCnt, k)
 	// remove fail conn from db
 	n.udb.Del(db.BucketPeer, common.Hash2String(k))
 }
@@ -324,21 +578,23 @@ func (n *Node) checkRecord() {
 			n.delRecord(waveID, galaxy.CmdQuestion)
 		}
 	}
+	if cnt := n.orphans.expire(time.Now()); cnt > 0 {
+		log.Info("Orphan messages expired", cnt)
+	}
+	n.absentMsgs.expire(time.Now())
+	if stats := n.orphans.Stats(); stats.Count > 0 {
+		log.Info("Orphan messages", stats.Count, "missing references", stats.Missing)
+	}
 }
 
-func (n *Node) standardLoop(chanWave chan<- galaxy.Wave, chanWSig chan<- common.Hash) {
+// standardLoop ask the connected peers, and return the copy of peers which
+// not connected, they are dialed after the lock released.
+func (n *Node) standardLoop() map[common.Hash]*peer.Peer {
+	dials := make(map[common.Hash]*peer.Peer)
 	for k, p := range n.peers {
 		if !p.Connected() {
-			if err := p.Dial(); err != nil {
-				log.Error(err)
-				n.removePeer(k)
-				continue
-			}
-			if err := n.askPeers(k); err != nil {
-				log.Error(err)
-				continue
-			}
-			go n.serveReceiveWave(p.Conn, k, chanWave, chanWSig)
+			cp := *p
+			dials[k] = &cp
 		} else {
 			if loopCnt, ok := n.standardLoopCnt[k]; !ok || loopCnt >= maxPeerLoopCnt {
 				n.standardLoopCnt[k] = 0
@@ -350,8 +606,24 @@ func (n *Node) standardLoop(chanWave chan<- galaxy.Wave, chanWSig chan<- common.
 				continue
 			}
 
+			// nothing sync before version handshake success
+			if !p.Handshaked() {
+				continue
+			}
+
+			// challenge the user claimed by peer, universe is needed
+			if n.standardLoopCnt[k] == 1 && n.needChallenge(p) {
+				if err := n.askUser(k); err != nil {
+					log.Error(err)
+					continue
+				}
+			}
+
 			// get roots if universe not exist, so break if not err
 			if n.initStep < db.StepRootsSaved {
+				if !p.HasCapability(galaxy.CapRoots) {
+					continue
+				}
 				if err := n.askRoots(k); err != nil {
 					log.Error(err)
 					continue
@@ -359,120 +631,290 @@ func (n *Node) standardLoop(chanWave chan<- galaxy.Wave, chanWSig chan<- common.
 				break // done for this loop
 			}
 
-			// sync from peers,
-			if n.standardLoopCnt[k] == 1 {
+			// sync from all peers at same time, start from the tips of peer
+			if n.standardLoopCnt[k] == 1 && p.HasCapability(galaxy.CapMessages) && (p.Verified || !n.msgVerifiedOnly) {
 				log.Trace("Start to sync from other peer ")
-				n.peerSyncCnt[k] = syncMsgLoopCnt
-				if err := n.askMsg(k); err != nil {
+				if err := n.askTips(k); err != nil {
 					log.Error(err)
 					continue
 				}
-			} else {
-				n.peerSyncCnt[k] = 0
 			}
 
 		}
 	}
+	return dials
 }
 
-func (n *Node) runNode(sig <-chan struct{}, wait chan<- struct{}) {
-	// run node
+// connectPeers apply the connections dialed without lock, the peer fail to
+// dial is removed, version handshake is started on the new connection.
+func (n *Node) connectPeers(conns map[common.Hash]*websocket.Conn, chanWave chan<- galaxy.Wave, chanWSig chan<- common.Hash) {
+	n.mu.Lock()
+	defer n.unlock()
+	for k, conn := range conns {
+		// node stopped or peer changed while dialing
+		if p, ok := n.peers[k]; n.stopped || !ok || p.Connected() {
+			if conn != nil {
+				conn.Close()
+			}
+			continue
+		}
+		if conn == nil {
+			n.removePeer(k)
+			continue
+		}
+		n.peers[k].Conn = conn
+		// version handshake first, peers will be asked after handshake success
+		if err := n.askVersion(k); err != nil {
+			log.Error(err)
+			continue
+		}
+		n.wg.Add(1)
+		go n.serveReceiveWave(conn, k, chanWave, chanWSig)
+	}
+}
+
+// needChallenge return true if the peer claim an user which not verified yet
+func (n *Node) needChallenge(p *peer.Peer) bool {
+	return !p.Verified && p.UserID != common.Hash{} && n.universe != nil
+}
+
+// verifiedPeer return true if the wave is response from verified peer,
+// the wave from ws connection is never verified
+func (n *Node) verifiedPeer(ws *websocket.Conn, waveID common.Hash) bool {
+	if ws != nil {
+		return false
+	}
+	if r, ok := n.questionRecord[waveID]; ok {
+		if p, ok := n.peers[r.pid]; ok {
+			return p.Verified
+		}
+	}
+	return false
+}
+
+func (n *Node) runNode() {
+	defer n.wg.Done()
 	chanWave := make(chan galaxy.Wave)
 	chanWSig := make(chan common.Hash)
-	n.standardLoopCnt = make(map[common.Hash]uint64)
 
 	for {
 		select {
-		case <-time.After(time.Second * time.Duration(checkPeerInterval)):
+		case <-time.After(n.checkInterval):
 			log.Info("Update information from peers")
-			n.checkRecord()
-			n.standardLoop(chanWave, chanWSig)
+			n.checkPeers(chanWave, chanWSig)
 		case k := <-chanWSig:
-			n.removePeer(k)
-		case <-sig:
+			n.removeFailPeer(k)
+		case <-n.quit:
 			log.Info("Stop server")
-			close(wait)
 			return
 		case w := <-chanWave:
-			waveID, err := n.handleWave(nil, w, true)
-			if err != nil {
-				//log.Trace("Peer handler fail", err, "waveID", common.Hash2String(waveID))
-			} else if w.Command() == galaxy.CmdMessages {
-				n.reAskMsg(waveID)
-			}
-			n.delRecord(waveID, w.Command())
+			n.handlePeerWave(w)
 		}
 	}
 }
 
-func (n *Node) runTimeProof(sig <-chan struct{}, wait chan<- struct{}) {
+// checkPeers check the records and update information from peers, the peers
+// not connected are dialed without lock, so slow peer not block the node.
+func (n *Node) checkPeers(chanWave chan galaxy.Wave, chanWSig chan common.Hash) {
+	conns := make(map[common.Hash]*websocket.Conn)
+	for k, p := range n.loopPeers() {
+		if err := p.Dial(); err != nil {
+			log.Error(err)
+		}
+		conns[k] = p.Conn
+	}
+	if len(conns) > 0 {
+		n.connectPeers(conns, chanWave, chanWSig)
+	}
+}
+
+// loopPeers run the standard loop, return the peers need to dial
+func (n *Node) loopPeers() map[common.Hash]*peer.Peer {
+	n.mu.Lock()
+	defer n.unlock()
+	if n.stopped {
+		return nil
+	}
+	n.checkRecord()
+	return n.standardLoop()
+}
+
+// removeFailPeer remove the peer whose connection is failed
+func (n *Node) removeFailPeer(k common.Hash) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.removePeer(k)
+}
+
+// handlePeerWave handle the wave received from peers, and delete its record
+func (n *Node) handlePeerWave(w galaxy.Wave) {
+	n.mu.Lock()
+	defer n.unlock()
+	// the record is deleted even if the wave is not valid
+	waveID, _ := n.handleWave(nil, w, true)
+	n.delRecord(waveID, w.Command())
+}
+
+func (n *Node) runTimeProof() {
+	defer n.wg.Done()
 	for {
 		select {
-		case <-sig:
+		case <-n.quit:
 			log.Info("Stop time proof server")
-			close(wait)
 			return
 		case <-time.After(time.Second * time.Duration(n.tpInterval)):
-			var refs []*core.MsgReference
-			// load last msg in universe
-			lastMsg, err := db.GetLastMsg(n.udb)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			refs = append(refs, &core.MsgReference{SenderID: lastMsg.SenderID, MsgID: lastMsg.ID()})
-			// load last msg from unlock user if exist
-			lastMsgByUser, err := db.GetLastMsgByUser(n.udb, n.tpUnlockedUser.ID())
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			if lastMsg.ID() != lastMsgByUser.ID() {
-				refs = append(refs, &core.MsgReference{SenderID: lastMsgByUser.SenderID, MsgID: lastMsgByUser.ID()})
-			}
-			// create new msg, use 1.2 as reference
-			tpMsgValue := &core.MsgValue{ContentType: core.TypeText, Content: []byte(strconv.Itoa(rand.Intn(100000)))}
-			tpMsg, err := core.CreateMsg(n.tpUnlockedUser, tpMsgValue, n.tpUnlockedPrivateKey, refs...)
+			tpMsg, err := n.timeProof()
 			if err != nil {
 				log.Error(err)
 				continue
 			}
-			// save msg into udb,
-			if err := n.saveMsg(tpMsg); err != nil {
-				log.Error(err)
-				continue
-			}
-			// 5. broadcast the new msg
-			if err := n.broadcastMsg(tpMsg); err != nil {
-				log.Error(err)
-				continue
-			}
-
 			log.Info("A new message", common.Hash2String(tpMsg.ID()), "just be created and broadcast")
 		}
 	}
 }
 
-func (n Node) broadcastMsg(msg *core.Message) error {
-	for _, p := range n.peers {
-		if !p.Connected() {
-			continue
-		}
+// timeProof create the time proof msg by unlocked user, which reference the
+// last msg in universe and the last msg of user, then save and broadcast it
+func (n *Node) timeProof() (*core.Message, error) {
+	n.mu.Lock()
+	defer n.unlock()
+	var refs []*core.MsgReference
+	// load last msg in universe
+	lastMsg, err := db.GetLastMsg(n.udb)
+	if err != nil {
+		return nil, err
+	}
+	refs = append(refs, &core.MsgReference{SenderID: lastMsg.SenderID, MsgID: lastMsg.ID()})
+	// load last msg from unlock user if exist
+	lastMsgByUser, err := db.GetLastMsgByUser(n.udb, n.tpUnlockedUser.ID())
+	if err != nil && err != db.ErrMessageNotFound {
+		return nil, err
+	}
+	if lastMsgByUser != nil && lastMsg.ID() != lastMsgByUser.ID() {
+		refs = append(refs, &core.MsgReference{SenderID: lastMsgByUser.SenderID, MsgID: lastMsgByUser.ID()})
+	}
+	// create new msg, use 1.2 as reference
+	tpMsgValue := &core.MsgValue{ContentType: core.TypeText, Content: []byte(strconv.Itoa(rand.Intn(100000)))}
+	tpMsg, err := core.CreateMsg(n.tpUnlockedUser, tpMsgValue, n.tpUnlockedPrivateKey, refs...)
+	if err != nil {
+		return nil, err
+	}
+	// save msg into udb,
+	if err := n.saveMsg(tpMsg); err != nil {
+		return nil, err
+	}
+	// broadcast the new msg
+	n.broadcastMsg(tpMsg)
+	return tpMsg, nil
+}
+
+// broadcastMsg queue the msg to all connected peers, sent after lock released
+func (n *Node) broadcastMsg(msg *core.Message) {
+	for k, p := range n.peers {
+		n.queueWave(k, p, func(p *peer.Peer) error {
+			return p.SendMsg(common.CreateHash(), msg)
+		})
+	}
+}
 
-		if err := p.SendMsg(common.CreateHash(), msg); err != nil {
+func (n *Node) saveMsg(msg *core.Message) error {
+	if err := n.universe.AddMsg(msg); err != nil {
+		return err
+	}
+	n.absentMsgs.remove(msg.ID())
+	if msg.Value.ContentType == core.TypeBirth {
+		user, err := n.userByMsg(msg)
+		if err != nil {
 			return err
 		}
+		if err := db.SaveBirthMsg(n.udb, msg, user); err != nil {
+			return err
+		}
+	} else if err := db.SaveMsg(n.udb, msg); err != nil {
+		return err
 	}
-	return nil
+	if n.searchEnable {
+		if err := db.UpdateSearchIndex(n.udb); err != nil {
+			return err
+		}
+	}
+	return n.checkSnapshot()
 }
 
-func (n Node) saveMsg(msg *core.Message) error {
-	if err := n.universe.AddMsg(msg); err != nil {
+// checkSnapshot save the snapshot of universe every snapshotInterval messages
+func (n *Node) checkSnapshot() error {
+	if n.snapshotInterval == 0 {
+		return nil
+	}
+	msgCount, err := db.GetMsgCount(n.udb)
+	if err != nil {
 		return err
 	}
-	if err := db.SaveMsg(n.udb, msg); err != nil {
+	if msgCount.Uint64()%n.snapshotInterval != 0 {
+		return nil
+	}
+	snapshot, err := n.universe.Snapshot(n.msgSaved)
+	if err == core.ErrSnapshotMsgNotSaved {
+		log.Warn("Snapshot skipped with", msgCount, "messages", err)
+		return nil
+	} else if err != nil {
 		return err
 	}
+	if err := db.SaveSnapshot(n.udb, snapshot, msgCount.Uint64()); err != nil {
+		return err
+	}
+	log.Info("Snapshot saved with", msgCount, "messages")
+	return nil
+}
+
+// msgSaved return true if the msg is saved in udb
+func (n *Node) msgSaved(msgID common.Hash) (bool, error) {
+	return db.HasMsg(n.udb, msgID)
+}
+
+// loadSnapshot rebuild the universe from the latest snapshot, return the
+// count of messages already contained in the universe.
+func (n *Node) loadSnapshot() (uint64, error) {
+	snapshot, order, err := db.GetSnapshot(n.udb)
+	if err != nil {
+		return 0, err
+	}
+	universe, err := core.NewUniverseFromSnapshot(snapshot, func(msgID common.Hash) (*core.Message, error) {
+		return db.GetMsgByID(n.udb, msgID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	n.universe = universe
+	return order, nil
+}
+
+// userByMsg return the user just created by birth msg, which should be in universe
+func (n *Node) userByMsg(msg *core.Message) (*core.User, error) {
+	user, err := core.CreateNewUser(n.universe, msg)
+	if err != nil {
+		return nil, err
+	}
+	if n.universe.GetUserByID(user.ID()) == nil {
+		return nil, core.ErrUserNotExist
+	}
+	return user, nil
+}
+
+// rebuildUsers save the users in universe but missing in udb, the users
+// created before user be persisted by birth msg can be found after rebuild.
+func (n *Node) rebuildUsers() error {
+	for _, userID := range n.universe.GetAllUserIDs() {
+		if _, err := db.GetUserByID(n.udb, userID); err == nil {
+			continue
+		} else if err != db.ErrUserNotFound {
+			return err
+		}
+		if err := db.SaveUser(n.udb, n.universe.GetUserByID(userID)); err != nil {
+			return err
+		}
+		log.Info("User rebuild", common.Hash2String(userID))
+	}
 	return nil
 }
 
@@ -492,17 +934,47 @@ func (n *Node) loadUniverse() (err error) {
 	}
 	// update init step
 	n.initStep = db.StepRootsSaved
+	n.setRoots(user0.ID(), user1.ID())
 	log.Info("root0", common.Hash2String(user0.ID()))
 	log.Info("root1", common.Hash2String(user1.ID()))
-	n.universe, err = core.NewUniverse(user0, user1)
+	msgCount, err := db.GetMsgCount(n.udb)
 	if err != nil {
 		return err
 	}
-	msgCount, err := db.GetMsgCount(n.udb)
+	// load from snapshot and replay the messages after it,
+	// replay all messages if snapshot not exist or corrupted
+	start, err := n.loadSnapshot()
+	switch {
+	case err == db.ErrSnapshotNotFound:
+	case err != nil:
+		log.Error("Load snapshot fail", err)
+	case start > msgCount.Uint64():
+		log.Error("Snapshot with", start, "messages is more than saved", msgCount)
+	default:
+		log.Info("Snapshot loaded with", start, "messages")
+		if err = n.replayMsgs(start, msgCount.Uint64()); err == nil {
+			log.Info("All", msgCount, "messages already be loaded")
+			return n.rebuildUsers()
+		}
+		log.Error("Replay messages after snapshot fail", err)
+	}
+	if err != db.ErrSnapshotNotFound {
+		log.Warn("Snapshot not used, replay all", msgCount, "messages")
+	}
+	n.universe, err = core.NewUniverse(user0, user1)
 	if err != nil {
 		return err
 	}
-	for i := uint64(0); i < msgCount.Uint64(); i++ {
+	if err := n.replayMsgs(0, msgCount.Uint64()); err != nil {
+		return err
+	}
+	log.Info("All", msgCount, "messages already be loaded")
+	return n.rebuildUsers()
+}
+
+// replayMsgs add the messages from start to end (by order) into universe
+func (n *Node) replayMsgs(start, end uint64) error {
+	for i := start; i < end; i++ {
 		// todo : replace by db.GetMsgByOrder()
 		mid, err := n.udb.Get(db.BucketMID, new(big.Int).SetUint64(i).String())
 		if err != nil {
@@ -512,13 +984,12 @@ func (n *Node) loadUniverse() (err error) {
 		if err != nil {
 			return err
 		}
-		var msg core.Message
-		err = json.Unmarshal(msgBytes, &msg)
+		msg, err := core.UnmarshalMsg(msgBytes)
 		if err != nil {
 			return err
 		}
 
-		err = n.universe.AddMsg(&msg)
+		err = n.universe.AddMsg(msg)
 		if err != nil {
 			return err
 		}
@@ -526,11 +997,10 @@ func (n *Node) loadUniverse() (err error) {
 			log.Info("message ", i+1, "be loaded", common.Hash2String(msg.ID()))
 		}
 	}
-	log.Info("All", msgCount, "messages already be loaded")
 	return nil
 }
 
-func (n Node) localPeer() *peer.Peer {
+func (n *Node) localPeer() *peer.Peer {
 	localPeer := &peer.Peer{IP: localIPAddress, Port: n.localPort, NodeKey: n.localNodeKey}
 	if n.tpUnlockedUser != nil {
 		localPeer.UserID = n.tpUnlockedUser.ID()
@@ -0,0 +1,364 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/core/coretest"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memory"
+	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/peer"
+)
+
+// createTestUniverse create the root users in order of female and male, and the first msg
+func createTestUniverse(t *testing.T) ([]*core.User, []*crypto.PrivateKey, *core.Message) {
+	users, priKeys := coretest.RootUsers(t)
+	msg, err := core.CreateMsg(users[0], &core.MsgValue{ContentType: core.TypeText, Content: []byte("first")}, priKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users, priKeys, msg
+}
+
+// newTestNode create node on memory db, with the universe already created,
+// only root users are in universe if msg is nil
+func newTestNode(t *testing.T, users []*core.User, msg *core.Message, cfg *Config) *Node {
+	udb := memory.NewDB()
+	for _, bucket := range db.Buckets {
+		if err := udb.CreateBucket(bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(0).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveRootUsers(udb, users); err != nil {
+		t.Fatal(err)
+	}
+	if msg != nil {
+		if err := db.SaveMsg(udb, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := New(udb, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// testConfig return the config of node on any free ports, which check peers frequently
+func testConfig(user *core.User, priKey *crypto.PrivateKey) *Config {
+	cfg := DefaultConfig()
+	cfg.LocalPort, cfg.APIPort = 0, 0
+	cfg.CheckInterval = 50 * time.Millisecond
+	cfg.TPUser, cfg.TPPrivateKey = user, priKey
+	return cfg
+}
+
+// hasMsgs return true if all msgs are in the universe of node
+func hasMsgs(n *Node, msgIDs ...common.Hash) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, id := range msgIDs {
+		if n.universe.GetMsgByID(id) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// lastMsgID return the ID of last msg of user in node, empty if not exist
+func lastMsgID(t *testing.T, n *Node, userID common.Hash) common.Hash {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	msg, err := db.GetLastMsgByUser(n.udb, userID)
+	if err == db.ErrMessageNotFound {
+		return common.Hash{}
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return msg.ID()
+}
+
+func TestNode_Concurrent(t *testing.T) {
+	users, priKeys, first := createTestUniverse(t)
+	n0 := newTestNode(t, users, first, testConfig(users[0], priKeys[0]))
+	n1 := newTestNode(t, users, first, testConfig(users[1], priKeys[1]))
+	// the auth of user share the public key with private key, which is changed
+	// when signing by the node, so the user is not read after node started
+	userIDs := []common.Hash{users[0].ID(), users[1].ID()}
+	for _, n := range []*Node{n0, n1} {
+		if err := n.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// msgs from incoming connection are dropped, so each node dial the other
+	if err := n0.AddPeer(n1.LocalPeer()); err != nil {
+		t.Fatal(err)
+	}
+	if err := n1.AddPeer(n0.LocalPeer()); err != nil {
+		t.Fatal(err)
+	}
+	apiURL := n0.APIURL()
+
+	// local api is requested at same time
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, method := range []string{MethodNodeInfo, MethodPeers, MethodSpaceTimes} {
+		wg.Add(1)
+		go func(method string) {
+			defer wg.Done()
+			reqBytes, _ := json.Marshal(&RPCRequest{JSONRPC: JSONRPCVersion, ID: json.RawMessage("1"), Method: method})
+			for {
+				select {
+				case <-done:
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+				res, err := http.Post(apiURL, "application/json", bytes.NewReader(reqBytes))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				res.Body.Close()
+				n0.OrphanStats()
+			}
+		}(method)
+	}
+
+	// time proof msgs of each node reach the other one
+	converged := false
+	for deadline := time.Now().Add(20 * time.Second); time.Now().Before(deadline) && !converged; time.Sleep(100 * time.Millisecond) {
+		msgID0, msgID1 := lastMsgID(t, n0, userIDs[0]), lastMsgID(t, n1, userIDs[1])
+		converged = msgID0 != first.ID() && msgID1 != common.Hash{} && hasMsgs(n1, msgID0) && hasMsgs(n0, msgID1)
+	}
+	if !converged {
+		t.Error("time proof msgs not synced between nodes")
+	}
+
+	close(done)
+	wg.Wait()
+	for _, n := range []*Node{n0, n1} {
+		if err := n.Stop(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestNode_StartStop(t *testing.T) {
+	users, _, first := createTestUniverse(t)
+	n0 := newTestNode(t, users, first, testConfig(nil, nil))
+	n1 := newTestNode(t, users, first, testConfig(nil, nil))
+	if err := n0.Stop(); err != errNodeNotStarted {
+		t.Errorf("err should be %s, but now err : %v", errNodeNotStarted, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := n0.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := n0.Start(ctx); err != errNodeAlreadyStarted {
+		t.Errorf("err should be %s, but now err : %v", errNodeAlreadyStarted, err)
+	}
+	if err := n1.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := n1.AddPeer(n0.LocalPeer()); err != nil {
+		t.Fatal(err)
+	}
+	// wait the handshake of peer, and each node serve its own api
+	handshaked := false
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline) && !handshaked; time.Sleep(50 * time.Millisecond) {
+		var peers []*PeerInfo
+		client := NewClient(n1.APIURL())
+		if err := client.Call(&peers, MethodPeers); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range peers {
+			handshaked = handshaked || p.Handshaked
+		}
+	}
+	if !handshaked {
+		t.Error("peer should be handshaked")
+	}
+	var info NodeInfo
+	if err := NewClient(n0.APIURL()).Call(&info, MethodNodeInfo); err != nil {
+		t.Fatal(err)
+	}
+	if info.NodeKey != n0.localNodeKey {
+		t.Error("node info should be served by its own node")
+	}
+
+	// n0 is stopped by ctx, with the incoming connection from n1
+	port := n0.LocalPeer().Port
+	cancel()
+	select {
+	case <-n0.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("node should be stopped by ctx")
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		t.Error("port should be released after stop", err)
+	} else {
+		listener.Close()
+	}
+	if err := n1.Stop(); err != nil {
+		t.Error(err)
+	}
+	if err := n1.Stop(); err != errNodeNotStarted {
+		t.Errorf("err should be %s, but now err : %v", errNodeNotStarted, err)
+	}
+}
+
+// nonLoopbackIP return any ip of local interfaces which is not loopback, nil if not exist
+func nonLoopbackIP() net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP
+		}
+	}
+	return nil
+}
+
+func TestNode_APIListen(t *testing.T) {
+	ip := nonLoopbackIP()
+	if ip == nil {
+		t.Skip("no interface except loopback")
+	}
+	users, _, first := createTestUniverse(t)
+	for _, expose := range []bool{false, true} {
+		cfg := testConfig(nil, nil)
+		cfg.ExposeAPI = expose
+		n := newTestNode(t, users, first, cfg)
+		if err := n.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewClient(n.APIURL()).Call(nil, MethodNodeInfo); err != nil {
+			t.Error("local api should be served on loopback", err)
+		}
+		localPort, apiPort := func() (uint64, uint64) {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			return n.localPort, n.apiPort
+		}()
+		// incoming connections of peers are always accepted on all interfaces
+		for port, served := range map[uint64]bool{localPort: true, apiPort: expose} {
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), fmt.Sprint(port)), time.Second)
+			if err == nil {
+				conn.Close()
+			}
+			if served != (err == nil) {
+				t.Errorf("port %d on %s should be served %t, expose api %t", port, ip, served, expose)
+			}
+		}
+		if err := n.Stop(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestNode_SlowPeer(t *testing.T) {
+	users, _, first := createTestUniverse(t)
+	n := newTestNode(t, users, first, testConfig(nil, nil))
+	// the peer accept connection but never response, so the dial is blocked
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	p, err := peer.New("127.0.0.1", uint64(listener.Addr().(*net.TCPAddr).Port), "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.mu.Lock()
+	err = n.addPeer(p)
+	n.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked := make(chan struct{})
+	go func() {
+		n.checkPeers(make(chan galaxy.Wave), make(chan common.Hash))
+		close(checked)
+	}()
+	conn := <-accepted
+
+	// the node is available while dialing
+	called := make(chan error, 1)
+	go func() {
+		_, err := n.callAPI((*Node).apiNodeInfo, nil)
+		called <- err
+	}()
+	select {
+	case err := <-called:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("node should not be blocked by dialing slow peer")
+	}
+
+	// the peer fail to dial is removed
+	listener.Close()
+	conn.Close()
+	<-checked
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.peers[p.ID()]; ok {
+		t.Error("peer fail to dial should be removed")
+	}
+}
+
+func TestNode_HandleRootsMissingUser(t *testing.T) {
+	users, _, _ := createTestUniverse(t)
+	n := newTestNode(t, users, nil, testConfig(nil, nil))
+	// node still waiting for the roots from peer
+	n.initStep = db.StepInitDB
+	for _, roots := range [][2]*core.User{{users[0], nil}, {nil, users[1]}, {}} {
+		if _, err := n.handleRoots(nil, &galaxy.WaveRoots{Users: roots}); err != errRootsNotValid {
+			t.Error("roots missing user should not be valid", err)
+		}
+	}
+	if n.initStep != db.StepInitDB {
+		t.Error("init step should not be changed", n.initStep)
+	}
+}
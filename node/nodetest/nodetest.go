@@ -0,0 +1,223 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package nodetest provides the in-process network of nodes for integration test.
+// The nodes run on loopback with memory db, each node dial the others through
+// proxies, so the links between nodes can be cut or delayed by test.
+package nodetest
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/core/coretest"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/db/memory"
+	"github.com/pdupub/go-pdu/node"
+	"github.com/pdupub/go-pdu/peer"
+)
+
+const (
+	// DefaultCheckInterval is the default interval of nodes checking peers
+	DefaultCheckInterval = 100 * time.Millisecond
+
+	pollInterval = 50 * time.Millisecond
+	loopbackIP   = "127.0.0.1"
+)
+
+// Config is the config of network
+type Config struct {
+	// Nodes is the number of nodes
+	Nodes int
+
+	// TimeProof is the index of nodes which run time proof, the root users are
+	// used in order, so at most two nodes, each root user run on one node only
+	TimeProof []int
+
+	// TPInterval is the seconds between time proof messages
+	TPInterval uint64
+
+	// CheckInterval is the interval of nodes checking peers, DefaultCheckInterval if 0
+	CheckInterval time.Duration
+
+	// Codec of waves and messages of nodes, core.CodecBinary if empty
+	Codec string
+}
+
+// link is the connection dialed by node from to node to
+type link struct {
+	from, to int
+}
+
+// Network is the nodes running in process, the universe is created by the
+// generated root users, with the first message from Users[0].
+type Network struct {
+	Users   []*core.User
+	PriKeys []*crypto.PrivateKey
+	Nodes   []*node.Node
+
+	udbs    []db.UDB
+	proxies map[link]*proxy
+}
+
+// New create the universe, start the nodes and wire the peers of each other
+func New(t testing.TB, cfg *Config) *Network {
+	t.Helper()
+	if len(cfg.TimeProof) > 2 {
+		t.Fatal("time proof can run on two nodes at most")
+	}
+	nw := &Network{proxies: make(map[link]*proxy)}
+	first, err := nw.createUniverse(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < cfg.Nodes; i++ {
+		nodeCfg := node.DefaultConfig()
+		nodeCfg.LocalPort, nodeCfg.APIPort = 0, 0
+		nodeCfg.Codec = cfg.Codec
+		// peers are only wired by network, never exchanged out of proxies
+		nodeCfg.PeersVerifiedOnly = true
+		nodeCfg.CheckInterval = cfg.CheckInterval
+		if nodeCfg.CheckInterval == 0 {
+			nodeCfg.CheckInterval = DefaultCheckInterval
+		}
+		for k, idx := range cfg.TimeProof {
+			if idx == i {
+				nodeCfg.TPUser, nodeCfg.TPPrivateKey, nodeCfg.TPInterval = nw.Users[k], nw.PriKeys[k], cfg.TPInterval
+			}
+		}
+		if err := nw.startNode(nodeCfg, first); err != nil {
+			nw.Close()
+			t.Fatal(err)
+		}
+	}
+	for i := range nw.Nodes {
+		for j := range nw.Nodes {
+			if i == j {
+				continue
+			}
+			p, err := newProxy(net.JoinHostPort(loopbackIP, strconv.FormatUint(nw.Nodes[j].LocalPeer().Port, 10)))
+			if err != nil {
+				nw.Close()
+				t.Fatal(err)
+			}
+			nw.proxies[link{i, j}] = p
+		}
+	}
+	nw.wire()
+	return nw
+}
+
+// createUniverse generate the root users in order of female and male, and the first message
+func (nw *Network) createUniverse(t testing.TB) (*core.Message, error) {
+	nw.Users, nw.PriKeys = coretest.RootUsers(t)
+	return core.CreateMsg(nw.Users[0], &core.MsgValue{ContentType: core.TypeText, Content: []byte("first")}, nw.PriKeys[0])
+}
+
+// startNode create the memory db contain the universe, and start node on it
+func (nw *Network) startNode(cfg *node.Config, first *core.Message) error {
+	udb := memory.NewDB()
+	nw.udbs = append(nw.udbs, udb)
+	for _, bucket := range db.Buckets {
+		if err := udb.CreateBucket(bucket); err != nil {
+			return err
+		}
+	}
+	if err := udb.Set(db.BucketConfig, db.ConfigMsgCount, big.NewInt(0).Bytes()); err != nil {
+		return err
+	}
+	if err := db.SetSchemaVersion(udb, db.SchemaVersion); err != nil {
+		return err
+	}
+	if err := db.SaveRootUsers(udb, nw.Users); err != nil {
+		return err
+	}
+	if err := db.SaveMsg(udb, first); err != nil {
+		return err
+	}
+	n, err := node.New(udb, cfg)
+	if err != nil {
+		return err
+	}
+	if err := n.Start(context.Background()); err != nil {
+		return err
+	}
+	nw.Nodes = append(nw.Nodes, n)
+	return nil
+}
+
+// wire add the peers through proxies into each node, the peer removed by
+// node after connection lost is added again
+func (nw *Network) wire() {
+	for l, p := range nw.proxies {
+		target, err := peer.New(loopbackIP, p.port(), nw.Nodes[l.to].LocalPeer().NodeKey)
+		if err != nil {
+			continue
+		}
+		// fail if the peer still exist
+		nw.Nodes[l.from].AddPeer(target)
+	}
+}
+
+// Partition cut the links between the groups of nodes by index, the node
+// not in any group is isolated from all the others.
+func (nw *Network) Partition(groups ...[]int) {
+	group := make(map[int]int)
+	for g, nodes := range groups {
+		for _, i := range nodes {
+			group[i] = g + 1
+		}
+	}
+	for l, p := range nw.proxies {
+		gFrom, okFrom := group[l.from]
+		gTo, okTo := group[l.to]
+		p.setCut(!okFrom || !okTo || gFrom != gTo)
+	}
+}
+
+// Heal restore all the links cut by Partition
+func (nw *Network) Heal() {
+	for _, p := range nw.proxies {
+		p.setCut(false)
+	}
+	nw.wire()
+}
+
+// Delay the data in both direction of the connection dialed by node from to node to
+func (nw *Network) Delay(from, to int, delay time.Duration) {
+	if p, ok := nw.proxies[link{from, to}]; ok {
+		p.setDelay(delay)
+	}
+}
+
+// Close stop all nodes and proxies
+func (nw *Network) Close() {
+	for _, n := range nw.Nodes {
+		n.Stop()
+	}
+	for _, p := range nw.proxies {
+		p.close()
+	}
+	for _, udb := range nw.udbs {
+		udb.Close()
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package nodetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+const convergeTimeout = 30 * time.Second
+
+// lastMsgID return the newest msg by time sequence in the spacetime of first root user
+func lastMsgID(t *testing.T, nw *Network, i int) common.Hash {
+	s, err := nw.State(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last *core.SnapshotTimeProof
+	for _, st := range s.SpaceTimes {
+		for _, tp := range st.TimeProofs {
+			if last == nil || tp.Sequence > last.Sequence {
+				last = tp
+			}
+		}
+	}
+	if last == nil {
+		t.Fatal("time proof not found")
+	}
+	return last.MsgID
+}
+
+func TestNetwork_Converge(t *testing.T) {
+	for _, codec := range []string{core.CodecBinary, core.CodecJSON} {
+		nw := New(t, &Config{Nodes: 3, TimeProof: []int{0, 2}, TPInterval: 1, Codec: codec})
+		nw.AssertConverged(t, 5, convergeTimeout)
+		nw.Close()
+	}
+}
+
+func TestNetwork_Partition(t *testing.T) {
+	nw := New(t, &Config{Nodes: 4, TimeProof: []int{0, 3}, TPInterval: 1})
+	defer nw.Close()
+	nw.AssertConverged(t, 3, convergeTimeout)
+
+	nw.Partition([]int{0, 1}, []int{2, 3})
+	msgID := lastMsgID(t, nw, 0)
+	// new time proof in spacetime of root user 0 only reach the same side
+	for deadline := time.Now().Add(convergeTimeout); lastMsgID(t, nw, 0) == msgID; time.Sleep(pollInterval) {
+		if time.Now().After(deadline) {
+			t.Fatal("time proof not created")
+		}
+	}
+	msgID = lastMsgID(t, nw, 0)
+	nw.AssertConverged(t, 0, convergeTimeout, 0, 1)
+	if !nw.HasMsg(1, msgID) {
+		t.Error("msg should reach the node in same side")
+	}
+	time.Sleep(time.Second)
+	if nw.HasMsg(2, msgID) || nw.HasMsg(3, msgID) {
+		t.Error("msg should not reach the nodes in other side")
+	}
+
+	nw.Heal()
+	nw.AssertConverged(t, 0, convergeTimeout)
+	if !nw.HasMsg(3, msgID) {
+		t.Error("msg should reach all nodes after heal")
+	}
+}
+
+func TestNetwork_Delay(t *testing.T) {
+	nw := New(t, &Config{Nodes: 3, TimeProof: []int{0}, TPInterval: 1})
+	defer nw.Close()
+	for _, to := range []int{1, 2} {
+		nw.Delay(0, to, 100*time.Millisecond)
+		nw.Delay(to, 0, 100*time.Millisecond)
+	}
+	nw.AssertConverged(t, 4, convergeTimeout)
+}
@@ -0,0 +1,169 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package nodetest
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const proxyBufferSize = 32 * 1024
+
+// proxy forward the connections dialed by one node to another node, the link
+// can be cut to make partition, or delayed for each piece of data forwarded.
+type proxy struct {
+	listener net.Listener
+	target   string
+
+	mu    sync.Mutex
+	cut   bool
+	delay time.Duration
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}
+
+func newProxy(target string) (*proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &proxy{listener: listener, target: target, conns: make(map[net.Conn]bool)}
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+// port return the local port of proxy
+func (p *proxy) port() uint64 {
+	return uint64(p.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (p *proxy) serve() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.wg.Add(1)
+		go p.handle(conn)
+	}
+}
+
+// handle forward the connection to target, both side are closed once any side closed
+func (p *proxy) handle(conn net.Conn) {
+	defer p.wg.Done()
+	defer conn.Close()
+	if p.isCut() {
+		return
+	}
+	target, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	if !p.track(conn, target) {
+		return
+	}
+	defer p.untrack(conn, target)
+
+	done := make(chan struct{}, 2)
+	go p.pipe(target, conn, done)
+	go p.pipe(conn, target, done)
+	<-done
+	conn.Close()
+	target.Close()
+	<-done
+}
+
+func (p *proxy) pipe(dst, src net.Conn, done chan<- struct{}) {
+	defer func() { done <- struct{}{} }()
+	buf := make([]byte, proxyBufferSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if delay := p.getDelay(); delay > 0 {
+				time.Sleep(delay)
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// track the connections, false if the link is cut already
+func (p *proxy) track(conns ...net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cut {
+		return false
+	}
+	for _, c := range conns {
+		p.conns[c] = true
+	}
+	return true
+}
+
+func (p *proxy) untrack(conns ...net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range conns {
+		delete(p.conns, c)
+	}
+}
+
+func (p *proxy) isCut() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cut
+}
+
+// setCut cut or restore the link, the connections forwarding are closed when cut
+func (p *proxy) setCut(cut bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cut = cut
+	if cut {
+		for c := range p.conns {
+			c.Close()
+		}
+	}
+}
+
+func (p *proxy) getDelay() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.delay
+}
+
+func (p *proxy) setDelay(delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.delay = delay
+}
+
+// close the proxy and all the connections forwarding
+func (p *proxy) close() {
+	p.listener.Close()
+	p.setCut(true)
+	p.wg.Wait()
+}
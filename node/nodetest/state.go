@@ -0,0 +1,166 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package nodetest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// State is the state of universe in node, contain the msgD, userD and spacetimes,
+// all the lists are sorted, so the states of nodes can be compared directly.
+type State struct {
+	MsgIDs     []common.Hash
+	UserIDs    []common.Hash
+	SpaceTimes []*core.SnapshotSpaceTime
+}
+
+// NewState create the state from the snapshot of universe
+func NewState(snapshot *core.Snapshot) *State {
+	s := &State{MsgIDs: sortHashes(snapshot.MsgIDs), SpaceTimes: snapshot.SpaceTimes}
+	for _, user := range snapshot.Users {
+		s.UserIDs = append(s.UserIDs, user.ID())
+	}
+	sortHashes(s.UserIDs)
+	for _, st := range s.SpaceTimes {
+		sortHashes(st.ParentIDs)
+		sort.Slice(st.TimeProofs, func(i, j int) bool {
+			return lessHash(st.TimeProofs[i].MsgID, st.TimeProofs[j].MsgID)
+		})
+		for _, ui := range st.UserInfos {
+			sortHashes(ui.ParentIDs)
+		}
+		sort.Slice(st.UserInfos, func(i, j int) bool {
+			return lessHash(st.UserInfos[i].UserID, st.UserInfos[j].UserID)
+		})
+	}
+	sort.Slice(s.SpaceTimes, func(i, j int) bool {
+		return lessHash(s.SpaceTimes[i].ID, s.SpaceTimes[j].ID)
+	})
+	return s
+}
+
+// Equal return true if the states are same
+func (s *State) Equal(other *State) bool {
+	return reflect.DeepEqual(s, other)
+}
+
+// String return the summary of state
+func (s *State) String() string {
+	var sts []string
+	for _, st := range s.SpaceTimes {
+		sts = append(sts, fmt.Sprintf("%s:%d", common.Hash2String(st.ID)[:8], st.MaxTimeSequence))
+	}
+	return fmt.Sprintf("msgs %d users %d spacetimes [%s]", len(s.MsgIDs), len(s.UserIDs), strings.Join(sts, " "))
+}
+
+func lessHash(a, b common.Hash) bool {
+	return common.Hash2String(a) < common.Hash2String(b)
+}
+
+func sortHashes(hashes []common.Hash) []common.Hash {
+	sort.Slice(hashes, func(i, j int) bool { return lessHash(hashes[i], hashes[j]) })
+	return hashes
+}
+
+// State return the state of node by index
+func (nw *Network) State(i int) (*State, error) {
+	snapshot, err := nw.Nodes[i].Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return NewState(snapshot), nil
+}
+
+// HasMsg return true if the msg exist in the universe of node
+func (nw *Network) HasMsg(i int, msgID common.Hash) bool {
+	s, err := nw.State(i)
+	if err != nil {
+		return false
+	}
+	for _, id := range s.MsgIDs {
+		if id == msgID {
+			return true
+		}
+	}
+	return false
+}
+
+// Converged return true if the nodes (all nodes if not set) have the same
+// state, which contain at least minMsgs messages.
+func (nw *Network) Converged(minMsgs int, nodes ...int) bool {
+	var first *State
+	for _, i := range nw.indexes(nodes) {
+		s, err := nw.State(i)
+		if err != nil || len(s.MsgIDs) < minMsgs {
+			return false
+		}
+		if first == nil {
+			first = s
+		} else if !s.Equal(first) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexes return the nodes, or index of all nodes if empty
+func (nw *Network) indexes(nodes []int) []int {
+	if len(nodes) == 0 {
+		for i := range nw.Nodes {
+			nodes = append(nodes, i)
+		}
+	}
+	return nodes
+}
+
+// WaitConverged wait until the nodes (all nodes if not set) converged, or timeout
+func (nw *Network) WaitConverged(minMsgs int, timeout time.Duration, nodes ...int) bool {
+	for deadline := time.Now().Add(timeout); ; time.Sleep(pollInterval) {
+		if nw.Converged(minMsgs, nodes...) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+	}
+}
+
+// AssertConverged fail the test if the nodes (all nodes if not set) not converged
+// before timeout, the states of nodes are reported.
+func (nw *Network) AssertConverged(t testing.TB, minMsgs int, timeout time.Duration, nodes ...int) {
+	t.Helper()
+	if nw.WaitConverged(minMsgs, timeout, nodes...) {
+		return
+	}
+	var lines []string
+	for _, i := range nw.indexes(nodes) {
+		if s, err := nw.State(i); err != nil {
+			lines = append(lines, fmt.Sprintf("node %d : %s", i, err))
+		} else {
+			lines = append(lines, fmt.Sprintf("node %d : %s", i, s))
+		}
+	}
+	t.Errorf("nodes not converged with at least %d msgs in %s\n%s", minMsgs, timeout, strings.Join(lines, "\n"))
+}
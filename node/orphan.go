@@ -0,0 +1,202 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+// OrphanStats is the statistics of orphan pool
+type OrphanStats struct {
+	Count    int    `json:"count"`
+	Missing  int    `json:"missing"`
+	Added    uint64 `json:"added"`
+	Resolved uint64 `json:"resolved"`
+	Expired  uint64 `json:"expired"`
+	Evicted  uint64 `json:"evicted"`
+}
+
+// orphan is the message waiting for its references
+type orphan struct {
+	msg     *core.Message
+	pid     common.Hash
+	missing []common.Hash
+	added   time.Time
+	elem    *list.Element
+}
+
+// orphanPool keep the messages which references not exist yet, the orphans
+// are indexed by the missing references, so they can be retried once the
+// references arrive. The orphans are kept in the order of added, so the
+// oldest orphan can be evicted if the pool is full, the orphans waiting for
+// the references being requested are kept, because they are the front of sync.
+type orphanPool struct {
+	maxSize   int
+	maxAge    time.Duration
+	requested func(refID common.Hash) bool
+	orphans   map[common.Hash]*orphan
+	waiting   map[common.Hash][]common.Hash
+	order     *list.List
+	stats     OrphanStats
+}
+
+// newOrphanPool create the orphan pool, requested return true if the reference
+// is being requested from peer, nil if no reference is requested
+func newOrphanPool(maxSize int, maxAge time.Duration, requested func(refID common.Hash) bool) *orphanPool {
+	return &orphanPool{
+		maxSize:   maxSize,
+		maxAge:    maxAge,
+		requested: requested,
+		orphans:   make(map[common.Hash]*orphan),
+		waiting:   make(map[common.Hash][]common.Hash),
+		order:     list.New(),
+	}
+}
+
+// add the msg into pool, indexed by the missing references
+func (op *orphanPool) add(msg *core.Message, pid common.Hash, missing []common.Hash) {
+	id := msg.ID()
+	if _, ok := op.orphans[id]; ok {
+		return
+	}
+	if len(op.orphans) >= op.maxSize {
+		op.evict()
+	}
+	o := &orphan{msg: msg, pid: pid, missing: missing, added: time.Now()}
+	o.elem = op.order.PushBack(id)
+	op.orphans[id] = o
+	for _, ref := range missing {
+		op.waiting[ref] = append(op.waiting[ref], id)
+	}
+	op.stats.Added++
+}
+
+// has return true if the msg is in pool
+func (op *orphanPool) has(msgID common.Hash) bool {
+	_, ok := op.orphans[msgID]
+	return ok
+}
+
+// resolve remove the msg from pool, because it be saved or no longer valid
+func (op *orphanPool) resolve(msgID common.Hash) {
+	if op.remove(msgID) {
+		op.stats.Resolved++
+	}
+}
+
+// children return the orphans waiting for the reference, the index of
+// this reference is removed, orphans still in the pool are indexed by
+// other references if any missing.
+func (op *orphanPool) children(refID common.Hash) []*core.Message {
+	var msgs []*core.Message
+	for _, id := range op.waiting[refID] {
+		if o, ok := op.orphans[id]; ok {
+			msgs = append(msgs, o.msg)
+		}
+	}
+	delete(op.waiting, refID)
+	return msgs
+}
+
+// missing return the references which orphans are waiting for
+func (op *orphanPool) missing() []common.Hash {
+	var refs []common.Hash
+	for ref := range op.waiting {
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// expire remove the orphans added before maxAge
+func (op *orphanPool) expire(now time.Time) int {
+	cnt := 0
+	for e := op.order.Front(); e != nil; e = op.order.Front() {
+		id := e.Value.(common.Hash)
+		if now.Sub(op.orphans[id].added) <= op.maxAge {
+			break
+		}
+		op.remove(id)
+		cnt++
+	}
+	op.stats.Expired += uint64(cnt)
+	return cnt
+}
+
+// evict remove the oldest orphan which not waiting for the references being
+// requested, or the oldest one if all of them are waiting
+func (op *orphanPool) evict() {
+	e := op.order.Front()
+	for o := e; o != nil && op.requested != nil; o = o.Next() {
+		if !op.waitingRequested(op.orphans[o.Value.(common.Hash)]) {
+			e = o
+			break
+		}
+	}
+	if e != nil && op.remove(e.Value.(common.Hash)) {
+		op.stats.Evicted++
+	}
+}
+
+// waitingRequested return true if any missing reference of orphan is being requested
+func (op *orphanPool) waitingRequested(o *orphan) bool {
+	for _, ref := range o.missing {
+		if op.requested(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// remove the orphan from pool, and from the index of its missing references
+func (op *orphanPool) remove(msgID common.Hash) bool {
+	o, ok := op.orphans[msgID]
+	if !ok {
+		return false
+	}
+	delete(op.orphans, msgID)
+	op.order.Remove(o.elem)
+	for _, ref := range o.missing {
+		ids, ok := op.waiting[ref]
+		if !ok {
+			continue
+		}
+		var left []common.Hash
+		for _, id := range ids {
+			if id != msgID {
+				left = append(left, id)
+			}
+		}
+		if len(left) == 0 {
+			delete(op.waiting, ref)
+		} else {
+			op.waiting[ref] = left
+		}
+	}
+	return true
+}
+
+// Stats return the statistics of pool
+func (op *orphanPool) Stats() OrphanStats {
+	stats := op.stats
+	stats.Count = len(op.orphans)
+	stats.Missing = len(op.waiting)
+	return stats
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+)
+
+func createOrphanMsgs(t *testing.T, cnt int) []*core.Message {
+	users, priKeys, _ := createTestUniverse(t)
+	var msgs []*core.Message
+	for i := 0; i < cnt; i++ {
+		msg, err := core.CreateMsg(users[0], &core.MsgValue{ContentType: core.TypeText, Content: []byte(fmt.Sprintf("orphan %d", i))}, priKeys[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func checkOrphanStats(t *testing.T, name string, op *orphanPool, expect OrphanStats) {
+	if stats := op.Stats(); stats != expect {
+		t.Errorf("%s : stats should be %+v, but %+v", name, expect, stats)
+	}
+}
+
+func TestOrphanPool(t *testing.T) {
+	msgs := createOrphanMsgs(t, 4)
+	pid := common.CreateHash()
+	refA, refB, refC := common.CreateHash(), common.CreateHash(), common.CreateHash()
+	op := newOrphanPool(3, time.Minute, nil)
+
+	op.add(msgs[0], pid, []common.Hash{refA, refB})
+	op.add(msgs[1], pid, []common.Hash{refA})
+	op.add(msgs[1], pid, []common.Hash{refA})
+	if !op.has(msgs[0].ID()) || !op.has(msgs[1].ID()) || op.has(msgs[2].ID()) {
+		t.Error("orphans in pool not match")
+	}
+	checkOrphanStats(t, "add", op, OrphanStats{Count: 2, Missing: 2, Added: 2})
+
+	// children remove the index of reference, orphan is still indexed by others
+	if children := op.children(refA); len(children) != 2 || children[0].ID() != msgs[0].ID() || children[1].ID() != msgs[1].ID() {
+		t.Error("children of reference not match")
+	}
+	if children := op.children(refA); len(children) != 0 {
+		t.Error("index of reference should be removed")
+	}
+	op.resolve(msgs[1].ID())
+	checkOrphanStats(t, "children", op, OrphanStats{Count: 1, Missing: 1, Added: 2, Resolved: 1})
+
+	// the oldest orphan is evicted and removed from index
+	op.add(msgs[1], pid, []common.Hash{refC})
+	op.add(msgs[2], pid, []common.Hash{refC})
+	op.add(msgs[3], pid, []common.Hash{refC})
+	if op.has(msgs[0].ID()) {
+		t.Error("oldest orphan should be evicted")
+	}
+	if _, ok := op.waiting[refB]; ok {
+		t.Error("evicted orphan should be removed from index")
+	}
+	checkOrphanStats(t, "evict", op, OrphanStats{Count: 3, Missing: 1, Added: 5, Resolved: 1, Evicted: 1})
+
+	// all orphans expired, no index left
+	if cnt := op.expire(time.Now()); cnt != 0 {
+		t.Errorf("expired should be %d, but %d", 0, cnt)
+	}
+	if cnt := op.expire(time.Now().Add(2 * time.Minute)); cnt != 3 {
+		t.Errorf("expired should be %d, but %d", 3, cnt)
+	}
+	if len(op.missing()) != 0 || len(op.waiting) != 0 || op.order.Len() != 0 {
+		t.Error("index should be empty after all orphans expired")
+	}
+	checkOrphanStats(t, "expire", op, OrphanStats{Added: 5, Resolved: 1, Evicted: 1, Expired: 3})
+}
+
+func TestOrphanPool_EvictRequested(t *testing.T) {
+	msgs := createOrphanMsgs(t, 5)
+	pid := common.CreateHash()
+	refA, refB, refC := common.CreateHash(), common.CreateHash(), common.CreateHash()
+	requested := map[common.Hash]bool{refA: true}
+	op := newOrphanPool(3, time.Minute, func(refID common.Hash) bool { return requested[refID] })
+
+	// the oldest orphan waiting for requested reference is kept
+	op.add(msgs[0], pid, []common.Hash{refA})
+	op.add(msgs[1], pid, []common.Hash{refB})
+	op.add(msgs[2], pid, []common.Hash{refB, refA})
+	op.add(msgs[3], pid, []common.Hash{refC})
+	if !op.has(msgs[0].ID()) || op.has(msgs[1].ID()) || !op.has(msgs[2].ID()) {
+		t.Error("orphan not waiting for requested reference should be evicted")
+	}
+
+	// all orphans waiting for requested references, the oldest is evicted
+	requested[refC] = true
+	op.add(msgs[4], pid, []common.Hash{refC})
+	if op.has(msgs[0].ID()) || !op.has(msgs[4].ID()) {
+		t.Error("oldest orphan should be evicted")
+	}
+	checkOrphanStats(t, "evict", op, OrphanStats{Count: 3, Missing: 3, Added: 5, Evicted: 2})
+}
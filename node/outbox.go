@@ -0,0 +1,74 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/peer"
+	"golang.org/x/net/websocket"
+)
+
+// outWave is the wave queued while node is locked, it is sent by the copy of
+// peer after the lock released, so a slow peer can not block the node.
+type outWave struct {
+	pid  common.Hash
+	conn *websocket.Conn
+	p    *peer.Peer
+	send func(p *peer.Peer) error
+}
+
+// queueWave queue the wave to peer, the peer is copied so the wave is sent
+// by the state of peer when queued. Must be called with n.mu held.
+func (n *Node) queueWave(pid common.Hash, p *peer.Peer, send func(p *peer.Peer) error) {
+	if p == nil || !p.Connected() {
+		return
+	}
+	cp := *p
+	n.outbox = append(n.outbox, &outWave{pid: pid, conn: p.Conn, p: &cp, send: send})
+}
+
+// unlock release n.mu and send the waves queued while locked
+func (n *Node) unlock() {
+	out := n.outbox
+	n.outbox = nil
+	n.mu.Unlock()
+	n.sendWaves(out)
+}
+
+// sendWaves send the waves without lock, the peer whose connection fail is
+// marked disconnected, and will be dialed again in next loop.
+func (n *Node) sendWaves(out []*outWave) {
+	var failed []*outWave
+	for _, w := range out {
+		if err := w.send(w.p); err != nil {
+			log.Error("Send wave fail", err)
+			failed = append(failed, w)
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, w := range failed {
+		// the connection may be changed after the wave queued
+		if p, ok := n.peers[w.pid]; ok && p.Conn == w.conn {
+			p.Conn = nil
+		}
+	}
+}
@@ -16,10 +16,42 @@
 
 package node
 
+import "time"
+
 const (
 	// DefaultTimeProofInterval is the default interval for time proof message
 	DefaultTimeProofInterval = 1 // 1 seconds
 
 	// DefaultLocalPort is the default port of local serve
 	DefaultLocalPort = 8341
+
+	// DefaultAPIPort is the default port of local api
+	DefaultAPIPort = 8342
+
+	// DefaultAPIHost is the default host of local api, only the local requests are accepted
+	DefaultAPIHost = "127.0.0.1"
+
+	// DefaultCheckInterval is the default interval of checking and syncing from peers
+	DefaultCheckInterval = 10 * time.Second
+
+	// DefaultShutdownTimeout is the default time to wait the local api requests when node stop
+	DefaultShutdownTimeout = 5 * time.Second
+
+	// DefaultSnapshotInterval is the default number of messages between two snapshots of universe
+	DefaultSnapshotInterval = 1000
+
+	// DefaultOrphanPoolSize is the default max number of messages in orphan pool
+	DefaultOrphanPoolSize = 4096
+
+	// DefaultOrphanMaxAge is the default time of message can wait in orphan pool
+	DefaultOrphanMaxAge = 10 * time.Minute
+
+	// DefaultAbsentSetSize is the default max number of msg IDs marked as absent
+	DefaultAbsentSetSize = 4096
+
+	// DefaultAbsentPeers is the default number of peers which must miss the msg before it is absent
+	DefaultAbsentPeers = 2
+
+	// DefaultAbsentTTL is the default time of the absent mark be kept
+	DefaultAbsentTTL = 10 * time.Minute
 )
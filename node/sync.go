@@ -0,0 +1,182 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"time"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/common/log"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+	"github.com/pdupub/go-pdu/galaxy"
+)
+
+// knownMsg return true if the message is in universe, or waiting for its references
+func (n *Node) knownMsg(msgID common.Hash) bool {
+	if n.orphans.has(msgID) {
+		return true
+	}
+	return n.universe.GetMsgByID(msgID) != nil
+}
+
+// msgRequested return true if the msg is asked from peer and not responded yet
+func (n *Node) msgRequested(msgID common.Hash) bool {
+	_, ok := n.requestedMsgs[msgID]
+	return ok
+}
+
+// missingRefs return the references of msg which not exist in universe,
+// the reference which can not be found from peer is not missing.
+func (n *Node) missingRefs(msg *core.Message) []common.Hash {
+	var missing []common.Hash
+	for _, r := range msg.Reference {
+		if n.universe.GetMsgByID(r.MsgID) == nil && !n.absentMsgs.absent(r.MsgID, time.Now()) {
+			missing = append(missing, r.MsgID)
+		}
+	}
+	return missing
+}
+
+// receiveMsg save the msg if all references exist, or keep the msg in orphan
+// pool and ask the missing references from peer.
+func (n *Node) receiveMsg(pid common.Hash, msg *core.Message) error {
+	if n.knownMsg(msg.ID()) {
+		return nil
+	}
+	if missing := n.missingRefs(msg); len(missing) > 0 {
+		n.orphans.add(msg, pid, missing)
+		var unknown []common.Hash
+		for _, id := range missing {
+			if !n.knownMsg(id) {
+				unknown = append(unknown, id)
+			}
+		}
+		return n.askMissingMsgs(pid, unknown)
+	}
+	if err := n.saveMsg(msg); err != nil {
+		return err
+	}
+	n.broadcastMsg(msg)
+	n.processOrphans(msg.ID())
+	return nil
+}
+
+// processOrphans retry the orphans waiting for the messages, and the
+// orphans waiting for them, until no more orphan can be saved. The saved
+// orphans are not broadcast, peers sync them by tips.
+func (n *Node) processOrphans(msgIDs ...common.Hash) {
+	for queue := msgIDs; len(queue) > 0; queue = queue[1:] {
+		for _, msg := range n.orphans.children(queue[0]) {
+			if len(n.missingRefs(msg)) > 0 {
+				continue
+			}
+			n.orphans.resolve(msg.ID())
+			if err := n.saveMsg(msg); err != nil {
+				log.Error("Save orphan message fail", common.Hash2String(msg.ID()), err)
+				continue
+			}
+			queue = append(queue, msg.ID())
+		}
+	}
+}
+
+// ancestorMsgs return at most max msgs of msgIDs and their ancestors, the
+// ancestors are found by references, and returned before their descendants,
+// so the msgs can be saved in order by the receiver.
+func (n *Node) ancestorMsgs(msgIDs []common.Hash, max int) ([]*core.Message, error) {
+	var msgs []*core.Message
+	seen := make(map[common.Hash]bool)
+	for queue := append([]common.Hash{}, msgIDs...); len(queue) > 0 && len(msgs) < max; queue = queue[1:] {
+		if seen[queue[0]] {
+			continue
+		}
+		seen[queue[0]] = true
+		msg, err := db.GetMsgByID(n.udb, queue[0])
+		if err == db.ErrMessageNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+		for _, r := range msg.Reference {
+			queue = append(queue, r.MsgID)
+		}
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+// markAbsentMsgs mark the msg.ID asked but not be responded by peer, the msg
+// become absent once enough peers miss it, and the message which reference
+// them can be saved without them. The newly absent msg.IDs are returned.
+func (n *Node) markAbsentMsgs(waveID common.Hash, received map[common.Hash]bool) (absent []common.Hash) {
+	r, ok := n.questionRecord[waveID]
+	if !ok {
+		return nil
+	}
+	for _, id := range r.msgIDs {
+		if !received[id] && n.universe.GetMsgByID(id) == nil {
+			if n.absentMsgs.mark(id, r.pid, time.Now()) {
+				absent = append(absent, id)
+			}
+		}
+	}
+	return absent
+}
+
+// missingOrphanRefs return the references which orphans are waiting for and
+// not asked from any peer yet.
+func (n *Node) missingOrphanRefs() []common.Hash {
+	var refs []common.Hash
+	for _, id := range n.orphans.missing() {
+		if !n.knownMsg(id) && !n.absentMsgs.absent(id, time.Now()) {
+			refs = append(refs, id)
+		}
+	}
+	return refs
+}
+
+// OrphanStats return the statistics of orphan pool
+func (n *Node) OrphanStats() OrphanStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.orphans.Stats()
+}
+
+// askMissingMsgs ask the missing messages with their ancestors from peer,
+// any other peer can be used if the message not come from peers.
+func (n *Node) askMissingMsgs(pid common.Hash, msgIDs []common.Hash) error {
+	if len(msgIDs) == 0 {
+		return nil
+	}
+	if p, ok := n.peers[pid]; !ok || !p.Connected() {
+		pid = common.Hash{}
+		for k, p := range n.peers {
+			if p.Handshaked() && p.HasCapability(galaxy.CapMessages) && (p.Verified || !n.msgVerifiedOnly) {
+				pid = k
+				break
+			}
+		}
+		if pid == (common.Hash{}) {
+			return nil
+		}
+	}
+	return n.askAncestors(pid, msgIDs)
+}
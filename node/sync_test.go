@@ -0,0 +1,62 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pdupub/go-pdu/common"
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/db"
+)
+
+func TestNode_AncestorMsgs(t *testing.T) {
+	users, priKeys, first := createTestUniverse(t)
+	n := newTestNode(t, users, first, testConfig(nil, nil))
+	// chain of msgs, each one reference the one before
+	chain := []*core.Message{first}
+	for i := 0; i < 5; i++ {
+		last := chain[len(chain)-1]
+		msg, err := core.CreateMsg(users[0], &core.MsgValue{ContentType: core.TypeText, Content: []byte(fmt.Sprintf("msg %d", i))}, priKeys[0],
+			&core.MsgReference{SenderID: users[0].ID(), MsgID: last.ID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveMsg(n.udb, msg); err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, msg)
+	}
+	tip := chain[len(chain)-1].ID()
+
+	// ancestors are before descendants, limited by max
+	msgs, err := n.ancestorMsgs([]common.Hash{tip, tip}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 || msgs[0].ID() != chain[3].ID() || msgs[1].ID() != chain[4].ID() || msgs[2].ID() != tip {
+		t.Error("ancestors not match", len(msgs))
+	}
+	// all ancestors found, the msg not exist is skipped
+	if msgs, err = n.ancestorMsgs([]common.Hash{common.CreateHash(), tip}, 10); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != len(chain) || msgs[0].ID() != first.ID() {
+		t.Error("all ancestors should be found", len(msgs))
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 The PDU Authors
+// This file is part of the PDU library.
+//
+// The PDU library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The PDU library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the PDU library. If not, see <http://www.gnu.org/licenses/>.
+
+package peer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
+	"github.com/pdupub/go-pdu/crypto/utils"
+)
+
+// NonceSize is the size of nonce used in user challenge
+const NonceSize = 32
+
+var (
+	// ErrUserNotMatch is returned if the user in response is not the user claimed by peer
+	ErrUserNotMatch = errors.New("user not match")
+
+	// ErrChallengeFail is returned if the signature of challenge is not valid
+	ErrChallengeFail = errors.New("challenge fail")
+)
+
+// CreateNonce create the random nonce for user challenge
+func CreateNonce() ([]byte, error) {
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// challengeHash return the hash need to be signed for challenge,
+// node key of the responder is included, so the signature can not be relayed by other node.
+func challengeHash(nonce []byte, nodeKey string) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, nonce...), nodeKey...))
+	return hash[:]
+}
+
+// SignChallenge sign the nonce and local node key by the private key of user
+func SignChallenge(nonce []byte, nodeKey string, priKey *crypto.PrivateKey) (*crypto.Signature, error) {
+	engine, err := utils.SelectEngine(priKey.Source)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := engine.Sign(challengeHash(nonce, nodeKey), priKey)
+	if err != nil {
+		return nil, err
+	}
+	// public key is not necessary, verify by auth of user
+	sig.PubKey = nil
+	return sig, nil
+}
+
+// VerifyChallenge verify the response of challenge by the auth of user claimed by peer,
+// set the peer verified if success
+func (p *Peer) VerifyChallenge(nonce []byte, user *core.User, sig *crypto.Signature) error {
+	if user == nil || user.ID() != p.UserID || user.Auth == nil {
+		return ErrUserNotMatch
+	}
+	if res, err := user.Auth.Verify(challengeHash(nonce, p.NodeKey), sig); err != nil || !res {
+		return ErrChallengeFail
+	}
+	p.SetVerified()
+	return nil
+}
@@ -25,7 +25,9 @@ import (
 
 	"github.com/pdupub/go-pdu/common"
 	"github.com/pdupub/go-pdu/core"
+	"github.com/pdupub/go-pdu/crypto"
 	"github.com/pdupub/go-pdu/galaxy"
+	"github.com/pdupub/go-pdu/params"
 	"golang.org/x/net/websocket"
 )
 
@@ -36,8 +38,12 @@ var (
 )
 
 const (
-	// MaxMsgCountPerWave is the max number of msg per wave
-	MaxMsgCountPerWave = 2
+	// MaxMsgCountPerWave is the max number of msg per wave,
+	// also the max number of msg.ID asked in one question
+	MaxMsgCountPerWave = 16
+
+	// MaxTipsPerWave is the max number of tips per wave
+	MaxTipsPerWave = 64
 )
 
 // Peer contain the info of websocket connection
@@ -48,6 +54,11 @@ type Peer struct {
 	UserID   common.Hash `json:"userID"`
 	Verified bool        `json:"verified"`
 	Conn     *websocket.Conn
+	magic    [galaxy.MagicSize]byte
+
+	handshaked   bool
+	version      string
+	capabilities []string
 }
 
 // New create new Peer
@@ -71,6 +82,38 @@ func (p *Peer) SetUserID(userID common.Hash) {
 	}
 }
 
+// SetMagic set the network magic used in wave header
+func (p *Peer) SetMagic(magic [galaxy.MagicSize]byte) {
+	p.magic = magic
+}
+
+// SetHandshake set the version and capabilities negotiated with this peer
+func (p *Peer) SetHandshake(version string, capabilities []string) {
+	p.handshaked = true
+	p.version = version
+	p.capabilities = capabilities
+}
+
+// Handshaked return true if the version handshake is finished
+func (p *Peer) Handshaked() bool {
+	return p.handshaked
+}
+
+// Version return the version of remote node
+func (p *Peer) Version() string {
+	return p.version
+}
+
+// HasCapability return true if the capability is supported by both side
+func (p *Peer) HasCapability(capability string) bool {
+	for _, c := range p.capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
 // SetVerified set verified is true
 func (p *Peer) SetVerified() {
 	p.Verified = true
@@ -86,8 +129,10 @@ func (p *Peer) Dial() error {
 	return nil
 }
 
-// Close the ws connection,
+// Close the ws connection, handshake and verify is needed for next connection
 func (p *Peer) Close() error {
+	p.handshaked = false
+	p.Verified = false
 	if p.Conn != nil {
 		return p.Conn.Close()
 	}
@@ -113,8 +158,17 @@ func (p *Peer) Connected() bool {
 	return false
 }
 
+// Codec return the codec of waves and messages sent to this peer, core.CodecBinary
+// is used after handshake if it is supported by both side
+func (p *Peer) Codec() string {
+	if p.handshaked && p.HasCapability(galaxy.CapBinary) {
+		return core.CodecBinary
+	}
+	return core.CodecJSON
+}
+
 func (p *Peer) send(wave galaxy.Wave) error {
-	_, err := galaxy.SendWave(p.Conn, wave)
+	_, err := galaxy.SendWave(p.Conn, p.magic, wave, p.Codec())
 	if err != nil {
 		p.Conn = nil
 		return err
@@ -177,7 +231,7 @@ func (p *Peer) SendMsgs(waveID common.Hash, msgs []*core.Message) error {
 	}
 	var msgsB [][]byte
 	for _, msg := range msgs {
-		msgBytes, err := json.Marshal(msg)
+		msgBytes, err := core.MarshalMsg(msg, p.Codec())
 		if err != nil {
 			return err
 		}
@@ -219,6 +273,21 @@ func (p *Peer) SendPeers(waveID common.Hash, pm map[common.Hash]*Peer, localPeer
 	return p.send(wave)
 }
 
+// SendTips is used to send tips of local message dag
+func (p *Peer) SendTips(waveID common.Hash, msgIDs []common.Hash) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	if len(msgIDs) > MaxTipsPerWave {
+		msgIDs = msgIDs[:MaxTipsPerWave]
+	}
+	wave := &galaxy.WaveTips{
+		WaveID: waveID,
+		MsgIDs: msgIDs,
+	}
+	return p.send(wave)
+}
+
 // SendRoots is used to send 2 roots to peer
 func (p *Peer) SendRoots(waveID common.Hash, user0, user1 *core.User) error {
 	if !p.Connected() {
@@ -235,6 +304,35 @@ func (p *Peer) SendRoots(waveID common.Hash, user0, user1 *core.User) error {
 	return p.send(wave)
 }
 
+// SendVersion is used to send version of local node, first wave of each connection
+func (p *Peer) SendVersion(waveID common.Hash, roots [2]common.Hash, capabilities []string) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	wave := &galaxy.WaveVersion{
+		WaveID:       waveID,
+		Version:      params.Version,
+		Protocol:     galaxy.ProtocolVersion,
+		MinProtocol:  galaxy.MinProtocolVersion,
+		Roots:        roots,
+		Capabilities: capabilities,
+	}
+	return p.send(wave)
+}
+
+// SendUser is used to response the user challenge
+func (p *Peer) SendUser(waveID common.Hash, userID common.Hash, sig *crypto.Signature) error {
+	if !p.Connected() {
+		return errPeerNotReachable
+	}
+	wave := &galaxy.WaveUser{
+		WaveID:    waveID,
+		UserID:    userID,
+		Signature: sig,
+	}
+	return p.send(wave)
+}
+
 // SendPing is used for ping pong, send ping to peer
 func (p *Peer) SendPing(waveID common.Hash) error {
 	if !p.Connected() {